@@ -0,0 +1,161 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
+
+// Prefix is the prefix of every API key token
+const Prefix = "ubk_"
+
+// Actions API keys can be limited to
+const (
+	// ActionList allows listing students and their passwords
+	ActionList = "list"
+	// ActionReset allows resetting students' passwords
+	ActionReset = "reset"
+	// ActionApprovals allows listing approval requests
+	ActionApprovals = "approvals"
+)
+
+// Actions are all actions API keys can be limited to
+var Actions = []string{ActionList, ActionReset, ActionApprovals}
+
+// Key represents an API key. The secret part of the key is only stored as a hash.
+// The key can only be used for its Actions, or for every action if Actions is empty
+type Key struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Hash        string            `json:"hash"`
+	Permissions []auth.GradeRange `json:"permissions"`
+	Actions     []string          `json:"actions,omitempty"`
+	CreatedBy   string            `json:"created_by"`
+	Created     time.Time         `json:"created"`
+	Expires     time.Time         `json:"expires,omitempty"`
+	Revoked     time.Time         `json:"revoked,omitempty"`
+	LastUsed    time.Time         `json:"last_used,omitempty"`
+}
+
+// Valid returns true if the key isn't revoked or expired at time t
+func (k *Key) Valid(t time.Time) bool {
+	if !k.Revoked.IsZero() {
+		return false
+	}
+	return k.Expires.IsZero() || t.Before(k.Expires)
+}
+
+// Allows returns true if the key can be used for action
+func (k *Key) Allows(action string) bool {
+	if len(k.Actions) == 0 {
+		return true
+	}
+	for _, a := range k.Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidAction returns true if action is one of Actions
+func ValidAction(action string) bool {
+	for _, a := range Actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Check returns true if secret matches the key's hash
+func (k *Key) Check(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash(secret)), []byte(k.Hash)) == 1
+}
+
+// User returns the User the key acts as
+func (k *Key) User() *auth.User {
+	return &auth.User{
+		Username:    "apikey:" + k.ID,
+		DisplayName: k.Name,
+		Permissions: k.Permissions,
+	}
+}
+
+func hash(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+func random(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Generate returns a new Key and the token to give to the client. The token can't be recovered from the Key.
+// If actions is empty, the key can be used for every action
+func Generate(name string, permissions []auth.GradeRange, actions []string, expires time.Time, createdBy string) (token string, key *Key, err error) {
+	b := make([]byte, 6)
+	if _, err = rand.Read(b); err != nil {
+		return "", nil, fmt.Errorf("Unable to generate id: %v", err)
+	}
+	id := hex.EncodeToString(b)
+
+	secret, err := random(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("Unable to generate secret: %v", err)
+	}
+
+	key = &Key{
+		ID:          id,
+		Name:        name,
+		Hash:        hash(secret),
+		Permissions: permissions,
+		Actions:     actions,
+		CreatedBy:   createdBy,
+		Created:     time.Now(),
+		Expires:     expires,
+	}
+
+	return Prefix + id + "_" + secret, key, nil
+}
+
+// Parse splits a token into its id and secret. ok is false if the token is malformed
+func Parse(token string) (id, secret string, ok bool) {
+	if !strings.HasPrefix(token, Prefix) {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(token, Prefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	return parts[0], parts[1], true
+}
+
+// Store is an API key storage mechanism
+type Store interface {
+	// Add stores a new key or returns an error if one occurred
+	Add(k *Key) error
+	// Get returns the key with the given id or nil if it doesn't exist,
+	// or an error if one occurred
+	Get(id string) (*Key, error)
+	// List returns all keys or an error if one occurred
+	List() ([]*Key, error)
+	// Update replaces the stored key with the same ID or returns an error if one occurred
+	Update(k *Key) error
+	// Used records that the key with the given id was used at t, or returns an error if one occurred.
+	// Stores may save the time later with other changes
+	Used(id string, t time.Time) error
+}
@@ -0,0 +1,77 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
+
+func TestGenerate(t *testing.T) {
+	token, key, err := Generate("sis", []auth.GradeRange{{MinGrade: 9, MaxGrade: 12}}, []string{ActionList}, time.Time{}, "admin")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, secret, ok := Parse(token)
+	if !ok || id != key.ID {
+		t.Fatalf("unable to parse token %s", token)
+	}
+	if !key.Check(secret) || key.Check(secret+"x") || strings.Contains(key.Hash, secret) {
+		t.Error("unexpected secret check")
+	}
+
+	u := key.User()
+	if u.Username != "apikey:"+key.ID || u.DisplayName != "sis" || len(u.Permissions) != 1 || u.Admin {
+		t.Errorf("unexpected user: %+v", u)
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, token := range []string{"", "ubk_", "ubk_id", "ubk_id_", "ubk__secret", "xyz_id_secret"} {
+		if _, _, ok := Parse(token); ok {
+			t.Errorf("%q: expected malformed token", token)
+		}
+	}
+
+	if id, secret, ok := Parse("ubk_0123456789ab_se_cret"); !ok || id != "0123456789ab" || secret != "se_cret" {
+		t.Errorf("unexpected parse: %s, %s, %t", id, secret, ok)
+	}
+}
+
+func TestValid(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		key   *Key
+		valid bool
+	}{
+		{&Key{}, true},
+		{&Key{Expires: now.Add(time.Hour)}, true},
+		{&Key{Expires: now}, false},
+		{&Key{Revoked: now.Add(-time.Hour)}, false},
+	}
+	for i, c := range cases {
+		if c.key.Valid(now) != c.valid {
+			t.Errorf("case %d: expected valid to be %t", i, c.valid)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	all := &Key{}
+	for _, a := range Actions {
+		if !all.Allows(a) {
+			t.Errorf("key without actions doesn't allow %s", a)
+		}
+	}
+
+	k := &Key{Actions: []string{ActionList, ActionApprovals}}
+	if !k.Allows(ActionList) || !k.Allows(ActionApprovals) || k.Allows(ActionReset) {
+		t.Errorf("unexpected actions allowed: %v", k.Actions)
+	}
+
+	if !ValidAction(ActionReset) || ValidAction("delete") || ValidAction("") {
+		t.Error("unexpected action validation")
+	}
+}
@@ -0,0 +1,20 @@
+package file
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const lockfileExclusiveLock = 0x2
+
+// lockFile blocks until an exclusive lock on f is acquired. The lock is released when f is closed
+func lockFile(f *os.File) error {
+	if r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock, 0, 1, 0,
+		uintptr(unsafe.Pointer(new(syscall.Overlapped)))); r == 0 {
+		return err
+	}
+	return nil
+}
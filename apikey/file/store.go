@@ -0,0 +1,306 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+)
+
+// Store represents a Store that persists keys to a JSON file. Keys are kept in memory and only read again if the file
+// is changed by another process, e.g. userbrowser-admin. Last used times are saved with the next change, or by Run
+type Store struct {
+	path string
+	mu   *sync.Mutex
+
+	keys    map[string]*apikey.Key
+	modTime time.Time
+	size    int64
+	// used are the last used times not yet saved
+	used map[string]time.Time
+}
+
+// New returns a new *Store using the file at path
+func New(path string) *Store {
+	return &Store{path: path, mu: new(sync.Mutex), used: make(map[string]time.Time)}
+}
+
+func (s *Store) read() (map[string]*apikey.Key, error) {
+	keys := make(map[string]*apikey.Key)
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return keys, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&keys); err != nil {
+		return nil, fmt.Errorf("Unable to decode %s: %v", s.path, err)
+	}
+
+	return keys, nil
+}
+
+// stat returns the modification time and size of the file, or zero values if it doesn't exist
+func (s *Store) stat() (time.Time, int64, error) {
+	fi, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return time.Time{}, 0, nil
+	} else if err != nil {
+		return time.Time{}, 0, fmt.Errorf("Unable to stat %s: %v", s.path, err)
+	}
+	return fi.ModTime(), fi.Size(), nil
+}
+
+// lock takes an exclusive lock on a file next to the keys, so changes made by other processes, e.g. userbrowser-admin,
+// aren't lost between reading and writing the keys. The returned function releases the lock
+func (s *Store) lock() (func(), error) {
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open lock file: %v", err)
+	}
+
+	if err = lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Unable to lock %s: %v", f.Name(), err)
+	}
+
+	return func() { f.Close() }, nil
+}
+
+// load reads the keys if they haven't been read or the file has changed since. The caller must hold s.mu
+func (s *Store) load() (map[string]*apikey.Key, error) {
+	modTime, size, err := s.stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.keys != nil && modTime.Equal(s.modTime) && size == s.size {
+		return s.keys, nil
+	}
+
+	keys, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for id, t := range s.used {
+		if k, ok := keys[id]; ok && t.After(k.LastUsed) {
+			k.LastUsed = t
+		}
+	}
+
+	s.keys, s.modTime, s.size = keys, modTime, size
+
+	return keys, nil
+}
+
+// write writes keys, including unsaved last used times. The caller must hold s.mu and the lock from s.lock,
+// taken before the keys were loaded
+func (s *Store) write(keys map[string]*apikey.Key) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(keys); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to encode keys: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", s.path, err)
+	}
+
+	modTime, size, err := s.stat()
+	if err != nil {
+		// the keys are read again on the next call
+		s.keys = nil
+		return nil
+	}
+
+	s.keys, s.modTime, s.size = keys, modTime, size
+	s.used = make(map[string]time.Time)
+
+	return nil
+}
+
+// Add stores a new key or returns an error if one occurred
+func (s *Store) Add(k *apikey.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := keys[k.ID]; ok {
+		return fmt.Errorf("Key %s already exists", k.ID)
+	}
+
+	// copy on write, so a failed write doesn't change the loaded keys
+	updated := make(map[string]*apikey.Key, len(keys)+1)
+	for id, key := range keys {
+		updated[id] = key
+	}
+	key := *k
+	updated[k.ID] = &key
+
+	return s.write(updated)
+}
+
+// Get returns the key with the given id or nil if it doesn't exist,
+// or an error if one occurred
+func (s *Store) Get(id string) (*apikey.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	k, ok := keys[id]
+	if !ok {
+		return nil, nil
+	}
+
+	key := *k
+	return &key, nil
+}
+
+// List returns all keys, oldest first, or an error if one occurred
+func (s *Store) List() ([]*apikey.Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*apikey.Key, 0, len(keys))
+	for _, k := range keys {
+		key := *k
+		list = append(list, &key)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+
+	return list, nil
+}
+
+// Update replaces the stored key with the same ID or returns an error if one occurred.
+// A later last used time recorded with Used is kept
+func (s *Store) Update(k *apikey.Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	old, ok := keys[k.ID]
+	if !ok {
+		return errors.New("Key doesn't exist")
+	}
+
+	updated := make(map[string]*apikey.Key, len(keys))
+	for id, key := range keys {
+		updated[id] = key
+	}
+	key := *k
+	if old.LastUsed.After(key.LastUsed) {
+		key.LastUsed = old.LastUsed
+	}
+	updated[k.ID] = &key
+
+	return s.write(updated)
+}
+
+// Used records that the key with the given id was used at t, or returns an error if one occurred.
+// The time is saved with the next change, or by Run
+func (s *Store) Used(id string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	k, ok := keys[id]
+	if !ok {
+		return errors.New("Key doesn't exist")
+	}
+
+	if t.After(k.LastUsed) {
+		k.LastUsed = t
+		s.used[id] = t
+	}
+
+	return nil
+}
+
+// Flush saves the last used times recorded since the last change, or returns an error if one occurred
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.used) == 0 {
+		return nil
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	return s.write(keys)
+}
+
+// Run calls Flush every interval. Run never returns
+func (s *Store) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.Flush(); err != nil {
+			log.Println("Unable to save API key last used times:", err)
+		}
+	}
+}
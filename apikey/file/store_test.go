@@ -0,0 +1,242 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
+
+func newKey(t *testing.T) *apikey.Key {
+	t.Helper()
+	_, k, err := apikey.Generate("key", []auth.GradeRange{{MinGrade: 1, MaxGrade: 5}}, nil, time.Time{}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	s := New(path)
+
+	k1, k2 := newKey(t), newKey(t)
+	k2.Created = k1.Created.Add(time.Second)
+	for _, k := range []*apikey.Key{k2, k1} {
+		if err := s.Add(k); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Add(k1); err == nil {
+		t.Error("expected error for duplicate key")
+	}
+
+	list, err := s.List()
+	if err != nil || len(list) != 2 || list[0].ID != k1.ID || list[1].ID != k2.ID {
+		t.Fatalf("unexpected list: %v, %v", list, err)
+	}
+
+	// keys returned can't change the store without Update
+	k, err := s.Get(k1.ID)
+	if err != nil || k == nil {
+		t.Fatalf("unexpected key: %v, %v", k, err)
+	}
+	k.Revoked = time.Now()
+	if k, _ = s.Get(k1.ID); !k.Revoked.IsZero() {
+		t.Error("store changed without Update")
+	}
+
+	k.Revoked = time.Now()
+	if err = s.Update(k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = s.Update(&apikey.Key{ID: "missing"}); err == nil {
+		t.Error("expected error for missing key")
+	}
+
+	// changes are persisted
+	if k, err = New(path).Get(k1.ID); err != nil || k == nil || k.Revoked.IsZero() {
+		t.Errorf("update not persisted: %v, %v", k, err)
+	}
+	if k, err = s.Get("missing"); err != nil || k != nil {
+		t.Errorf("unexpected key: %v, %v", k, err)
+	}
+}
+
+func TestStoreCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	s := New(path)
+
+	k := newKey(t)
+	if err := s.Add(k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the file isn't read again while its modification time and size are unchanged
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, []byte(strings.Repeat(" ", len(buf))), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := s.Get(k.ID); err != nil || got == nil {
+		t.Errorf("unexpected key: %v, %v", got, err)
+	}
+	if err = os.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// changes made by other processes are seen
+	other := New(path)
+	revoked, err := other.Get(k.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked.Revoked = time.Now()
+	revoked.Name = "a longer name, so the file size changes"
+	if err = other.Update(revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := s.Get(k.ID); err != nil || got.Revoked.IsZero() {
+		t.Errorf("external change not seen: %v, %v", got, err)
+	}
+}
+
+func TestStoreUsed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	s := New(path)
+
+	k := newKey(t)
+	if err := s.Add(k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now().Round(0)
+	if err := s.Used(k.ID, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Used("missing", now); err == nil {
+		t.Error("expected error for missing key")
+	}
+
+	// the time is returned immediately, but not written until Flush
+	if got, _ := s.Get(k.ID); !got.LastUsed.Equal(now) {
+		t.Errorf("expected last used %v, got %v", now, got.LastUsed)
+	}
+	if got, _ := New(path).Get(k.ID); !got.LastUsed.IsZero() {
+		t.Error("last used time written before Flush")
+	}
+
+	// earlier times don't replace later ones
+	if err := s.Used(k.ID, now.Add(-time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := New(path).Get(k.ID); !got.LastUsed.Equal(now) {
+		t.Errorf("expected last used %v, got %v", now, got.LastUsed)
+	}
+}
+
+func TestStoreUsedMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	s := New(path)
+
+	k := newKey(t)
+	if err := s.Add(k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// an update from a key read before it was used keeps the last used time
+	stale, _ := s.Get(k.ID)
+	now := time.Now().Round(0)
+	s.Used(k.ID, now)
+	stale.Name = "renamed"
+	if err := s.Update(stale); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, _ := New(path).Get(k.ID); got.Name != "renamed" || !got.LastUsed.Equal(now) {
+		t.Errorf("unexpected key: %+v", got)
+	}
+
+	// unsaved times are kept when another process changes the file
+	later := now.Add(time.Minute)
+	s.Used(k.ID, later)
+
+	other := New(path)
+	revoked, _ := other.Get(k.ID)
+	revoked.Revoked = time.Now()
+	revoked.Name = "revoked by another process"
+	if err := other.Update(revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := New(path).Get(k.ID)
+	if got.Revoked.IsZero() || !got.LastUsed.Equal(later) {
+		t.Errorf("unexpected key: %+v", got)
+	}
+}
+
+func TestStoreLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.json")
+	s := New(path)
+
+	k := newKey(t)
+	if err := s.Add(k); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Now().Round(0)
+	s.Used(k.ID, now)
+
+	// another process is part way through revoking the key
+	other := New(path)
+	unlock, err := other.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := other.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- s.Flush() }()
+	select {
+	case err := <-done:
+		t.Fatalf("Flush didn't wait for the lock: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	revoked := *keys[k.ID]
+	revoked.Revoked = time.Now()
+	if err = other.write(map[string]*apikey.Key{k.ID: &revoked}); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	if err = <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, _ := New(path).Get(k.ID)
+	if got.Revoked.IsZero() || !got.LastUsed.Equal(now) {
+		t.Errorf("unexpected key: %+v", got)
+	}
+}
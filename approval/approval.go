@@ -0,0 +1,110 @@
+// Package approval implements two-person approval for sensitive password resets
+package approval
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+)
+
+// Status is the status of a Request
+type Status string
+
+// Request statuses
+const (
+	StatusPending  Status = "pending"
+	StatusApproved Status = "approved"
+	StatusRejected Status = "rejected"
+	StatusExpired  Status = "expired"
+)
+
+// Request is a password reset waiting for, or decided by, a second staff member
+type Request struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Grade       int       `json:"grade"`
+	RequestedBy string    `json:"requested_by"`
+	Requested   time.Time `json:"requested"`
+	Expires     time.Time `json:"expires"`
+	Status      Status    `json:"status"`
+	DecidedBy   string    `json:"decided_by,omitempty"`
+	Decided     time.Time `json:"decided,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Expire marks the request expired if it's pending and past its expiration, returning true if it was changed
+func (r *Request) Expire(t time.Time) bool {
+	if r.Status != StatusPending || t.Before(r.Expires) {
+		return false
+	}
+	r.Status = StatusExpired
+	r.Decided = r.Expires
+	return true
+}
+
+// NewRequest returns a new pending request by requestedBy to reset user's password, expiring after expiration
+func NewRequest(user *db.User, requestedBy string, expiration time.Duration) (*Request, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	return &Request{
+		ID:          hex.EncodeToString(buf),
+		Username:    user.Username,
+		FirstName:   user.FirstName,
+		LastName:    user.LastName,
+		Grade:       user.Grade,
+		RequestedBy: requestedBy,
+		Requested:   now,
+		Expires:     now.Add(expiration),
+		Status:      StatusPending,
+	}, nil
+}
+
+// Policy determines which students' resets require approval
+type Policy struct {
+	// Grades are grade ranges that require approval
+	Grades []auth.GradeRange
+	// Groups are groups whose direct members require approval
+	Groups []string
+}
+
+// Required returns true if resetting the user's password requires approval
+func (p *Policy) Required(user *db.User) bool {
+	for _, r := range p.Grades {
+		if user.Grade >= r.MinGrade && user.Grade <= r.MaxGrade {
+			return true
+		}
+	}
+
+	for _, g := range p.Groups {
+		for _, ug := range user.Groups {
+			if strings.EqualFold(g, ug) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Store is a persistent store of requests. Implementations should mark expired requests with Request.Expire when reading them
+type Store interface {
+	// Add stores a new request or returns an error if one occurred
+	Add(r *Request) error
+	// Get returns the request with the given id or nil if it doesn't exist, or an error if one occurred
+	Get(id string) (*Request, error)
+	// List returns requests with the given status (or all if empty), newest first, or an error if one occurred
+	List(status Status) ([]*Request, error)
+	// Update replaces the stored request with the same ID or returns an error if one occurred
+	Update(r *Request) error
+}
@@ -0,0 +1,148 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/approval"
+)
+
+// Store represents a Store that persists requests to a JSON file
+type Store struct {
+	path string
+	mu   *sync.Mutex
+}
+
+// New returns a new *Store using the file at path
+func New(path string) *Store {
+	return &Store{path: path, mu: new(sync.Mutex)}
+}
+
+// read returns the stored requests, marking expired requests
+func (s *Store) read() (map[string]*approval.Request, error) {
+	requests := make(map[string]*approval.Request)
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return requests, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&requests); err != nil {
+		return nil, fmt.Errorf("Unable to decode %s: %v", s.path, err)
+	}
+
+	now := time.Now()
+	for _, r := range requests {
+		r.Expire(now)
+	}
+
+	return requests, nil
+}
+
+func (s *Store) write(requests map[string]*approval.Request) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(requests); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to encode requests: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// Add stores a new request or returns an error if one occurred
+func (s *Store) Add(r *approval.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := requests[r.ID]; ok {
+		return fmt.Errorf("Request %s already exists", r.ID)
+	}
+
+	requests[r.ID] = r
+
+	return s.write(requests)
+}
+
+// Get returns the request with the given id or nil if it doesn't exist,
+// or an error if one occurred
+func (s *Store) Get(id string) (*approval.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return requests[id], nil
+}
+
+// List returns requests with the given status (or all if empty), newest first, or an error if one occurred
+func (s *Store) List(status approval.Status) ([]*approval.Request, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*approval.Request, 0, len(requests))
+	for _, r := range requests {
+		if status == "" || r.Status == status {
+			list = append(list, r)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Requested.After(list[j].Requested)
+	})
+
+	return list, nil
+}
+
+// Update replaces the stored request with the same ID or returns an error if one occurred
+func (s *Store) Update(r *approval.Request) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := requests[r.ID]; !ok {
+		return errors.New("Request doesn't exist")
+	}
+
+	requests[r.ID] = r
+
+	return s.write(requests)
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Record represents a single audited action
+type Record struct {
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor,omitempty"`
+	APIKey    string    `json:"api_key,omitempty"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Result    int       `json:"result"`
+	Error     string    `json:"error,omitempty"`
+
+	// ApprovalID is the approval request the action created or decided
+	ApprovalID string `json:"approval_id,omitempty"`
+	// RequestedBy is the user that requested an approved or rejected reset
+	RequestedBy string `json:"requested_by,omitempty"`
+
+	// PrevHash is the Hash of the previous record in the log
+	PrevHash string `json:"prev_hash"`
+	// Hash is the SHA-256 hash of this record, including PrevHash
+	Hash string `json:"hash"`
+	// HMAC is the HMAC-SHA256 of Hash, if an HMAC key is configured
+	HMAC string `json:"hmac,omitempty"`
+}
+
+// Query represents a filter on Records. Zero values match everything
+type Query struct {
+	Actor  string
+	Target string
+	Action string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the maximum number of records returned. Zero means no limit
+	Limit int
+}
+
+// Match returns true if the record matches the query
+func (q *Query) Match(r *Record) bool {
+	if q.Actor != "" && !strings.EqualFold(q.Actor, r.Actor) {
+		return false
+	}
+	if q.Target != "" && !strings.EqualFold(q.Target, r.Target) {
+		return false
+	}
+	if q.Action != "" && !strings.EqualFold(q.Action, r.Action) {
+		return false
+	}
+	if !q.Since.IsZero() && r.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !r.Time.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Store is an audit record storage mechanism
+type Store interface {
+	// Append seals the record into the log's hash chain and stores it, or returns an error if one occurred.
+	// Stores may sync records to disk in batches, off the request path
+	Append(r *Record) error
+	// Query returns the records matching q, newest first, or an error if one occurred
+	Query(q *Query) ([]*Record, error)
+	// Purge removes all records older than before and returns the number removed,
+	// or an error if one occurred. A record of the purge is appended to the log
+	Purge(before time.Time) (int, error)
+}
+
+// Retain purges records older than retention from the store every interval. It never returns
+func Retain(s Store, retention, interval time.Duration) {
+	for {
+		n, err := s.Purge(time.Now().Add(-retention))
+		if err != nil {
+			log.Println("Unable to purge audit records:", err)
+		} else if n > 0 {
+			log.Printf("Purged %d audit records older than %v\n", n, retention)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ChainError describes the first record that breaks the hash chain
+type ChainError struct {
+	// Index is the zero-based position of the record in the log
+	Index int
+	// Record is nil if the chain is broken by records missing from the end of the log
+	Record *Record
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	if e.Record == nil {
+		return fmt.Sprintf("Audit chain broken after record %d: %s", e.Index, e.Reason)
+	}
+	return fmt.Sprintf("Audit chain broken at record %d (%s %s by %s): %s",
+		e.Index, e.Record.Time.Format("2006-01-02T15:04:05Z07:00"), e.Record.Action, e.Record.Actor, e.Reason)
+}
+
+// Checkpoint records both ends of the log, so records removed from its start or end are detected.
+// Without an HMAC key, anyone who can modify the log can also rewrite the checkpoint
+type Checkpoint struct {
+	// Start is the PrevHash of the first record in the log, which is empty until records are purged
+	Start string `json:"start"`
+	// Head is the Hash of the last record in the log when the checkpoint was written
+	Head string    `json:"head"`
+	Time time.Time `json:"time"`
+	// HMAC is the HMAC-SHA256 of the checkpoint's other fields, if an HMAC key is configured
+	HMAC string `json:"hmac,omitempty"`
+}
+
+func (c *Checkpoint) computeHMAC(key []byte) string {
+	return computeHMAC(key, c.Start+"."+c.Head+"."+c.Time.UTC().Format(time.RFC3339Nano))
+}
+
+// Sign sets the checkpoint's HMAC if key is non-empty
+func (c *Checkpoint) Sign(key []byte) {
+	c.HMAC = ""
+	if len(key) > 0 {
+		c.HMAC = c.computeHMAC(key)
+	}
+}
+
+// ComputeHash returns the SHA-256 hash of the record's contents, including PrevHash but excluding Hash and HMAC
+func (r *Record) ComputeHash() (string, error) {
+	c := *r
+	c.Hash = ""
+	c.HMAC = ""
+
+	buf, err := json.Marshal(&c)
+	if err != nil {
+		return "", fmt.Errorf("Unable to encode record: %v", err)
+	}
+
+	h := sha256.Sum256(buf)
+	return hex.EncodeToString(h[:]), nil
+}
+
+func computeHMAC(key []byte, hash string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(hash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Seal links the record to the previous record's hash and sets its Hash, and HMAC if key is non-empty
+func (r *Record) Seal(prevHash string, key []byte) error {
+	r.PrevHash = prevHash
+
+	hash, err := r.ComputeHash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+
+	r.HMAC = ""
+	if len(key) > 0 {
+		r.HMAC = computeHMAC(key, hash)
+	}
+
+	return nil
+}
+
+// Verifier checks a sequence of records, oldest first, for breaks in the hash chain
+type Verifier struct {
+	key        []byte
+	checkpoint *Checkpoint
+	prev       string
+	index      int
+	head       bool
+}
+
+// NewVerifier returns a new *Verifier that checks the log against checkpoint. If checkpoint is nil, the log must
+// start with the first record ever written. If key is non-empty, every record's HMAC and the checkpoint's HMAC are
+// also checked
+func NewVerifier(key []byte, checkpoint *Checkpoint) *Verifier {
+	if checkpoint == nil {
+		checkpoint = new(Checkpoint)
+	}
+	return &Verifier{key: key, checkpoint: checkpoint, prev: checkpoint.Start, head: checkpoint.Head == ""}
+}
+
+// checkCheckpoint returns a reason if the checkpoint's HMAC doesn't match
+func (v *Verifier) checkCheckpoint() string {
+	if len(v.key) == 0 || (v.checkpoint.Start == "" && v.checkpoint.Head == "") {
+		return ""
+	}
+	if !hmac.Equal([]byte(v.checkpoint.computeHMAC(v.key)), []byte(v.checkpoint.HMAC)) {
+		return "checkpoint HMAC doesn't match"
+	}
+	return ""
+}
+
+// Next verifies the next record in the sequence and returns a *ChainError if it breaks the chain
+func (v *Verifier) Next(r *Record) error {
+	idx := v.index
+
+	if idx == 0 {
+		if reason := v.checkCheckpoint(); reason != "" {
+			return &ChainError{Index: idx, Record: r, Reason: reason}
+		}
+		if r.PrevHash != v.prev {
+			return &ChainError{Index: idx, Record: r, Reason: "first record doesn't match checkpoint; records were removed from the start"}
+		}
+	} else if r.PrevHash != v.prev {
+		return &ChainError{Index: idx, Record: r, Reason: "previous hash doesn't match"}
+	}
+
+	hash, err := r.ComputeHash()
+	if err != nil {
+		return &ChainError{Index: idx, Record: r, Reason: err.Error()}
+	}
+
+	if hash != r.Hash {
+		return &ChainError{Index: idx, Record: r, Reason: "record hash doesn't match contents"}
+	}
+
+	if len(v.key) > 0 && !hmac.Equal([]byte(computeHMAC(v.key, hash)), []byte(r.HMAC)) {
+		return &ChainError{Index: idx, Record: r, Reason: "HMAC doesn't match"}
+	}
+
+	v.prev = r.Hash
+	v.index++
+	if r.Hash == v.checkpoint.Head {
+		v.head = true
+	}
+
+	return nil
+}
+
+// Finish returns a *ChainError if the checkpoint's last record wasn't seen, i.e. records were removed from the end
+func (v *Verifier) Finish() error {
+	if v.index == 0 {
+		if reason := v.checkCheckpoint(); reason != "" {
+			return &ChainError{Index: 0, Reason: reason}
+		}
+	}
+	if !v.head {
+		return &ChainError{Index: v.index, Reason: "last checkpointed record is missing; records were removed from the end"}
+	}
+	return nil
+}
+
+// Count returns the number of records successfully verified
+func (v *Verifier) Count() int {
+	return v.index
+}
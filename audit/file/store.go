@@ -0,0 +1,477 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/audit"
+)
+
+// entry is the location of a record in the file
+type entry struct {
+	offset int64
+	length int
+	time   time.Time
+}
+
+// index holds the location of every record in the file, oldest first, and the positions of records by field
+type index struct {
+	entries []entry
+	actors  map[string][]int
+	targets map[string][]int
+	actions map[string][]int
+}
+
+func newIndex() *index {
+	return &index{actors: make(map[string][]int), targets: make(map[string][]int), actions: make(map[string][]int)}
+}
+
+func (i *index) add(r *audit.Record, offset int64, length int) {
+	pos := len(i.entries)
+	i.entries = append(i.entries, entry{offset: offset, length: length, time: r.Time})
+	if r.Actor != "" {
+		i.actors[strings.ToLower(r.Actor)] = append(i.actors[strings.ToLower(r.Actor)], pos)
+	}
+	if r.Target != "" {
+		i.targets[strings.ToLower(r.Target)] = append(i.targets[strings.ToLower(r.Target)], pos)
+	}
+	i.actions[strings.ToLower(r.Action)] = append(i.actions[strings.ToLower(r.Action)], pos)
+}
+
+// candidates returns the positions of the records that can match q, oldest first. all is true if every record can match
+func (i *index) candidates(q *audit.Query) (positions []int, all bool) {
+	all = true
+	narrow := func(field string, m map[string][]int) {
+		if field == "" {
+			return
+		}
+		p := m[strings.ToLower(field)]
+		if all || len(p) < len(positions) {
+			positions, all = p, false
+		}
+	}
+	narrow(q.Actor, i.actors)
+	narrow(q.Target, i.targets)
+	narrow(q.Action, i.actions)
+	return positions, all
+}
+
+// Store represents a Store that appends hash-chained records to a JSON lines file. Appended records are written
+// immediately but synced to disk, along with the checkpoint at path + ".checkpoint", by Sync or Run
+type Store struct {
+	path    string
+	hmacKey []byte
+	mu      *sync.Mutex
+
+	// index is nil if the file hasn't been read yet
+	index    *index
+	size     int64
+	lastHash string
+	// start is the PrevHash of the first record in the file
+	start string
+
+	// f is the file opened for appending, or nil if it isn't open
+	f *os.File
+	// dirty is true if records have been appended since the last sync
+	dirty bool
+}
+
+// New returns a new *Store using the file at path. If hmacKey is non-empty, records are also signed with it
+func New(path string, hmacKey []byte) *Store {
+	return &Store{path: path, hmacKey: hmacKey, mu: new(sync.Mutex)}
+}
+
+func (s *Store) checkpointPath() string {
+	return s.path + ".checkpoint"
+}
+
+// readCheckpoint returns the stored checkpoint, or nil if it doesn't exist
+func (s *Store) readCheckpoint() (*audit.Checkpoint, error) {
+	buf, err := os.ReadFile(s.checkpointPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to read %s: %v", s.checkpointPath(), err)
+	}
+
+	c := new(audit.Checkpoint)
+	if err = json.Unmarshal(buf, c); err != nil {
+		return nil, fmt.Errorf("Unable to decode %s: %v", s.checkpointPath(), err)
+	}
+
+	return c, nil
+}
+
+// writeCheckpoint replaces the stored checkpoint with the current ends of the log. The caller must hold s.mu
+func (s *Store) writeCheckpoint() error {
+	c := &audit.Checkpoint{Start: s.start, Head: s.lastHash, Time: time.Now().UTC()}
+	c.Sign(s.hmacKey)
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.checkpointPath())+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(c); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to encode checkpoint: %v", err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to sync temporary file: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.checkpointPath()); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", s.checkpointPath(), err)
+	}
+
+	return nil
+}
+
+// load reads the file and checkpoint if they haven't been read yet. The caller must hold s.mu
+func (s *Store) load() error {
+	if s.index != nil {
+		return nil
+	}
+
+	c, err := s.readCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	idx := newIndex()
+	var size int64
+	var last string
+	err = s.each(func(r *audit.Record, offset int64, length int) {
+		idx.add(r, offset, length)
+		size = offset + int64(length)
+		last = r.Hash
+	})
+	if err != nil {
+		return err
+	}
+
+	s.index, s.size, s.lastHash = idx, size, last
+	s.start = ""
+	if c != nil {
+		s.start = c.Start
+	}
+
+	return nil
+}
+
+// each calls fn for every record in the file, oldest first, with its offset and length in bytes
+func (s *Store) each(fn func(r *audit.Record, offset int64, length int)) error {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	var offset int64
+	for line := 1; ; line++ {
+		buf, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(buf) == 0 {
+			return nil
+		} else if err != nil && err != io.EOF {
+			return fmt.Errorf("Unable to read %s: %v", s.path, err)
+		}
+
+		r := new(audit.Record)
+		if err := json.Unmarshal(buf, r); err != nil {
+			return fmt.Errorf("Unable to decode %s line %d: %v", s.path, line, err)
+		}
+		fn(r, offset, len(buf))
+		offset += int64(len(buf))
+	}
+}
+
+// Append seals the record into the log's hash chain and writes it, or returns an error if one occurred.
+// The record is synced to disk by the next Sync
+func (s *Store) Append(r *audit.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.append(r)
+}
+
+func (s *Store) append(r *audit.Record) error {
+	if err := s.load(); err != nil {
+		return err
+	}
+
+	r.Time = r.Time.UTC()
+	if err := r.Seal(s.lastHash, s.hmacKey); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("Unable to encode record: %v", err)
+	}
+	buf = append(buf, '\n')
+
+	if s.f == nil {
+		if s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600); err != nil {
+			return fmt.Errorf("Unable to open %s: %v", s.path, err)
+		}
+	}
+
+	if _, err = s.f.Write(buf); err != nil {
+		// the file may hold part of the record, so it's read again before the next append
+		s.close()
+		return fmt.Errorf("Unable to write record: %v", err)
+	}
+
+	s.index.add(r, s.size, len(buf))
+	s.size += int64(len(buf))
+	s.lastHash = r.Hash
+	s.dirty = true
+
+	return nil
+}
+
+// close closes the file and discards the index. The caller must hold s.mu
+func (s *Store) close() {
+	if s.f != nil {
+		s.f.Close()
+		s.f = nil
+	}
+	s.index = nil
+}
+
+// Sync syncs the records appended since the last Sync to disk and updates the checkpoint,
+// or returns an error if one occurred
+func (s *Store) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sync()
+}
+
+func (s *Store) sync() error {
+	if !s.dirty {
+		return nil
+	}
+
+	if s.f != nil {
+		if err := s.f.Sync(); err != nil {
+			return fmt.Errorf("Unable to sync %s: %v", s.path, err)
+		}
+	}
+
+	// the checkpoint is only written once the records it refers to are on disk
+	if err := s.writeCheckpoint(); err != nil {
+		return err
+	}
+	s.dirty = false
+
+	return nil
+}
+
+// Run calls Sync every interval. Run never returns
+func (s *Store) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.Sync(); err != nil {
+			log.Println("Unable to sync audit log:", err)
+		}
+	}
+}
+
+// Query returns the records matching q, newest first, or an error if one occurred
+func (s *Store) Query(q *audit.Query) ([]*audit.Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	positions, all := s.index.candidates(q)
+	n := len(positions)
+	if all {
+		n = len(s.index.entries)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	var records []*audit.Record
+	for i := n - 1; i >= 0; i-- {
+		pos := i
+		if !all {
+			pos = positions[i]
+		}
+
+		e := s.index.entries[pos]
+		if (!q.Since.IsZero() && e.time.Before(q.Since)) || (!q.Until.IsZero() && !e.time.Before(q.Until)) {
+			continue
+		}
+
+		buf := make([]byte, e.length)
+		if _, err = f.ReadAt(buf, e.offset); err != nil {
+			return nil, fmt.Errorf("Unable to read %s: %v", s.path, err)
+		}
+
+		r := new(audit.Record)
+		if err = json.Unmarshal(bytes.TrimSpace(buf), r); err != nil {
+			return nil, fmt.Errorf("Unable to decode record at offset %d: %v", e.offset, err)
+		}
+
+		if !q.Match(r) {
+			continue
+		}
+
+		records = append(records, r)
+		if q.Limit > 0 && len(records) == q.Limit {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// Purge removes all records older than before and returns the number removed,
+// or an error if one occurred. A record of the purge is appended to the log and the checkpoint is updated
+func (s *Store) Purge(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil {
+		return 0, err
+	}
+
+	var (
+		kept   []*audit.Record
+		purged int
+	)
+	err := s.each(func(r *audit.Record, offset int64, length int) {
+		if r.Time.Before(before) {
+			purged++
+			return
+		}
+		kept = append(kept, r)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range kept {
+		if err = enc.Encode(r); err != nil {
+			tmp.Close()
+			return 0, fmt.Errorf("Unable to encode record: %v", err)
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("Unable to sync temporary file: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return 0, fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return 0, fmt.Errorf("Unable to replace %s: %v", s.path, err)
+	}
+
+	// the log now starts after the last purged record
+	start, last := s.lastHash, s.lastHash
+	if len(kept) > 0 {
+		start = kept[0].PrevHash
+	}
+	s.close()
+	if err = s.load(); err != nil {
+		return purged, err
+	}
+	// the purge record follows the last record, even if it was purged
+	s.start, s.lastHash = start, last
+
+	err = s.append(&audit.Record{
+		Time:   time.Now(),
+		Action: "PurgeAudit",
+		Target: fmt.Sprintf("%d records before %s", purged, before.UTC().Format(time.RFC3339)),
+	})
+	if err != nil {
+		return purged, fmt.Errorf("Unable to record purge: %v", err)
+	}
+
+	if err = s.sync(); err != nil {
+		return purged, err
+	}
+
+	return purged, nil
+}
+
+// Verify walks the log and returns a *audit.ChainError for the first record that breaks the hash chain or doesn't
+// match the checkpoint, or another error if one occurred. It also returns the number of records verified
+func (s *Store) Verify() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, err := s.readCheckpoint()
+	if err != nil {
+		return 0, err
+	}
+
+	v := audit.NewVerifier(s.hmacKey, c)
+
+	var chainErr error
+	err = s.each(func(r *audit.Record, offset int64, length int) {
+		if chainErr == nil {
+			chainErr = v.Next(r)
+		}
+	})
+	if err != nil {
+		return v.Count(), err
+	}
+
+	if chainErr == nil {
+		chainErr = v.Finish()
+	}
+
+	return v.Count(), chainErr
+}
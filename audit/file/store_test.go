@@ -0,0 +1,172 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/audit"
+)
+
+var testHMACKey = []byte("secret")
+
+// newTestStore returns a synced *Store with a record for each actor, one second apart starting at start
+func newTestStore(t *testing.T, start time.Time, actors ...string) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	s := New(path, testHMACKey)
+	for i, actor := range actors {
+		r := &audit.Record{Time: start.Add(time.Duration(i) * time.Second), Actor: actor, Action: "ResetPassword", Target: "jdoe12"}
+		if err := s.Append(r); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Sync(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s, path
+}
+
+// removeLines rewrites the log without the lines at the given indexes
+func removeLines(t *testing.T, path string, indexes ...int) {
+	t.Helper()
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(buf, []byte("\n"))
+	for i := len(indexes) - 1; i >= 0; i-- {
+		lines = append(lines[:indexes[i]], lines[indexes[i]+1:]...)
+	}
+	if err = os.WriteFile(path, bytes.Join(lines, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	s, path := newTestStore(t, start, "alice", "bob", "alice", "carol", "Alice")
+
+	for _, test := range []struct {
+		name     string
+		query    *audit.Query
+		expected []string
+	}{
+		{"all", &audit.Query{}, []string{"Alice", "carol", "alice", "bob", "alice"}},
+		{"actor", &audit.Query{Actor: "ALICE"}, []string{"Alice", "alice", "alice"}},
+		{"actor and action", &audit.Query{Actor: "bob", Action: "resetpassword"}, []string{"bob"}},
+		{"missing actor", &audit.Query{Actor: "dave"}, nil},
+		{"limit", &audit.Query{Actor: "alice", Limit: 2}, []string{"Alice", "alice"}},
+		{"since", &audit.Query{Since: start.Add(3 * time.Second)}, []string{"Alice", "carol"}},
+		{"until", &audit.Query{Target: "jdoe12", Until: start.Add(time.Second)}, []string{"alice"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			// a new store reads the index from the file
+			for _, store := range []*Store{s, New(path, testHMACKey)} {
+				records, err := store.Query(test.query)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				var actors []string
+				for _, r := range records {
+					actors = append(actors, r.Actor)
+				}
+				if len(actors) != len(test.expected) {
+					t.Fatalf("expected %v, got %v", test.expected, actors)
+				}
+				for i := range actors {
+					if actors[i] != test.expected[i] {
+						t.Fatalf("expected %v, got %v", test.expected, actors)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		modify func(t *testing.T, path string)
+		valid  bool
+	}{
+		{"intact", func(t *testing.T, path string) {}, true},
+		{"first record removed", func(t *testing.T, path string) { removeLines(t, path, 0) }, false},
+		{"middle record removed", func(t *testing.T, path string) { removeLines(t, path, 1) }, false},
+		{"last record removed", func(t *testing.T, path string) { removeLines(t, path, 2) }, false},
+		{"checkpoint removed", func(t *testing.T, path string) { os.Remove(path + ".checkpoint") }, true},
+		{"checkpoint removed after first record removed", func(t *testing.T, path string) {
+			removeLines(t, path, 0)
+			os.Remove(path + ".checkpoint")
+		}, false},
+		{"checkpoint changed", func(t *testing.T, path string) {
+			buf, err := os.ReadFile(path + ".checkpoint")
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf = bytes.Replace(buf, []byte(`"start":""`), []byte(`"start":"0000"`), 1)
+			if err = os.WriteFile(path+".checkpoint", buf, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, path := newTestStore(t, time.Now(), "alice", "bob", "carol")
+			test.modify(t, path)
+
+			_, err := New(path, testHMACKey).Verify()
+			var chainErr *audit.ChainError
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !test.valid && !errors.As(err, &chainErr) {
+				t.Errorf("expected *audit.ChainError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPurge(t *testing.T) {
+	start := time.Now().Add(-time.Hour)
+	s, path := newTestStore(t, start, "alice", "bob", "carol")
+
+	n, err := s.Purge(start.Add(2 * time.Second))
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 purged, got %d, %v", n, err)
+	}
+
+	if err = s.Append(&audit.Record{Time: time.Now(), Actor: "dave", Action: "ResetPassword"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = s.Sync(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	records, err := s.Query(&audit.Query{})
+	if err != nil || len(records) != 3 || records[0].Actor != "dave" || records[1].Action != "PurgeAudit" ||
+		records[2].Actor != "carol" {
+		t.Fatalf("unexpected records after purge: %v, %v", records, err)
+	}
+
+	if n, err := New(path, testHMACKey).Verify(); err != nil || n != 3 {
+		t.Errorf("expected 3 verified records, got %d, %v", n, err)
+	}
+
+	// removing the first record left by the purge is detected
+	removeLines(t, path, 0)
+	var chainErr *audit.ChainError
+	if _, err = New(path, testHMACKey).Verify(); !errors.As(err, &chainErr) {
+		t.Errorf("expected *audit.ChainError, got %v", err)
+	}
+
+	// purging every record leaves only the purge record
+	s, path = newTestStore(t, start, "alice", "bob")
+	if n, err = s.Purge(time.Now()); err != nil || n != 2 {
+		t.Fatalf("expected 2 purged, got %d, %v", n, err)
+	}
+	if n, err := New(path, testHMACKey).Verify(); err != nil || n != 1 {
+		t.Errorf("expected 1 verified record, got %d, %v", n, err)
+	}
+}
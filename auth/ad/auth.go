@@ -1,41 +1,58 @@
 package ad
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strconv"
 
 	adauth "github.com/korylprince/go-ad-auth/v3"
 	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/dc"
 )
 
+// accountDisabled is the userAccountControl flag for disabled accounts
+const accountDisabled = 0x2
+
 // Permissions is a mapping of groups to GradeRanges
 type Permissions map[string][]auth.GradeRange
 
 // Auth represents an Active Directory authentication mechanism
 type Auth struct {
-	config      *adauth.Config
+	pool        *dc.Pool
+	bindUser    string
+	bindPass    string
 	permissions Permissions
+	adminGroups []string
 }
 
-// New returns a new *Auth with the given configuration and permissions mapping
-func New(config *adauth.Config, permissions Permissions) *Auth {
-	return &Auth{config: config, permissions: permissions}
+// New returns a new *Auth using the domain controllers in pool and the given permissions mapping.
+// Members of adminGroups are administrators.
+// The bind credentials are used to look up users that have authenticated by other means
+func New(pool *dc.Pool, bindUser, bindPass string, permissions Permissions, adminGroups []string) *Auth {
+	return &Auth{pool: pool, bindUser: bindUser, bindPass: bindPass, permissions: permissions, adminGroups: adminGroups}
 }
 
-// Authenticate authenticates the given credentials and returns the User associated with the account if successful,
-// or nil if not. If an error occurs it is returned.
-func (a *Auth) Authenticate(username, password string) (user *auth.User, err error) {
+// groups returns the names of all groups that grant permissions
+func (a *Auth) groups() []string {
 	var groups []string
 	for group := range a.permissions {
 		groups = append(groups, group)
 	}
 
-	status, entry, userGroups, err := adauth.AuthenticateExtended(a.config, username, password, []string{"displayName"}, groups)
-	if err != nil {
-		return nil, fmt.Errorf("Error attempting to authenticate as %s: %v", username, err)
+	for _, group := range a.adminGroups {
+		if _, ok := a.permissions[group]; !ok {
+			groups = append(groups, group)
+		}
 	}
 
-	if !status || len(userGroups) == 0 {
-		return nil, nil
+	return groups
+}
+
+// user returns the User for the given entry and groups, or nil if the groups grant no permissions
+func (a *Auth) user(username, displayName string, userGroups []string) *auth.User {
+	if len(userGroups) == 0 {
+		return nil
 	}
 
 	var gradePermissions []auth.GradeRange
@@ -44,9 +61,156 @@ func (a *Auth) Authenticate(username, password string) (user *auth.User, err err
 		gradePermissions = append(gradePermissions, a.permissions[group]...)
 	}
 
-	return &auth.User{
+	u := &auth.User{
 		Username:    username,
-		DisplayName: entry.GetAttributeValue("displayName"),
+		DisplayName: displayName,
 		Permissions: gradePermissions,
-	}, nil
+		Groups:      userGroups,
+	}
+	u.Admin = u.InGroup(a.adminGroups...)
+
+	return u
+}
+
+// userGroups returns the names of the groups granting permissions that the object with the given DN is a member of
+func (a *Auth) userGroups(conn *adauth.Conn, dn string) ([]string, error) {
+	groupDNs := make(map[string]string)
+	var dns []string
+	for _, group := range a.groups() {
+		groupDN, err := conn.GroupDN(group)
+		if err != nil {
+			return nil, fmt.Errorf("Error searching for group %s: %v", group, err)
+		}
+		groupDNs[groupDN] = group
+		dns = append(dns, groupDN)
+	}
+
+	matched, err := conn.ObjectGroups("dn", dn, dns)
+	if err != nil {
+		return nil, err
+	}
+
+	var userGroups []string
+	for _, groupDN := range matched {
+		userGroups = append(userGroups, groupDNs[groupDN])
+	}
+
+	return userGroups, nil
+}
+
+// directoryError translates err from a directory operation into an *auth.UnavailableError if the directory couldn't be
+// reached or ctx is done, since operations aborted by closing the connection fail with a less helpful error
+func directoryError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return &auth.UnavailableError{Err: ctx.Err()}
+	}
+	if dc.IsUnavailable(err) {
+		return &auth.UnavailableError{Err: err}
+	}
+	return err
+}
+
+// retry runs the idempotent op with the pool's retries and circuit breaker, returning an *auth.UnavailableError if
+// the circuit breaker is open
+func (a *Auth) retry(ctx context.Context, op func() error) error {
+	err := a.pool.Retry(ctx, op)
+	if errors.As(err, new(*dc.CircuitOpenError)) {
+		return &auth.UnavailableError{Err: err}
+	}
+	return err
+}
+
+// Authenticate authenticates the given credentials and returns the User associated with the account if successful,
+// or nil if the account has no permissions. An *auth.InvalidCredentialsError is returned if the credentials are invalid.
+// If an error occurs it is returned. Authentication is aborted when ctx is done, and retried if the directory is
+// unavailable.
+func (a *Auth) Authenticate(ctx context.Context, username, password string) (user *auth.User, err error) {
+	err = a.retry(ctx, func() error {
+		user, err = a.authenticate(ctx, username, password)
+		return err
+	})
+	return user, err
+}
+
+func (a *Auth) authenticate(ctx context.Context, username, password string) (user *auth.User, err error) {
+	upn, err := a.pool.Base().UPN(username)
+	if err != nil {
+		return nil, fmt.Errorf("Error attempting to authenticate as %s: %v", username, err)
+	}
+
+	conn, done, err := a.pool.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to server: %w", &auth.UnavailableError{Err: err})
+	}
+	defer done()
+
+	status, err := conn.Bind(upn, password)
+	if err != nil {
+		return nil, fmt.Errorf("Error attempting to authenticate as %s: %w", username, directoryError(ctx, err))
+	}
+	if !status {
+		return nil, &auth.InvalidCredentialsError{Username: username}
+	}
+
+	entry, err := conn.GetAttributes("userPrincipalName", upn, []string{"displayName"})
+	if err != nil {
+		return nil, fmt.Errorf("Error searching for user %s: %w", username, directoryError(ctx, err))
+	}
+	// the bind can succeed with a UPN that isn't the user's userPrincipalName, e.g. with an alternate UPN suffix
+	if entry == nil {
+		return nil, nil
+	}
+
+	userGroups, err := a.userGroups(conn, entry.DN)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting groups for user %s: %w", username, directoryError(ctx, err))
+	}
+
+	return a.user(username, entry.GetAttributeValue("displayName"), userGroups), nil
+}
+
+// Lookup returns the User associated with the given username if it exists, is enabled, and has permissions,
+// or nil if not. If an error occurs it is returned. The lookup is aborted when ctx is done, and retried if the
+// directory is unavailable.
+func (a *Auth) Lookup(ctx context.Context, username string) (user *auth.User, err error) {
+	err = a.retry(ctx, func() error {
+		user, err = a.lookup(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+func (a *Auth) lookup(ctx context.Context, username string) (user *auth.User, err error) {
+	conn, done, err := a.pool.Connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to server: %w", &auth.UnavailableError{Err: err})
+	}
+	defer done()
+
+	status, err := conn.Bind(a.bindUser, a.bindPass)
+	if err != nil {
+		return nil, fmt.Errorf("Error binding to server: %w", directoryError(ctx, err))
+	}
+	if !status {
+		return nil, &auth.BindError{User: a.bindUser}
+	}
+
+	entry, err := conn.GetAttributes("sAMAccountName", username, []string{"displayName", "userAccountControl"})
+	if err != nil {
+		return nil, fmt.Errorf("Error searching for user %s: %w", username, directoryError(ctx, err))
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if uac, err := strconv.Atoi(entry.GetAttributeValue("userAccountControl")); err != nil || uac&accountDisabled != 0 {
+		return nil, nil
+	}
+
+	userGroups, err := a.userGroups(conn, entry.DN)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting groups for user %s: %w", username, directoryError(ctx, err))
+	}
+
+	return a.user(username, entry.GetAttributeValue("displayName"), userGroups), nil
 }
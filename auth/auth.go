@@ -1,11 +1,49 @@
 package auth
 
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // GradeRange represents an inclusive range of grades
 type GradeRange struct {
-	MinGrade int
-	MaxGrade int
+	MinGrade int `json:"min_grade"`
+	MaxGrade int `json:"max_grade"`
 }
 
+// ParseGradeRanges parses the format "{min-grade}<>{max-grade};{min-grade}<>{max-grade};..."
+func ParseGradeRanges(str string) ([]GradeRange, error) {
+	var gradeRanges []GradeRange
+
+	for _, r := range strings.Split(str, ";") {
+
+		grades := strings.Split(strings.TrimSpace(r), "<>")
+
+		if len(grades) != 2 {
+			return nil, fmt.Errorf("Unable to parse grade range: %s", r)
+		}
+
+		minGrade, err := strconv.Atoi(strings.TrimSpace(grades[0]))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse minimum grade: %s: %v", grades[0], err)
+		}
+
+		maxGrade, err := strconv.Atoi(strings.TrimSpace(grades[1]))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse maximum grade: %s: %v", grades[1], err)
+		}
+
+		gradeRanges = append(gradeRanges, GradeRange{MinGrade: minGrade, MaxGrade: maxGrade})
+	}
+
+	return gradeRanges, nil
+}
+
+// AllGrades is the range of every grade
+var AllGrades = GradeRange{MinGrade: -1, MaxGrade: 12}
+
 // In returns true if i is in the GradeRange
 func (r GradeRange) In(i int) bool {
 	return r.MinGrade <= i && i <= r.MaxGrade
@@ -16,10 +54,42 @@ type User struct {
 	Username    string
 	DisplayName string
 	Permissions []GradeRange
+	// Groups are the configured permission groups the user is a member of
+	Groups []string
+	// Admin grants access to every grade and to administrative actions
+	Admin bool
+}
+
+// AuthorizedRange returns true if the User has permissions for every grade in the given range
+func (u *User) AuthorizedRange(r GradeRange) bool {
+	for grade := r.MinGrade; grade <= r.MaxGrade; grade++ {
+		if !u.Authorized(grade) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// InGroup returns true if the User is a member of any of the given groups
+func (u *User) InGroup(groups ...string) bool {
+	for _, g := range u.Groups {
+		for _, group := range groups {
+			if strings.EqualFold(g, group) {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // Authorized returns true if the User has permissions for the give grade
 func (u *User) Authorized(grade int) bool {
+	if u.Admin {
+		return true
+	}
+
 	for _, perm := range u.Permissions {
 		if perm.In(grade) {
 			return true
@@ -32,6 +102,14 @@ func (u *User) Authorized(grade int) bool {
 // Auth represents an authentication mechanism
 type Auth interface {
 	// Authenticate authenticates the given credentials and returns the User associated with the account if successful,
-	// or nil if not. If an error occurs it is returned.
-	Authenticate(username, password string) (user *User, err error)
+	// or nil if the account has no permissions. An *InvalidCredentialsError is returned if the credentials are invalid.
+	// If an error occurs it is returned. Authentication is aborted when ctx is done.
+	Authenticate(ctx context.Context, username, password string) (user *User, err error)
+}
+
+// Lookup represents a mechanism to look up users that have been authenticated by other means
+type Lookup interface {
+	// Lookup returns the User associated with the given username if it exists, is enabled, and has permissions,
+	// or nil if not. If an error occurs it is returned. The lookup is aborted when ctx is done.
+	Lookup(ctx context.Context, username string) (user *User, err error)
 }
@@ -0,0 +1,42 @@
+package auth
+
+import "fmt"
+
+// InvalidCredentialsError is returned when a username and password don't match
+type InvalidCredentialsError struct {
+	Username string
+}
+
+func (e *InvalidCredentialsError) Error() string {
+	return fmt.Sprintf("Invalid credentials for user %s", e.Username)
+}
+
+// UnavailableError is returned when the authentication backend can't be reached or doesn't respond in time
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("Authentication backend unavailable: %v", e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// BindError is returned when the backend rejects the credentials used to look up users
+type BindError struct {
+	User string
+	Err  error
+}
+
+func (e *BindError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("Invalid bind credentials for user %s", e.User)
+	}
+	return fmt.Sprintf("Unable to bind as user %s: %v", e.User, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,450 @@
+package oidc
+
+import (
+	"container/list"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
+
+// LoginExpiration is how long a user has to complete a login at the identity provider
+const LoginExpiration = 10 * time.Minute
+
+// maxPendingLogins is the number of logins that can be in progress at once. Starting a login past the limit
+// discards the oldest, so unauthenticated clients can't grow the pending logins without limit
+const maxPendingLogins = 10000
+
+// clockSkew is the difference allowed between the server's and identity provider's clocks when checking ID token times
+const clockSkew = time.Minute
+
+// Permissions is a mapping of groups or roles to GradeRanges
+type Permissions map[string][]auth.GradeRange
+
+// Config contains settings for connecting to an OpenID Connect identity provider
+type Config struct {
+	// Issuer is the issuer URL used for discovery and to validate ID tokens
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to openid
+	Scopes []string
+	// UsernameClaim is the ID token claim used as the username. Defaults to preferred_username
+	UsernameClaim string
+	// GroupsClaim is the ID token claim containing the user's groups or roles. Defaults to groups
+	GroupsClaim string
+	// AdminGroups are groups or roles whose members are administrators
+	AdminGroups []string
+	// HTTPClient is used to contact the identity provider. Defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type pendingLogin struct {
+	state    string
+	verifier string
+	nonce    string
+	expires  time.Time
+}
+
+// Provider represents an OpenID Connect authorization code flow with PKCE
+type Provider struct {
+	config      *Config
+	permissions Permissions
+
+	mu        *sync.Mutex
+	discovery *discovery
+	keys      map[string]crypto.PublicKey
+	// pending maps states to elements of order, which holds *pendingLogins oldest first
+	pending    map[string]*list.Element
+	order      *list.List
+	maxPending int
+}
+
+// New returns a new *Provider with the given configuration and permissions mapping
+func New(config *Config, permissions Permissions) *Provider {
+	return &Provider{
+		config:      config,
+		permissions: permissions,
+		mu:          new(sync.Mutex),
+		pending:     make(map[string]*list.Element),
+		order:       list.New(),
+		maxPending:  maxPendingLogins,
+	}
+}
+
+func (p *Provider) client() *http.Client {
+	if p.config.HTTPClient != nil {
+		return p.config.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover returns the provider's discovery document, fetching it if necessary
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	d := p.discovery
+	p.mu.Unlock()
+	if d != nil {
+		return d, nil
+	}
+
+	d = new(discovery)
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.config.Issuer, "/")+"/.well-known/openid-configuration", d); err != nil {
+		return nil, fmt.Errorf("Unable to get discovery document: %v", err)
+	}
+
+	if d.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("Discovered issuer %s doesn't match configured issuer %s", d.Issuer, p.config.Issuer)
+	}
+
+	p.mu.Lock()
+	p.discovery = d
+	p.mu.Unlock()
+
+	return d, nil
+}
+
+// key returns the signing key with the given id, refreshing the key set if it isn't found
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	k, ok := p.keys[kid]
+	p.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set := new(struct {
+		Keys []*jwk `json:"keys"`
+	})
+	if err = p.getJSON(ctx, d.JWKSURI, set); err != nil {
+		return nil, fmt.Errorf("Unable to get key set: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		pub, err := j.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[j.Kid] = pub
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if k, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("Unknown signing key: %s", kid)
+	}
+
+	return k, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthURL returns the URL to send the user to in order to log in at the identity provider,
+// and the state that will be returned with the authorization code
+func (p *Provider) AuthURL(ctx context.Context) (authURL, state string, err error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	login := &pendingLogin{expires: time.Now().Add(LoginExpiration)}
+	if state, err = randomString(); err != nil {
+		return "", "", fmt.Errorf("Unable to generate state: %v", err)
+	}
+	login.state = state
+	if login.nonce, err = randomString(); err != nil {
+		return "", "", fmt.Errorf("Unable to generate nonce: %v", err)
+	}
+	if login.verifier, err = randomString(); err != nil {
+		return "", "", fmt.Errorf("Unable to generate code verifier: %v", err)
+	}
+
+	challenge := sha256.Sum256([]byte(login.verifier))
+
+	p.mu.Lock()
+	// logins expire in the order they're started, so expired and excess logins are at the front
+	now := time.Now()
+	for e := p.order.Front(); e != nil; e = p.order.Front() {
+		if !e.Value.(*pendingLogin).expires.Before(now) && p.order.Len() < p.maxPending {
+			break
+		}
+		delete(p.pending, e.Value.(*pendingLogin).state)
+		p.order.Remove(e)
+	}
+	p.pending[state] = p.order.PushBack(login)
+	p.mu.Unlock()
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {login.nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + q.Encode(), state, nil
+}
+
+// Exchange exchanges the authorization code for an ID token and returns the User associated with it if successful,
+// or nil if the user has no permissions. If an error occurs it is returned. Requests to the identity provider are
+// aborted when ctx is done.
+func (p *Provider) Exchange(ctx context.Context, state, code string) (user *auth.User, err error) {
+	p.mu.Lock()
+	e, ok := p.pending[state]
+	if ok {
+		delete(p.pending, state)
+		p.order.Remove(e)
+	}
+	p.mu.Unlock()
+
+	if !ok || e.Value.(*pendingLogin).expires.Before(time.Now()) {
+		return nil, errors.New("Unknown or expired state")
+	}
+	login := e.Value.(*pendingLogin)
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {login.verifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to exchange code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to exchange code: unexpected status: %s", resp.Status)
+	}
+
+	tokens := new(struct {
+		IDToken string `json:"id_token"`
+	})
+	if err = json.NewDecoder(resp.Body).Decode(tokens); err != nil {
+		return nil, fmt.Errorf("Unable to parse token response: %v", err)
+	}
+
+	claims, err := p.verify(ctx, tokens.IDToken, login.nonce)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token: %v", err)
+	}
+
+	return p.user(claims)
+}
+
+// verify verifies the signature and standard claims of the ID token and returns its claims
+func (p *Provider) verify(ctx context.Context, token, nonce string) (map[string]interface{}, error) {
+	header, claims, signed, sig, err := parseJWT(token)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifySignature(header.Alg, key, signed, sig); err != nil {
+		return nil, fmt.Errorf("Invalid signature: %v", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.config.Issuer {
+		return nil, fmt.Errorf("Unexpected issuer: %s", iss)
+	}
+
+	aud := stringsClaim(claims, "aud")
+	if !aud.contains(p.config.ClientID) {
+		return nil, errors.New("Token not issued for this client")
+	}
+
+	// the authorized party must be this client if it's set, and must be set if there are other audiences
+	azp, ok := claims["azp"].(string)
+	if (ok || len(aud) > 1) && azp != p.config.ClientID {
+		return nil, fmt.Errorf("Unexpected authorized party: %s", azp)
+	}
+
+	now := time.Now()
+
+	exp, ok := timeClaim(claims, "exp")
+	if !ok || !now.Before(exp.Add(clockSkew)) {
+		return nil, errors.New("Token expired")
+	}
+
+	if iat, ok := timeClaim(claims, "iat"); !ok || iat.After(now.Add(clockSkew)) {
+		return nil, errors.New("Token issued in the future")
+	}
+
+	if nbf, ok := timeClaim(claims, "nbf"); ok && nbf.After(now.Add(clockSkew)) {
+		return nil, errors.New("Token not yet valid")
+	}
+
+	if n, _ := claims["nonce"].(string); n != nonce {
+		return nil, errors.New("Nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+// user maps the ID token claims to a User, or nil if the user has no permissions
+func (p *Provider) user(claims map[string]interface{}) (*auth.User, error) {
+	usernameClaim := p.config.UsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = "preferred_username"
+	}
+	groupsClaim := p.config.GroupsClaim
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+
+	username, _ := claims[usernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("Token missing %s claim", usernameClaim)
+	}
+
+	var (
+		gradePermissions []auth.GradeRange
+		groups           []string
+	)
+	for _, group := range stringsClaim(claims, groupsClaim) {
+		perms, ok := p.permissions[group]
+		if ok {
+			gradePermissions = append(gradePermissions, perms...)
+		}
+		if ok || stringList(p.config.AdminGroups).contains(group) {
+			groups = append(groups, group)
+		}
+	}
+
+	displayName, _ := claims["name"].(string)
+
+	u := &auth.User{
+		Username:    username,
+		DisplayName: displayName,
+		Permissions: gradePermissions,
+		Groups:      groups,
+	}
+	u.Admin = u.InGroup(p.config.AdminGroups...)
+
+	if len(gradePermissions) == 0 && !u.Admin {
+		return nil, nil
+	}
+
+	return u, nil
+}
+
+// timeClaim returns the claim as a time, or false if it isn't a NumericDate
+func timeClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+type stringList []string
+
+func (l stringList) contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// stringsClaim returns the claim as a list of strings. A single string value is returned as a list of one
+func stringsClaim(claims map[string]interface{}, name string) stringList {
+	switch v := claims[name].(type) {
+	case string:
+		return stringList{v}
+	case []interface{}:
+		var l stringList
+		for _, i := range v {
+			if s, ok := i.(string); ok {
+				l = append(l, s)
+			}
+		}
+		return l
+	}
+	return nil
+}
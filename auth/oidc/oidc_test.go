@@ -0,0 +1,433 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
+
+const testClientID = "userbrowser"
+
+// testIdP is a stand-in identity provider that issues the ID token set by the test for the next code exchange
+type testIdP struct {
+	server *httptest.Server
+
+	mu *sync.Mutex
+	// keys are every key tokens can be signed with, and published are the ids of those in the key set
+	keys      map[string]crypto.Signer
+	published map[string]bool
+	challenge string
+	token     string
+	jwksHits  int
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	idp := &testIdP{mu: new(sync.Mutex), keys: make(map[string]crypto.Signer), published: make(map[string]bool)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksHits++
+
+		var keys []map[string]string
+		for kid := range idp.published {
+			keys = append(keys, publicJWK(kid, idp.keys[kid].Public()))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+
+		// PKCE: the verifier must hash to the challenge sent with the authorization request
+		verifier := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if r.FormValue("client_id") != testClientID ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != idp.challenge {
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.token})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+// addKey adds a new signing key of the given type ("RSA" or "EC") with the given id and publishes it in the key set
+func (idp *testIdP) addKey(t *testing.T, kid, kty string) {
+	t.Helper()
+	var (
+		k   crypto.Signer
+		err error
+	)
+	if kty == "RSA" {
+		k, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.mu.Lock()
+	idp.keys[kid] = k
+	idp.published[kid] = true
+	idp.mu.Unlock()
+}
+
+// unpublishKey removes the key from the key set. Tokens can still be signed with it
+func (idp *testIdP) unpublishKey(kid string) {
+	idp.mu.Lock()
+	delete(idp.published, kid)
+	idp.mu.Unlock()
+}
+
+func (idp *testIdP) keySetFetches() int {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	return idp.jwksHits
+}
+
+func publicJWK(kid string, pub crypto.PublicKey) map[string]string {
+	enc := base64.RawURLEncoding.EncodeToString
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "n": enc(k.N.Bytes()), "e": enc(big.NewInt(int64(k.E)).Bytes())}
+	case *ecdsa.PublicKey:
+		return map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256", "x": enc(k.X.FillBytes(make([]byte, 32))), "y": enc(k.Y.FillBytes(make([]byte, 32)))}
+	}
+	return nil
+}
+
+// sign returns a JWT with the given header and claims signed by the key with id kid
+func (idp *testIdP) sign(t *testing.T, header, claims map[string]interface{}, kid string) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	signed := enc(header) + "." + enc(claims)
+	hash := sha256.Sum256([]byte(signed))
+
+	idp.mu.Lock()
+	key := idp.keys[kid]
+	idp.mu.Unlock()
+
+	var (
+		sig []byte
+		err error
+	)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k, hash[:])
+		if err == nil {
+			sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestProvider(idp *testIdP) *Provider {
+	return New(&Config{
+		Issuer:      idp.server.URL,
+		ClientID:    testClientID,
+		RedirectURL: "https://userbrowser.example.com/",
+		AdminGroups: []string{"admins"},
+	}, Permissions{"teachers": {{MinGrade: 1, MaxGrade: 5}}})
+}
+
+// tokenOptions modify the header, claims and signature of an ID token
+type tokenOptions struct {
+	kid    string
+	header func(h map[string]interface{})
+	claims func(c map[string]interface{})
+	sign   func(token string) string
+}
+
+// login starts a login with p, has the IdP issue a token made with opts, and exchanges it
+func login(t *testing.T, p *Provider, idp *testIdP, opts tokenOptions) (*auth.User, error) {
+	t.Helper()
+	authURL, state, err := p.AuthURL(context.Background())
+	if err != nil {
+		t.Fatalf("AuthURL: %v", err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("state") != state || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("unexpected authorization URL: %s", authURL)
+	}
+
+	now := time.Now()
+	header := map[string]interface{}{"alg": "RS256", "kid": opts.kid, "typ": "JWT"}
+	claims := map[string]interface{}{
+		"iss":                idp.server.URL,
+		"aud":                testClientID,
+		"sub":                "1234",
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              q.Get("nonce"),
+		"preferred_username": "teacher",
+		"name":               "A Teacher",
+		"groups":             []string{"teachers"},
+	}
+	idp.mu.Lock()
+	if _, ok := idp.keys[opts.kid].(*ecdsa.PrivateKey); ok {
+		header["alg"] = "ES256"
+	}
+	idp.mu.Unlock()
+	if opts.header != nil {
+		opts.header(header)
+	}
+	if opts.claims != nil {
+		opts.claims(claims)
+	}
+
+	token := idp.sign(t, header, claims, opts.kid)
+	if opts.sign != nil {
+		token = opts.sign(token)
+	}
+
+	idp.mu.Lock()
+	idp.challenge = q.Get("code_challenge")
+	idp.token = token
+	idp.mu.Unlock()
+
+	return p.Exchange(context.Background(), state, "code")
+}
+
+func TestExchange(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.addKey(t, "rsa", "RSA")
+	idp.addKey(t, "ec", "EC")
+	p := newTestProvider(idp)
+
+	for _, kid := range []string{"rsa", "ec"} {
+		user, err := login(t, p, idp, tokenOptions{kid: kid})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", kid, err)
+		}
+		if user == nil || user.Username != "teacher" || user.DisplayName != "A Teacher" || user.Admin ||
+			len(user.Permissions) != 1 || user.Permissions[0].MaxGrade != 5 {
+			t.Errorf("%s: unexpected user: %+v", kid, user)
+		}
+	}
+}
+
+func TestExchangeUserMapping(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.addKey(t, "rsa", "RSA")
+	p := newTestProvider(idp)
+
+	user, err := login(t, p, idp, tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) {
+		c["groups"] = "students"
+	}})
+	if err != nil || user != nil {
+		t.Errorf("expected no user for a user without permissions, got %+v, %v", user, err)
+	}
+
+	user, err = login(t, p, idp, tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) {
+		c["groups"] = []string{"admins"}
+	}})
+	if err != nil || user == nil || !user.Admin {
+		t.Errorf("expected admin user, got %+v, %v", user, err)
+	}
+}
+
+func TestExchangeInvalid(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.addKey(t, "rsa", "RSA")
+	idp.addKey(t, "ec", "EC")
+	idp.addKey(t, "other", "RSA")
+	p := newTestProvider(idp)
+
+	hour := time.Hour
+	tests := []struct {
+		name string
+		opts tokenOptions
+	}{
+		{"bad signature", tokenOptions{kid: "rsa", sign: func(token string) string {
+			parts := strings.Split(token, ".")
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			sig[0] ^= 0xff
+			return parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+		}}},
+		{"modified claims", tokenOptions{kid: "rsa", sign: func(token string) string {
+			parts := strings.Split(token, ".")
+			claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			claims = []byte(strings.Replace(string(claims), `"teacher"`, `"admin"`, 1))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(claims) + "." + parts[2]
+		}}},
+		{"signed by other key", tokenOptions{kid: "rsa", sign: func(token string) string {
+			parts := strings.Split(token, ".")
+			other := strings.Split(idp.sign(t, map[string]interface{}{"alg": "RS256", "kid": "other"}, map[string]interface{}{}, "other"), ".")
+			return parts[0] + "." + parts[1] + "." + other[2]
+		}}},
+		{"unknown key", tokenOptions{kid: "rsa", header: func(h map[string]interface{}) { h["kid"] = "missing" }}},
+		{"alg none", tokenOptions{kid: "rsa", header: func(h map[string]interface{}) { h["alg"] = "none" }}},
+		{"alg mismatch", tokenOptions{kid: "ec", header: func(h map[string]interface{}) { h["alg"] = "RS256" }}},
+		{"wrong issuer", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }}},
+		{"wrong audience", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["aud"] = "other" }}},
+		{"other audience without azp", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "other"}
+		}}},
+		{"wrong azp", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["azp"] = "other" }}},
+		{"expired", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-hour).Unix() }}},
+		{"missing exp", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { delete(c, "exp") }}},
+		{"not yet valid", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["nbf"] = time.Now().Add(hour).Unix() }}},
+		{"issued in future", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["iat"] = time.Now().Add(hour).Unix() }}},
+		{"missing iat", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { delete(c, "iat") }}},
+		{"nonce mismatch", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { c["nonce"] = "other" }}},
+		{"missing username", tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) { delete(c, "preferred_username") }}},
+	}
+
+	for _, test := range tests {
+		if user, err := login(t, p, idp, test.opts); err == nil {
+			t.Errorf("%s: expected error, got user %+v", test.name, user)
+		}
+	}
+
+	// azp is accepted when it's this client
+	if _, err := login(t, p, idp, tokenOptions{kid: "rsa", claims: func(c map[string]interface{}) {
+		c["aud"] = []string{testClientID, "other"}
+		c["azp"] = testClientID
+	}}); err != nil {
+		t.Errorf("multiple audiences with azp: unexpected error: %v", err)
+	}
+}
+
+func TestExchangeState(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.addKey(t, "rsa", "RSA")
+	p := newTestProvider(idp)
+
+	if _, err := p.Exchange(context.Background(), "unknown", "code"); err == nil {
+		t.Error("expected error for unknown state")
+	}
+
+	// a state can only be used once
+	_, state, err := p.AuthURL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Exchange(context.Background(), state, "code")
+	if _, err = p.Exchange(context.Background(), state, "code"); err == nil || !strings.Contains(err.Error(), "state") {
+		t.Errorf("expected state error for reused state, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	idp := newTestIdP(t)
+	idp.addKey(t, "old", "RSA")
+	p := newTestProvider(idp)
+
+	if _, err := login(t, p, idp, tokenOptions{kid: "old"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the cached key set is used while it has the token's key
+	fetches := idp.keySetFetches()
+	if _, err := login(t, p, idp, tokenOptions{kid: "old"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if idp.keySetFetches() != fetches {
+		t.Error("key set fetched again for a known key")
+	}
+
+	// the key set is refreshed for a new key
+	idp.addKey(t, "new", "EC")
+	if _, err := login(t, p, idp, tokenOptions{kid: "new"}); err != nil {
+		t.Fatalf("rotated key: unexpected error: %v", err)
+	}
+	if idp.keySetFetches() != fetches+1 {
+		t.Error("key set not refreshed for a new key")
+	}
+
+	// keys removed from the key set aren't trusted after the next refresh
+	idp.unpublishKey("old")
+	idp.addKey(t, "newer", "RSA")
+	if _, err := login(t, p, idp, tokenOptions{kid: "newer"}); err != nil {
+		t.Fatalf("rotated key: unexpected error: %v", err)
+	}
+	if _, err := login(t, p, idp, tokenOptions{kid: "old"}); err == nil {
+		t.Error("expected error for token signed with a removed key")
+	}
+}
+
+func TestPendingLimit(t *testing.T) {
+	idp := newTestIdP(t)
+	p := newTestProvider(idp)
+	p.maxPending = 3
+
+	var states []string
+	for i := 0; i < 5; i++ {
+		_, state, err := p.AuthURL(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		states = append(states, state)
+	}
+
+	if len(p.pending) != 3 || p.order.Len() != 3 {
+		t.Fatalf("expected 3 pending logins, got %d, %d", len(p.pending), p.order.Len())
+	}
+
+	// the oldest logins are discarded
+	for i, state := range states {
+		if _, ok := p.pending[state]; ok != (i >= 2) {
+			t.Errorf("login %d: expected pending %v, got %v", i, i >= 2, ok)
+		}
+	}
+
+	// expired logins are discarded before the limit is reached
+	p.order.Front().Value.(*pendingLogin).expires = time.Now().Add(-time.Second)
+	p.maxPending = 10
+	if _, _, err := p.AuthURL(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.pending[states[2]]; ok || len(p.pending) != 3 {
+		t.Errorf("expected expired login to be discarded, got %d pending", len(p.pending))
+	}
+}
@@ -0,0 +1,143 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// limits on the CBOR accepted from clients. WebAuthn attestation objects and COSE keys nest at most three levels
+// and have a few dozen items
+const (
+	cborMaxDepth = 16
+	cborMaxItems = 1024
+)
+
+// decodeCBOR decodes a single CBOR (RFC 8949) data item from b and returns it along with any remaining bytes.
+// Only the definite length subset used by WebAuthn is supported. Integers are returned as int64,
+// byte strings as []byte, text strings as string, arrays as []interface{} and maps as map[interface{}]interface{}.
+// Map keys must be integers or text strings
+func decodeCBOR(b []byte) (v interface{}, rest []byte, err error) {
+	items := cborMaxItems
+	return decodeCBORItem(b, 0, &items)
+}
+
+// decodeCBORItem decodes a data item nested depth levels deep, decrementing items for every item decoded
+func decodeCBORItem(b []byte, depth int, items *int) (v interface{}, rest []byte, err error) {
+	if depth > cborMaxDepth {
+		return nil, nil, errors.New("CBOR data nested too deeply")
+	}
+	if *items--; *items < 0 {
+		return nil, nil, errors.New("Too many CBOR data items")
+	}
+
+	if len(b) == 0 {
+		return nil, nil, errors.New("Unexpected end of CBOR data")
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+	b = b[1:]
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		if len(b) < 1 {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		arg, b = uint64(b[0]), b[1:]
+	case info == 25:
+		if len(b) < 2 {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		arg, b = uint64(binary.BigEndian.Uint16(b)), b[2:]
+	case info == 26:
+		if len(b) < 4 {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		arg, b = uint64(binary.BigEndian.Uint32(b)), b[4:]
+	case info == 27:
+		if len(b) < 8 {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		arg, b = binary.BigEndian.Uint64(b), b[8:]
+	default:
+		return nil, nil, fmt.Errorf("Unsupported CBOR additional info: %d", info)
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("CBOR integer overflow")
+		}
+		return int64(arg), b, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("CBOR integer overflow")
+		}
+		return -1 - int64(arg), b, nil
+	case 2, 3:
+		if uint64(len(b)) < arg {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		if major == 2 {
+			return b[:arg], b[arg:], nil
+		}
+		return string(b[:arg]), b[arg:], nil
+	case 4:
+		// every item is at least one byte
+		if uint64(len(b)) < arg {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		var arr []interface{}
+		for i := uint64(0); i < arg; i++ {
+			if v, b, err = decodeCBORItem(b, depth+1, items); err != nil {
+				return nil, nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, b, nil
+	case 5:
+		if uint64(len(b)) < 2*arg {
+			return nil, nil, errors.New("Unexpected end of CBOR data")
+		}
+		m := make(map[interface{}]interface{})
+		for i := uint64(0); i < arg; i++ {
+			var k interface{}
+			if k, b, err = decodeCBORItem(b, depth+1, items); err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errors.New("Unsupported CBOR map key type")
+			}
+			if _, ok := m[k]; ok {
+				return nil, nil, errors.New("Duplicate CBOR map key")
+			}
+			if v, b, err = decodeCBORItem(b, depth+1, items); err != nil {
+				return nil, nil, err
+			}
+			m[k] = v
+		}
+		return m, b, nil
+	case 6:
+		// tags are ignored and the tagged item is returned
+		return decodeCBORItem(b, depth+1, items)
+	default:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22, 23:
+			return nil, b, nil
+		case 25, 26, 27:
+			return nil, nil, errors.New("CBOR floating point values are not supported")
+		}
+		return nil, nil, fmt.Errorf("Unsupported CBOR simple value: %d", arg)
+	}
+}
@@ -0,0 +1,96 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	// examples from RFC 8949 Appendix A
+	cases := []struct {
+		in   string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"8301820203820405", []interface{}{int64(1), []interface{}{int64(2), int64(3)}, []interface{}{int64(4), int64(5)}}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+	}
+
+	for _, c := range cases {
+		v, rest, err := decodeCBOR(mustHex(t, c.in+"ff"))
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, c.want) {
+			t.Errorf("%s: expected %#v, got %#v", c.in, c.want, v)
+		}
+		if !bytes.Equal(rest, []byte{0xff}) {
+			t.Errorf("%s: expected remaining bytes ff, got %x", c.in, rest)
+		}
+	}
+}
+
+func TestDecodeCBORInvalid(t *testing.T) {
+	tooMany := "990401" + strings.Repeat("00", 1025)
+	cases := map[string]string{
+		"empty":                 "",
+		"truncated argument":    "19e8",
+		"truncated byte string": "4401020304"[:8],
+		"truncated array":       "830102",
+		"truncated map":         "a20102",
+		"huge array":            "9bffffffffffffffff00",
+		"huge map":              "bbffffffffffffffff00",
+		"integer overflow":      "1bffffffffffffffff",
+		"indefinite length":     "9f01ff",
+		"float":                 "f93c00",
+		"byte string key":       "a1410102",
+		"array key":             "a1810102",
+		"map key":               "a1a0a0",
+		"duplicate key":         "a201020103",
+		"too deep":              strings.Repeat("81", cborMaxDepth+1) + "00",
+		"too deeply tagged":     strings.Repeat("c0", cborMaxDepth+1) + "00",
+		"too many items":        tooMany,
+	}
+
+	for name, in := range cases {
+		if _, _, err := decodeCBOR(mustHex(t, in)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// the limits allow nesting and items up to the maximum
+	if _, _, err := decodeCBOR(mustHex(t, strings.Repeat("81", cborMaxDepth)+"00")); err != nil {
+		t.Errorf("maximum depth: unexpected error: %v", err)
+	}
+	if _, _, err := decodeCBOR(mustHex(t, "9903ff"+strings.Repeat("00", cborMaxItems-1))); err != nil {
+		t.Errorf("maximum items: unexpected error: %v", err)
+	}
+}
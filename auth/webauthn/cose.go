@@ -0,0 +1,117 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE (RFC 8152) key parameters
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseAlgES256 = -7
+	coseAlgEdDSA = -8
+	coseAlgRS256 = -257
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// supportedAlgorithms are the COSE algorithms accepted for new credentials, in order of preference
+var supportedAlgorithms = []int64{coseAlgES256, coseAlgEdDSA, coseAlgRS256}
+
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func coseInt(m map[interface{}]interface{}, k int64) (int64, bool) {
+	v, ok := m[k].(int64)
+	return v, ok
+}
+
+func coseBytes(m map[interface{}]interface{}, k int64) ([]byte, bool) {
+	v, ok := m[k].([]byte)
+	return v, ok
+}
+
+// parseCOSEKey parses a CBOR encoded COSE public key
+func parseCOSEKey(b []byte) (*coseKey, error) {
+	v, _, err := decodeCBOR(b)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode key: %v", err)
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("Key is not a map")
+	}
+
+	kty, _ := coseInt(m, coseKeyType)
+	alg, _ := coseInt(m, coseAlgorithm)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == coseAlgES256:
+		crv, _ := coseInt(m, -1)
+		x, xok := coseBytes(m, -2)
+		y, yok := coseBytes(m, -3)
+		if crv != coseCurveP256 || !xok || !yok {
+			return nil, errors.New("Invalid EC2 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC2 key not on curve")
+		}
+		return &coseKey{alg: alg, key: key}, nil
+	case kty == coseKeyTypeOKP && alg == coseAlgEdDSA:
+		crv, _ := coseInt(m, -1)
+		x, ok := coseBytes(m, -2)
+		if crv != coseCurveEd25519 || !ok || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("Invalid OKP key")
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == coseAlgRS256:
+		n, nok := coseBytes(m, -1)
+		e, eok := coseBytes(m, -2)
+		if !nok || !eok {
+			return nil, errors.New("Invalid RSA key")
+		}
+		return &coseKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported key type %d with algorithm %d", kty, alg)
+}
+
+// verify verifies sig over msg with the key
+func (k *coseKey) verify(msg, sig []byte) error {
+	hash := sha256.Sum256(msg)
+
+	switch k.alg {
+	case coseAlgES256:
+		if !ecdsa.VerifyASN1(k.key.(*ecdsa.PublicKey), hash[:], sig) {
+			return errors.New("Invalid ES256 signature")
+		}
+		return nil
+	case coseAlgEdDSA:
+		if !ed25519.Verify(k.key.(ed25519.PublicKey), msg, sig) {
+			return errors.New("Invalid EdDSA signature")
+		}
+		return nil
+	case coseAlgRS256:
+		return rsa.VerifyPKCS1v15(k.key.(*rsa.PublicKey), crypto.SHA256, hash[:], sig)
+	}
+
+	return fmt.Errorf("Unsupported algorithm: %d", k.alg)
+}
@@ -0,0 +1,53 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"testing"
+)
+
+// fixed COSE keys: an Ed25519 key with the seed 0x01..0x20, and a P-256 key with that seed as its private scalar
+const (
+	testEd25519Key = "a401010327200621582079b5562e8fe654f94078b112e8a98ba7901f853ae695bed7e0e3910bad049664"
+	testES256Key   = "a5010203262001215820515c3d6eb9e396b904d3feca7f54fdcd0cc1e997bf375dca515ad0a6c3b4035f" +
+		"2258204536be3a50f318fbf9a5475902a221502bef0d57e08c53b2cc0a56f17d9f9354"
+)
+
+func TestParseCOSEKey(t *testing.T) {
+	k, err := parseCOSEKey(mustHex(t, testEd25519Key))
+	if err != nil {
+		t.Fatalf("Ed25519: unexpected error: %v", err)
+	}
+	if _, ok := k.key.(ed25519.PublicKey); !ok || k.alg != coseAlgEdDSA {
+		t.Errorf("Ed25519: unexpected key: %#v", k)
+	}
+
+	k, err = parseCOSEKey(mustHex(t, testES256Key))
+	if err != nil {
+		t.Fatalf("ES256: unexpected error: %v", err)
+	}
+	if _, ok := k.key.(*ecdsa.PublicKey); !ok || k.alg != coseAlgES256 {
+		t.Errorf("ES256: unexpected key: %#v", k)
+	}
+}
+
+func TestParseCOSEKeyInvalid(t *testing.T) {
+	cases := map[string]string{
+		"not a map": "80",
+		// the ES256 key with its last byte changed
+		"point not on curve": testES256Key[:len(testES256Key)-2] + "55",
+		// the ES256 key with curve P-384
+		"wrong curve":      "a5010203262002" + testES256Key[14:],
+		"missing y":        "a4010203262001215820515c3d6eb9e396b904d3feca7f54fdcd0cc1e997bf375dca515ad0a6c3b4035f",
+		"short OKP key":    "a40101032720062143010203",
+		"unsupported alg":  "a301020338220201",
+		"missing key type": "a10326",
+		"truncated":        testEd25519Key[:20],
+	}
+
+	for name, in := range cases {
+		if _, err := parseCOSEKey(mustHex(t, in)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/korylprince/userbrowser-server/v3/auth/webauthn"
+)
+
+// Store represents a Store that persists credentials to a JSON file
+type Store struct {
+	path string
+	mu   *sync.Mutex
+}
+
+// New returns a new *Store using the file at path
+func New(path string) *Store {
+	return &Store{path: path, mu: new(sync.Mutex)}
+}
+
+func (s *Store) read() ([]*webauthn.Credential, error) {
+	var creds []*webauthn.Credential
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return creds, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&creds); err != nil {
+		return nil, fmt.Errorf("Unable to decode %s: %v", s.path, err)
+	}
+
+	return creds, nil
+}
+
+func (s *Store) write(creds []*webauthn.Credential) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(creds); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to encode credentials: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// Add stores a new credential or returns an error if one occurred
+func (s *Store) Add(c *webauthn.Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	return s.write(append(creds, c))
+}
+
+// Get returns the credential with the given id or nil if it doesn't exist,
+// or an error if one occurred
+func (s *Store) Get(id []byte) (*webauthn.Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range creds {
+		if bytes.Equal(c.ID, id) {
+			return c, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// List returns all credentials for the given username or an error if one occurred
+func (s *Store) List(username string) ([]*webauthn.Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var userCreds []*webauthn.Credential
+	for _, c := range creds {
+		if strings.EqualFold(c.Username, username) {
+			userCreds = append(userCreds, c)
+		}
+	}
+
+	return userCreds, nil
+}
+
+// Update replaces the stored credential with the same ID or returns an error if one occurred
+func (s *Store) Update(c *webauthn.Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	for i := range creds {
+		if bytes.Equal(creds[i].ID, c.ID) {
+			creds[i] = c
+			return s.write(creds)
+		}
+	}
+
+	return errors.New("Credential doesn't exist")
+}
+
+// Delete removes the credential with the given id or returns an error if one occurred
+func (s *Store) Delete(id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creds, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	for i := range creds {
+		if bytes.Equal(creds[i].ID, id) {
+			return s.write(append(creds[:i], creds[i+1:]...))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,537 @@
+package webauthn
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// challengeExpiration is how long a client has to complete a ceremony
+const challengeExpiration = 5 * time.Minute
+
+// maxChallenges is the number of ceremonies that can be in progress at once, and maxUserChallenges the number for
+// a single username. Starting a ceremony past either limit discards the oldest, so unauthenticated clients can't grow
+// the outstanding challenges without limit
+const (
+	maxChallenges     = 10000
+	maxUserChallenges = 5
+)
+
+// authenticator data flags
+const (
+	flagUserPresent            = 0x01
+	flagUserVerified           = 0x04
+	flagAttestedCredentialData = 0x40
+)
+
+// Credential represents a registered WebAuthn credential
+type Credential struct {
+	ID        []byte    `json:"id"`
+	Username  string    `json:"username"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"public_key"`
+	SignCount uint32    `json:"sign_count"`
+	Created   time.Time `json:"created"`
+	LastUsed  time.Time `json:"last_used,omitempty"`
+}
+
+// Store is a credential storage mechanism
+type Store interface {
+	// Add stores a new credential or returns an error if one occurred
+	Add(c *Credential) error
+	// Get returns the credential with the given id or nil if it doesn't exist,
+	// or an error if one occurred
+	Get(id []byte) (*Credential, error)
+	// List returns all credentials for the given username or an error if one occurred
+	List(username string) ([]*Credential, error)
+	// Update replaces the stored credential with the same ID or returns an error if one occurred
+	Update(c *Credential) error
+	// Delete removes the credential with the given id or returns an error if one occurred
+	Delete(id []byte) error
+}
+
+// Config contains the relying party settings
+type Config struct {
+	// RPID is the relying party ID, usually the domain name of the web frontend
+	RPID   string
+	RPName string
+	// Origins are the allowed origins of the web frontend, e.g. https://userbrowser.example.com
+	Origins []string
+	// RequireUserVerification requires authenticators to verify the user, e.g. with a PIN or biometric
+	RequireUserVerification bool
+}
+
+type challenge struct {
+	value    string
+	username string
+	ceremony string
+	expires  time.Time
+}
+
+// WebAuthn represents a WebAuthn relying party
+type WebAuthn struct {
+	config *Config
+	store  Store
+
+	mu *sync.Mutex
+	// challenges maps challenges to elements of order, which holds *challenges oldest first
+	challenges map[string]*list.Element
+	order      *list.List
+	// users is the number of outstanding challenges for each lowercased username
+	users             map[string]int
+	maxChallenges     int
+	maxUserChallenges int
+}
+
+// New returns a new *WebAuthn with the given configuration and credential store
+func New(config *Config, store Store) *WebAuthn {
+	return &WebAuthn{
+		config:            config,
+		store:             store,
+		mu:                new(sync.Mutex),
+		challenges:        make(map[string]*list.Element),
+		order:             list.New(),
+		users:             make(map[string]int),
+		maxChallenges:     maxChallenges,
+		maxUserChallenges: maxUserChallenges,
+	}
+}
+
+// Store returns the credential store
+func (w *WebAuthn) Store() Store {
+	return w.store
+}
+
+// newChallenge creates and stores a new challenge for the given ceremony
+func (w *WebAuthn) newChallenge(username, ceremony string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("Unable to generate challenge: %v", err)
+	}
+	c := base64.RawURLEncoding.EncodeToString(b)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.add(&challenge{value: c, username: username, ceremony: ceremony, expires: time.Now().Add(challengeExpiration)})
+
+	return c, nil
+}
+
+// add stores ch, first discarding expired challenges and the oldest past the limits. The caller must hold w.mu
+func (w *WebAuthn) add(ch *challenge) {
+	// challenges expire in the order they're created, so expired and excess challenges are at the front
+	now := time.Now()
+	for e := w.order.Front(); e != nil; e = w.order.Front() {
+		if !e.Value.(*challenge).expires.Before(now) && w.order.Len() < w.maxChallenges {
+			break
+		}
+		w.remove(e)
+	}
+
+	// usernameless logins aren't limited per user, since they're shared by everyone
+	if user := strings.ToLower(ch.username); user != "" && w.users[user] >= w.maxUserChallenges {
+		for e := w.order.Front(); e != nil; e = e.Next() {
+			if strings.ToLower(e.Value.(*challenge).username) == user {
+				w.remove(e)
+				break
+			}
+		}
+	}
+
+	w.challenges[ch.value] = w.order.PushBack(ch)
+	if ch.username != "" {
+		w.users[strings.ToLower(ch.username)]++
+	}
+}
+
+// remove discards the challenge held by e. The caller must hold w.mu
+func (w *WebAuthn) remove(e *list.Element) {
+	ch := e.Value.(*challenge)
+	delete(w.challenges, ch.value)
+	w.order.Remove(e)
+	if ch.username == "" {
+		return
+	}
+	user := strings.ToLower(ch.username)
+	if w.users[user]--; w.users[user] <= 0 {
+		delete(w.users, user)
+	}
+}
+
+// useChallenge removes and returns the given challenge, or nil if it doesn't exist or has expired
+func (w *WebAuthn) useChallenge(c, ceremony string) *challenge {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	e, ok := w.challenges[c]
+	if !ok {
+		return nil
+	}
+	w.remove(e)
+
+	if ch := e.Value.(*challenge); ch.ceremony == ceremony && !ch.expires.Before(time.Now()) {
+		return ch
+	}
+
+	return nil
+}
+
+// UserVerified returns true if every login verifies the user, e.g. with a PIN or biometric, making the credential
+// a second factor on its own
+func (w *WebAuthn) UserVerified() bool {
+	return w.config.RequireUserVerification
+}
+
+func (w *WebAuthn) userVerification() string {
+	if w.config.RequireUserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// userHandle returns the opaque user handle for the given username
+func userHandle(username string) []byte {
+	h := sha256.Sum256([]byte(strings.ToLower(username)))
+	return h[:16]
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func descriptors(creds []*Credential) []*credentialDescriptor {
+	d := make([]*credentialDescriptor, 0, len(creds))
+	for _, c := range creds {
+		d = append(d, &credentialDescriptor{Type: "public-key", ID: base64.RawURLEncoding.EncodeToString(c.ID)})
+	}
+	return d
+}
+
+// BeginRegistration returns the options to pass to navigator.credentials.create() to register a new credential
+// for the given user. Binary values are base64url encoded
+func (w *WebAuthn) BeginRegistration(username, displayName string) (options interface{}, err error) {
+	type param struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	}
+
+	creds, err := w.store.List(username)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list credentials: %v", err)
+	}
+
+	c, err := w.newChallenge(username, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+
+	var params []*param
+	for _, alg := range supportedAlgorithms {
+		params = append(params, &param{Type: "public-key", Alg: alg})
+	}
+
+	return map[string]interface{}{
+		"rp": map[string]string{"id": w.config.RPID, "name": w.config.RPName},
+		"user": map[string]string{
+			"id":          base64.RawURLEncoding.EncodeToString(userHandle(username)),
+			"name":        username,
+			"displayName": displayName,
+		},
+		"challenge":          c,
+		"pubKeyCredParams":   params,
+		"timeout":            challengeExpiration.Milliseconds(),
+		"attestation":        "none",
+		"excludeCredentials": descriptors(creds),
+		"authenticatorSelection": map[string]string{
+			"residentKey":      "preferred",
+			"userVerification": w.userVerification(),
+		},
+	}, nil
+}
+
+// AttestationResponse is the JSON form of the PublicKeyCredential returned by navigator.credentials.create().
+// Binary values are base64url encoded
+type AttestationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the JSON form of the PublicKeyCredential returned by navigator.credentials.get().
+// Binary values are base64url encoded
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// verifyClientData parses clientDataJSON and checks its type and origin, returning the challenge used
+func (w *WebAuthn) verifyClientData(raw []byte, ceremony string) (*challenge, error) {
+	cd := new(clientData)
+	if err := json.Unmarshal(raw, cd); err != nil {
+		return nil, fmt.Errorf("Unable to parse client data: %v", err)
+	}
+
+	if cd.Type != ceremony {
+		return nil, fmt.Errorf("Unexpected client data type: %s", cd.Type)
+	}
+
+	originOK := false
+	for _, o := range w.config.Origins {
+		if cd.Origin == o {
+			originOK = true
+			break
+		}
+	}
+	if !originOK {
+		return nil, fmt.Errorf("Unexpected origin: %s", cd.Origin)
+	}
+
+	ch := w.useChallenge(cd.Challenge, ceremony)
+	if ch == nil {
+		return nil, errors.New("Unknown or expired challenge")
+	}
+
+	return ch, nil
+}
+
+// verifyAuthData checks the RP ID hash and flags of the authenticator data and returns the flags and signature counter
+func (w *WebAuthn) verifyAuthData(authData []byte) (flags byte, signCount uint32, err error) {
+	if len(authData) < 37 {
+		return 0, 0, errors.New("Authenticator data too short")
+	}
+
+	rpIDHash := sha256.Sum256([]byte(w.config.RPID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, 0, errors.New("RP ID hash mismatch")
+	}
+
+	flags = authData[32]
+	if flags&flagUserPresent == 0 {
+		return 0, 0, errors.New("User not present")
+	}
+	if w.config.RequireUserVerification && flags&flagUserVerified == 0 {
+		return 0, 0, errors.New("User not verified")
+	}
+
+	return flags, binary.BigEndian.Uint32(authData[33:37]), nil
+}
+
+// FinishRegistration verifies the response to a registration ceremony started for username
+// and stores the new credential with the given name. Attestation statements are not verified
+func (w *WebAuthn) FinishRegistration(username, name string, resp *AttestationResponse) (*Credential, error) {
+	rawClientData, err := decode(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode client data: %v", err)
+	}
+
+	ch, err := w.verifyClientData(rawClientData, "webauthn.create")
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(ch.username, username) {
+		return nil, errors.New("Challenge was issued to a different user")
+	}
+
+	rawAttestation, err := decode(resp.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode attestation object: %v", err)
+	}
+
+	v, _, err := decodeCBOR(rawAttestation)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse attestation object: %v", err)
+	}
+
+	att, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("Attestation object is not a map")
+	}
+
+	authData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("Attestation object missing authData")
+	}
+
+	flags, signCount, err := w.verifyAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	if flags&flagAttestedCredentialData == 0 {
+		return nil, errors.New("Authenticator data missing attested credential data")
+	}
+
+	// attested credential data: AAGUID (16), credential ID length (2), credential ID, COSE public key
+	data := authData[37:]
+	if len(data) < 18 {
+		return nil, errors.New("Attested credential data too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(data[16:18]))
+	data = data[18:]
+	if len(data) < idLen {
+		return nil, errors.New("Attested credential data too short")
+	}
+	id, data := data[:idLen], data[idLen:]
+
+	_, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse credential public key: %v", err)
+	}
+	pubKey := data[:len(data)-len(rest)]
+
+	if _, err = parseCOSEKey(pubKey); err != nil {
+		return nil, err
+	}
+
+	existing, err := w.store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to check for existing credential: %v", err)
+	}
+	if existing != nil {
+		return nil, errors.New("Credential is already registered")
+	}
+
+	cred := &Credential{
+		ID:        append([]byte(nil), id...),
+		Username:  username,
+		Name:      name,
+		PublicKey: append([]byte(nil), pubKey...),
+		SignCount: signCount,
+		Created:   time.Now(),
+	}
+
+	if err = w.store.Add(cred); err != nil {
+		return nil, fmt.Errorf("Unable to store credential: %v", err)
+	}
+
+	return cred, nil
+}
+
+// BeginLogin returns the options to pass to navigator.credentials.get(). If username is empty,
+// any discoverable credential may be used. Binary values are base64url encoded
+func (w *WebAuthn) BeginLogin(username string) (options interface{}, err error) {
+	var creds []*Credential
+	if username != "" {
+		if creds, err = w.store.List(username); err != nil {
+			return nil, fmt.Errorf("Unable to list credentials: %v", err)
+		}
+	}
+
+	c, err := w.newChallenge(username, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"challenge":        c,
+		"rpId":             w.config.RPID,
+		"timeout":          challengeExpiration.Milliseconds(),
+		"allowCredentials": descriptors(creds),
+		"userVerification": w.userVerification(),
+	}, nil
+}
+
+// FinishLogin verifies the response to a login ceremony and returns the credential used
+func (w *WebAuthn) FinishLogin(resp *AssertionResponse) (*Credential, error) {
+	id, err := decode(resp.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode credential id: %v", err)
+	}
+
+	rawClientData, err := decode(resp.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode client data: %v", err)
+	}
+
+	authData, err := decode(resp.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode authenticator data: %v", err)
+	}
+
+	sig, err := decode(resp.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decode signature: %v", err)
+	}
+
+	ch, err := w.verifyClientData(rawClientData, "webauthn.get")
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := w.store.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get credential: %v", err)
+	}
+	if cred == nil {
+		return nil, errors.New("Unknown credential")
+	}
+
+	if ch.username != "" && !strings.EqualFold(ch.username, cred.Username) {
+		return nil, errors.New("Credential doesn't belong to user")
+	}
+
+	if resp.Response.UserHandle != "" {
+		handle, err := decode(resp.Response.UserHandle)
+		if err != nil || !bytes.Equal(handle, userHandle(cred.Username)) {
+			return nil, errors.New("User handle mismatch")
+		}
+	}
+
+	_, signCount, err := w.verifyAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientDataHash := sha256.Sum256(rawClientData)
+	if err = key.verify(append(append([]byte(nil), authData...), clientDataHash[:]...), sig); err != nil {
+		return nil, err
+	}
+
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return nil, errors.New("Signature counter did not increase; credential may be cloned")
+	}
+
+	cred.SignCount = signCount
+	cred.LastUsed = time.Now()
+	if err = w.store.Update(cred); err != nil {
+		return nil, fmt.Errorf("Unable to update credential: %v", err)
+	}
+
+	return cred, nil
+}
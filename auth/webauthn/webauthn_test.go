@@ -0,0 +1,296 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fixed vectors for the relying party example.com, made with the keys in cose_test.go
+const (
+	testChallenge = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"
+
+	// a "none" attestation for the Ed25519 credential "cred-ed25519", with the UP, UV and AT flags and sign count 0
+	testAttestationObject = "a363666d74646e6f6e656761747453746d74a068617574684461746159006da379a6f6eeafb9a55e378c11" +
+		"8034e2751e682fab9f2d30ab13d2125586ce1947450000000000000000000000000000000000000000000c637265642d656432" +
+		"35353139a401010327200621582079b5562e8fe654f94078b112e8a98ba7901f853ae695bed7e0e3910bad049664"
+
+	// authenticator data with the UP and UV flags and sign count 1
+	testAuthData = "a379a6f6eeafb9a55e378c118034e2751e682fab9f2d30ab13d2125586ce19470500000001"
+
+	// signatures over testAuthData and the hash of testAssertionClientData
+	testEd25519Signature = "f3bea700ad3627e898326a9a4e79225e93cd35743de6b981d7221f58b5e83610c4f8bf4deede44579a8daad4" +
+		"19c4160dbdc1d29ebe7db1197447fcef8b262a05"
+	testES256Signature = "304502202a666a055fadef8b182eb124c515c6365e0256a5c4d4df8e3eda2519a5aa5399022100d337405b91f1" +
+		"927888175c5c42b961ec9fb889c65908bc0659819cc497904827"
+)
+
+const (
+	testRegistrationClientData = `{"type":"webauthn.create","challenge":"` + testChallenge + `","origin":"https://example.com"}`
+	testAssertionClientData    = `{"type":"webauthn.get","challenge":"` + testChallenge + `","origin":"https://example.com"}`
+)
+
+type testStore struct {
+	mu    *sync.Mutex
+	creds map[string]*Credential
+}
+
+func (s *testStore) Add(c *Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds[string(c.ID)] = c
+	return nil
+}
+
+func (s *testStore) Get(id []byte) (*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.creds[string(id)]
+	if !ok {
+		return nil, nil
+	}
+	cred := *c
+	return &cred, nil
+}
+
+func (s *testStore) List(username string) ([]*Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var creds []*Credential
+	for _, c := range s.creds {
+		if c.Username == username {
+			creds = append(creds, c)
+		}
+	}
+	return creds, nil
+}
+
+func (s *testStore) Update(c *Credential) error {
+	return s.Add(c)
+}
+
+func (s *testStore) Delete(id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.creds, string(id))
+	return nil
+}
+
+// newTestWebAuthn returns a *WebAuthn for example.com with the given credentials and an outstanding challenge
+// for the given ceremony
+func newTestWebAuthn(t *testing.T, ceremony string, creds ...*Credential) *WebAuthn {
+	t.Helper()
+	s := &testStore{mu: new(sync.Mutex), creds: make(map[string]*Credential)}
+	for _, c := range creds {
+		s.Add(c)
+	}
+
+	w := New(&Config{RPID: "example.com", RPName: "Example", Origins: []string{"https://example.com"}}, s)
+	w.add(&challenge{value: testChallenge, username: "user", ceremony: ceremony, expires: time.Now().Add(time.Minute)})
+	return w
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func TestFinishRegistration(t *testing.T) {
+	w := newTestWebAuthn(t, "webauthn.create")
+
+	resp := new(AttestationResponse)
+	resp.ID = encode([]byte("cred-ed25519"))
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = encode([]byte(testRegistrationClientData))
+	resp.Response.AttestationObject = encode(mustHex(t, testAttestationObject))
+
+	cred, err := w.FinishRegistration("user", "key", resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(cred.ID) != "cred-ed25519" || cred.Username != "user" || cred.SignCount != 0 ||
+		!bytes.Equal(cred.PublicKey, mustHex(t, testEd25519Key)) {
+		t.Errorf("unexpected credential: %#v", cred)
+	}
+
+	// the challenge can't be used again
+	if _, err = w.FinishRegistration("user", "key", resp); err == nil {
+		t.Error("expected error for reused challenge")
+	}
+}
+
+func TestFinishRegistrationInvalid(t *testing.T) {
+	authData := mustHex(t, testAttestationObject)[31:]
+	attestation := func(authData []byte) []byte {
+		return append(mustHex(t, testAttestationObject)[:31], authData...)
+	}
+	modified := func(i int, b byte) []byte {
+		d := append([]byte(nil), authData...)
+		d[i] = b
+		return attestation(d)
+	}
+
+	cases := []struct {
+		name        string
+		username    string
+		clientData  string
+		attestation []byte
+	}{
+		{"wrong user", "other", testRegistrationClientData, attestation(authData)},
+		{"wrong type", "user", testAssertionClientData, attestation(authData)},
+		{"wrong origin", "user", `{"type":"webauthn.create","challenge":"` + testChallenge + `","origin":"https://example.org"}`, attestation(authData)},
+		{"unknown challenge", "user", `{"type":"webauthn.create","challenge":"AAAA","origin":"https://example.com"}`, attestation(authData)},
+		{"wrong RP ID hash", "user", testRegistrationClientData, modified(0, 0)},
+		{"user not present", "user", testRegistrationClientData, modified(32, 0x44)},
+		{"no attested credential data", "user", testRegistrationClientData, modified(32, 0x05)},
+		{"credential ID too long", "user", testRegistrationClientData, modified(54, 0xff)},
+		{"truncated attestation object", "user", testRegistrationClientData, attestation(authData[:len(authData)-1])},
+		{"not a map", "user", testRegistrationClientData, mustHex(t, "80")},
+	}
+
+	for _, c := range cases {
+		w := newTestWebAuthn(t, "webauthn.create")
+		resp := new(AttestationResponse)
+		resp.Response.ClientDataJSON = encode([]byte(c.clientData))
+		resp.Response.AttestationObject = encode(c.attestation)
+		if _, err := w.FinishRegistration(c.username, "key", resp); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+}
+
+func testCredentials(t *testing.T) []*Credential {
+	return []*Credential{
+		{ID: []byte("cred-ed25519"), Username: "user", PublicKey: mustHex(t, testEd25519Key)},
+		{ID: []byte("cred-es256"), Username: "user", PublicKey: mustHex(t, testES256Key)},
+	}
+}
+
+func assertion(t *testing.T, id, clientData, authData, sig string) *AssertionResponse {
+	resp := new(AssertionResponse)
+	resp.ID = encode([]byte(id))
+	resp.Type = "public-key"
+	resp.Response.ClientDataJSON = encode([]byte(clientData))
+	resp.Response.AuthenticatorData = encode(mustHex(t, authData))
+	resp.Response.Signature = encode(mustHex(t, sig))
+	return resp
+}
+
+func TestFinishLogin(t *testing.T) {
+	for id, sig := range map[string]string{"cred-ed25519": testEd25519Signature, "cred-es256": testES256Signature} {
+		w := newTestWebAuthn(t, "webauthn.get", testCredentials(t)...)
+
+		cred, err := w.FinishLogin(assertion(t, id, testAssertionClientData, testAuthData, sig))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", id, err)
+		}
+		if string(cred.ID) != id || cred.SignCount != 1 || cred.LastUsed.IsZero() {
+			t.Errorf("%s: unexpected credential: %#v", id, cred)
+		}
+
+		// the assertion can't be replayed with a new challenge, since the sign count didn't increase
+		w.add(&challenge{value: testChallenge, username: "user", ceremony: "webauthn.get", expires: time.Now().Add(time.Minute)})
+		if _, err = w.FinishLogin(assertion(t, id, testAssertionClientData, testAuthData, sig)); err == nil {
+			t.Errorf("%s: expected error for replayed assertion", id)
+		}
+	}
+}
+
+func TestFinishLoginInvalid(t *testing.T) {
+	modified := func(s string, i int, b byte) string {
+		d := mustHex(t, s)
+		d[i] ^= b
+		return hex.EncodeToString(d)
+	}
+
+	cases := []struct {
+		name       string
+		id         string
+		clientData string
+		authData   string
+		sig        string
+	}{
+		{"modified signature", "cred-ed25519", testAssertionClientData, testAuthData, modified(testEd25519Signature, 10, 1)},
+		{"modified ES256 signature", "cred-es256", testAssertionClientData, testAuthData, modified(testES256Signature, 10, 1)},
+		{"other credential's signature", "cred-es256", testAssertionClientData, testAuthData, testEd25519Signature},
+		{"modified sign count", "cred-ed25519", testAssertionClientData, modified(testAuthData, 36, 2), testEd25519Signature},
+		{"wrong RP ID hash", "cred-ed25519", testAssertionClientData, modified(testAuthData, 0, 1), testEd25519Signature},
+		{"user not present", "cred-ed25519", testAssertionClientData, modified(testAuthData, 32, flagUserPresent), testEd25519Signature},
+		{"short authenticator data", "cred-ed25519", testAssertionClientData, testAuthData[:60], testEd25519Signature},
+		{"unknown credential", "cred-other", testAssertionClientData, testAuthData, testEd25519Signature},
+		{"wrong type", "cred-ed25519", testRegistrationClientData, testAuthData, testEd25519Signature},
+		{"wrong origin", "cred-ed25519", `{"type":"webauthn.get","challenge":"` + testChallenge + `","origin":"https://example.org"}`, testAuthData, testEd25519Signature},
+	}
+
+	for _, c := range cases {
+		w := newTestWebAuthn(t, "webauthn.get", testCredentials(t)...)
+		if _, err := w.FinishLogin(assertion(t, c.id, c.clientData, c.authData, c.sig)); err == nil {
+			t.Errorf("%s: expected error", c.name)
+		}
+	}
+
+	// credentials of other users can't be used when logging in as a user
+	w := newTestWebAuthn(t, "webauthn.get", testCredentials(t)...)
+	w.remove(w.challenges[testChallenge])
+	w.add(&challenge{value: testChallenge, username: "other", ceremony: "webauthn.get", expires: time.Now().Add(time.Minute)})
+	if _, err := w.FinishLogin(assertion(t, "cred-ed25519", testAssertionClientData, testAuthData, testEd25519Signature)); err == nil {
+		t.Error("expected error for another user's credential")
+	}
+}
+
+func TestChallengeLimit(t *testing.T) {
+	w := newTestWebAuthn(t, "webauthn.get")
+	w.remove(w.challenges[testChallenge])
+	w.maxChallenges, w.maxUserChallenges = 4, 2
+
+	newChallenge := func(username string) string {
+		t.Helper()
+		c, err := w.newChallenge(username, "webauthn.get")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// the oldest challenges for a user are discarded past the per-user limit
+	user := []string{newChallenge("jdoe12"), newChallenge("JDoe12"), newChallenge("jdoe12")}
+	if w.useChallenge(user[0], "webauthn.get") != nil {
+		t.Error("expected oldest challenge for user to be discarded")
+	}
+	if w.users["jdoe12"] != 2 || len(w.challenges) != 2 {
+		t.Errorf("expected 2 challenges for user, got %d of %d", w.users["jdoe12"], len(w.challenges))
+	}
+
+	// usernameless challenges aren't limited per user, but the oldest challenges are discarded past the overall limit
+	var anon []string
+	for i := 0; i < 4; i++ {
+		anon = append(anon, newChallenge(""))
+	}
+	if len(w.challenges) != 4 || w.order.Len() != 4 || w.users["jdoe12"] != 0 {
+		t.Errorf("expected 4 usernameless challenges, got %d, %d, %d for user", len(w.challenges), w.order.Len(), w.users["jdoe12"])
+	}
+	for _, c := range user[1:] {
+		if w.useChallenge(c, "webauthn.get") != nil {
+			t.Error("expected oldest challenge to be discarded")
+		}
+	}
+	for _, c := range anon {
+		if w.useChallenge(c, "webauthn.get") == nil {
+			t.Error("expected usernameless challenge to be kept")
+		}
+	}
+	if len(w.challenges) != 0 || w.order.Len() != 0 || len(w.users) != 0 {
+		t.Errorf("expected no challenges, got %d, %d, %d", len(w.challenges), w.order.Len(), len(w.users))
+	}
+
+	// expired challenges are discarded before the limits are reached
+	w.maxChallenges = 10
+	expired := newChallenge("jdoe12")
+	w.challenges[expired].Value.(*challenge).expires = time.Now().Add(-time.Second)
+	newChallenge("asmith7")
+	if _, ok := w.challenges[expired]; ok || w.users["jdoe12"] != 0 {
+		t.Error("expected expired challenge to be discarded")
+	}
+}
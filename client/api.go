@@ -0,0 +1,281 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	"github.com/korylprince/userbrowser-server/v3/audit"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+// Session is a logged in session
+type Session struct {
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	SessionID   string `json:"session_id"`
+	Admin       bool   `json:"admin"`
+}
+
+// Login authenticates with the client's credentials and stores the new session ID.
+// If the user must provide a second factor, a *MFARequiredError is returned
+func (c *Client) Login() (*Session, error) {
+	type request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	type response struct {
+		Session
+		MFARequired bool   `json:"mfa_required"`
+		MFAEnrolled bool   `json:"mfa_enrolled"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	resp := new(response)
+	if err := c.send(http.MethodPost, "/auth", "", &request{Username: c.username, Password: c.password}, resp); err != nil {
+		return nil, err
+	}
+
+	if resp.MFARequired {
+		return nil, &MFARequiredError{Username: resp.Username, Enrolled: resp.MFAEnrolled, Token: resp.MFAToken}
+	}
+
+	c.mu.Lock()
+	c.sessionID = resp.SessionID
+	c.mu.Unlock()
+
+	return &resp.Session, nil
+}
+
+// LoginMFA completes a login with the token from a *MFARequiredError and a TOTP or recovery code,
+// and stores the new session ID
+func (c *Client) LoginMFA(token, code string) (*Session, error) {
+	type request struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	resp := new(Session)
+	if err := c.send(http.MethodPost, "/auth/mfa", "", &request{MFAToken: token, Code: code}, resp); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.sessionID = resp.SessionID
+	c.mu.Unlock()
+
+	return resp, nil
+}
+
+// ListUsers returns the users the client is permitted to see
+func (c *Client) ListUsers() ([]*db.User, error) {
+	var users []*db.User
+	if err := c.Do(http.MethodGet, "/users", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ResetPassword resets the user's password and returns the new password
+func (c *Client) ResetPassword(username string) (string, error) {
+	pass, _, err := c.ResetPasswordSync(username)
+	return pass, err
+}
+
+// ResetPasswordSync resets the user's password and returns the new password
+// and the result of syncing it to each of the server's password sync targets.
+// If the reset requires approval, an *ApprovalPendingError is returned
+func (c *Client) ResetPasswordSync(username string) (string, []*pwsync.Result, error) {
+	resp := new(resetResponse)
+	if err := c.Do(http.MethodPost, "/users/"+url.PathEscape(username)+"/reset", nil, resp); err != nil {
+		return "", nil, err
+	}
+
+	if resp.Password == "" && resp.Approval != nil {
+		return "", nil, &ApprovalPendingError{Request: resp.Approval}
+	}
+
+	return resp.Password, resp.Sync, nil
+}
+
+type resetResponse struct {
+	Password string            `json:"password"`
+	Sync     []*pwsync.Result  `json:"sync"`
+	Approval *approval.Request `json:"approval"`
+}
+
+// ListApprovals returns approval requests with the given status (or pending requests if empty) for students
+// the client is permitted to see, newest first
+func (c *Client) ListApprovals(status approval.Status) ([]*approval.Request, error) {
+	path := "/approvals"
+	if status != "" {
+		path += "?status=" + url.QueryEscape(string(status))
+	}
+
+	var requests []*approval.Request
+	if err := c.Do(http.MethodGet, path, nil, &requests); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// ApproveReset approves the pending request and returns the new password and sync results
+func (c *Client) ApproveReset(id string) (string, []*pwsync.Result, error) {
+	resp := new(resetResponse)
+	if err := c.Do(http.MethodPost, "/approvals/"+url.PathEscape(id)+"/approve", nil, resp); err != nil {
+		return "", nil, err
+	}
+	return resp.Password, resp.Sync, nil
+}
+
+// RejectReset rejects the pending request with an optional reason
+func (c *Client) RejectReset(id, reason string) (*approval.Request, error) {
+	type request struct {
+		Reason string `json:"reason,omitempty"`
+	}
+
+	req := new(approval.Request)
+	if err := c.Do(http.MethodPost, "/approvals/"+url.PathEscape(id)+"/reject", &request{Reason: reason}, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// APIKey is an API key. Token is only set when the key is created
+type APIKey struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Permissions []auth.GradeRange `json:"permissions"`
+	Actions     []string          `json:"actions,omitempty"`
+	CreatedBy   string            `json:"created_by"`
+	Created     time.Time         `json:"created"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	Revoked     *time.Time        `json:"revoked,omitempty"`
+	LastUsed    *time.Time        `json:"last_used,omitempty"`
+	Token       string            `json:"token,omitempty"`
+}
+
+// ListAPIKeys returns all API keys
+func (c *Client) ListAPIKeys() ([]*APIKey, error) {
+	var keys []*APIKey
+	if err := c.Do(http.MethodGet, "/apikeys", nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CreateAPIKey creates a new API key. If actions is empty, the key can be used for every action.
+// If expires is the zero time, the key doesn't expire
+func (c *Client) CreateAPIKey(name string, permissions []auth.GradeRange, actions []string, expires time.Time) (*APIKey, error) {
+	type request struct {
+		Name        string            `json:"name"`
+		Permissions []auth.GradeRange `json:"permissions"`
+		Actions     []string          `json:"actions,omitempty"`
+		Expires     *time.Time        `json:"expires,omitempty"`
+	}
+
+	req := &request{Name: name, Permissions: permissions, Actions: actions}
+	if !expires.IsZero() {
+		req.Expires = &expires
+	}
+
+	key := new(APIKey)
+	if err := c.Do(http.MethodPost, "/apikeys", req, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey revokes the API key with the given ID
+func (c *Client) RevokeAPIKey(id string) (*APIKey, error) {
+	key := new(APIKey)
+	if err := c.Do(http.MethodDelete, "/apikeys/"+url.PathEscape(id), nil, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// QueryAudit returns audit records matching q, newest first
+func (c *Client) QueryAudit(q *audit.Query) ([]*audit.Record, error) {
+	v := make(url.Values)
+	if q.Actor != "" {
+		v.Set("actor", q.Actor)
+	}
+	if q.Target != "" {
+		v.Set("target", q.Target)
+	}
+	if q.Action != "" {
+		v.Set("action", q.Action)
+	}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	path := "/audit"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+
+	var records []*audit.Record
+	if err := c.Do(http.MethodGet, path, nil, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// ActiveSession is an active session. ID is a handle for the session, not the session ID
+type ActiveSession struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Expires     time.Time `json:"expires"`
+}
+
+// ListSessions returns all active sessions
+func (c *Client) ListSessions() ([]*ActiveSession, error) {
+	var sessions []*ActiveSession
+	if err := c.Do(http.MethodGet, "/sessions", nil, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteSession logs out the session with the given handle
+func (c *Client) DeleteSession(id string) error {
+	return c.Do(http.MethodDelete, "/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// ListWebhookDeliveries returns webhook deliveries with the given status (or all if empty), newest first.
+// If limit is 0, the server's default limit is used
+func (c *Client) ListWebhookDeliveries(status webhook.Status, limit int) ([]*webhook.Delivery, error) {
+	v := make(url.Values)
+	if status != "" {
+		v.Set("status", string(status))
+	}
+	if limit > 0 {
+		v.Set("limit", strconv.Itoa(limit))
+	}
+
+	path := "/webhooks/deliveries"
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+
+	var deliveries []*webhook.Delivery
+	if err := c.Do(http.MethodGet, path, nil, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
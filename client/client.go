@@ -0,0 +1,170 @@
+// Package client is a client for the userbrowser API
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/korylprince/userbrowser-server/v3/version"
+)
+
+// Client is a userbrowser API client. It's safe for concurrent use
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+
+	username string
+	password string
+	apiKey   string
+
+	mu        *sync.Mutex
+	sessionID string
+}
+
+// Option configures a Client
+type Option func(c *Client)
+
+// WithCredentials authenticates with the given username and password.
+// The client logs in on the first request, and again if the session expires
+func WithCredentials(username, password string) Option {
+	return func(c *Client) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// WithAPIKey authenticates with the given API key
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithSession authenticates with an existing session ID
+func WithSession(id string) Option {
+	return func(c *Client) {
+		c.sessionID = id
+	}
+}
+
+// WithHTTPClient uses the given *http.Client instead of http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// New returns a new *Client for the server at baseURL, e.g. https://example.com/prefix
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + "/api/" + version.API,
+		httpClient: http.DefaultClient,
+		mu:         new(sync.Mutex),
+	}
+
+	for _, o := range options {
+		o(c)
+	}
+
+	return c
+}
+
+// SessionID returns the current session ID, or an empty string if the client hasn't logged in
+func (c *Client) SessionID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionID
+}
+
+// token returns the bearer token for a request, logging in first if necessary
+func (c *Client) token() (string, error) {
+	if c.apiKey != "" {
+		return c.apiKey, nil
+	}
+
+	if id := c.SessionID(); id != "" || c.username == "" {
+		return id, nil
+	}
+
+	if _, err := c.Login(); err != nil {
+		return "", err
+	}
+
+	return c.SessionID(), nil
+}
+
+// send sends a request to the API and decodes the response into out if it's not nil.
+// Bodies are encoded as JSON. A non-2xx response is returned as an *Error
+func (c *Client) send(method, path, token string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("Unable to encode request: %v", err)
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("Unable to create request: %v", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("Unable to read response: %v", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newError(resp.StatusCode, buf)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err = json.Unmarshal(buf, out); err != nil {
+		return fmt.Errorf("Unable to decode response: %v", err)
+	}
+
+	return nil
+}
+
+// Do sends an authenticated request to the API path (relative to /api/3.0) and decodes the response into out.
+// If the session has expired and the client has credentials, it logs in again and retries once
+func (c *Client) Do(method, path string, in, out interface{}) error {
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+
+	err = c.send(method, path, token, in, out)
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusUnauthorized || c.apiKey != "" || c.username == "" {
+		return err
+	}
+
+	if _, err = c.Login(); err != nil {
+		return err
+	}
+
+	return c.send(method, path, c.SessionID(), in, out)
+}
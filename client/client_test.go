@@ -0,0 +1,263 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/version"
+)
+
+// testServer is a fake API that issues numbered sessions for the password "password"
+// and counts logins and requests to /users
+type testServer struct {
+	mu       *sync.Mutex
+	sessions map[string]bool
+	logins   int
+	requests int
+	// rejectAll makes /users return 401 for every token
+	rejectAll bool
+	// users is the response to /users, or if nil, a 404 error
+	users []*db.User
+}
+
+func newTestServer(t *testing.T) (*testServer, *httptest.Server) {
+	s := &testServer{mu: new(sync.Mutex), sessions: make(map[string]bool), users: []*db.User{{Username: "jdoe12"}}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/"+version.API+"/auth", s.auth)
+	mux.HandleFunc("/api/"+version.API+"/users", s.list)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (s *testServer) auth(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password != "password" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"error": "Invalid username or password", "error_code": "invalid_credentials",
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logins++
+	id := "session" + strconv.Itoa(s.logins)
+	s.sessions[id] = true
+	writeJSON(w, http.StatusOK, &Session{Username: req.Username, SessionID: id})
+}
+
+func (s *testServer) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+
+	token := r.Header.Get("Authorization")
+	if s.rejectAll || len(token) < 7 || !s.sessions[token[7:]] {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"code": 401, "description": "Unauthorized"})
+		return
+	}
+	if s.users == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "User not found", "error_code": "not_found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.users)
+}
+
+func (s *testServer) counts() (logins, requests int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins, s.requests
+}
+
+func TestRelogin(t *testing.T) {
+	s, srv := newTestServer(t)
+	c := New(srv.URL+"/", WithCredentials("staff", "password"))
+
+	// the client logs in on the first request
+	if users, err := c.ListUsers(); err != nil || len(users) != 1 {
+		t.Fatalf("unexpected result: %v, %v", users, err)
+	}
+	if logins, requests := s.counts(); logins != 1 || requests != 1 {
+		t.Errorf("expected 1 login and 1 request, got %d and %d", logins, requests)
+	}
+
+	// an expired session is replaced and the request retried
+	s.mu.Lock()
+	delete(s.sessions, c.SessionID())
+	s.mu.Unlock()
+	if users, err := c.ListUsers(); err != nil || len(users) != 1 {
+		t.Fatalf("unexpected result: %v, %v", users, err)
+	}
+	if logins, requests := s.counts(); logins != 2 || requests != 3 {
+		t.Errorf("expected 2 logins and 3 requests, got %d and %d", logins, requests)
+	}
+	if id := c.SessionID(); id != "session2" {
+		t.Errorf("expected new session ID, got %q", id)
+	}
+
+	// without credentials, the 401 is returned
+	c = New(srv.URL, WithSession("expired"))
+	if _, err := c.ListUsers(); !IsUnauthorized(err) {
+		t.Errorf("expected 401, got %v", err)
+	}
+	if logins, _ := s.counts(); logins != 2 {
+		t.Errorf("expected no login, got %d", logins-2)
+	}
+}
+
+func TestUnauthorizedRetry(t *testing.T) {
+	s, srv := newTestServer(t)
+	s.rejectAll = true
+	c := New(srv.URL, WithCredentials("staff", "password"))
+
+	// a 401 after logging in again is returned instead of retried
+	if _, err := c.ListUsers(); !IsUnauthorized(err) {
+		t.Errorf("expected 401, got %v", err)
+	}
+	if logins, requests := s.counts(); logins != 2 || requests != 2 {
+		t.Errorf("expected 2 logins and 2 requests, got %d and %d", logins, requests)
+	}
+
+	// a failed login is returned
+	c = New(srv.URL, WithCredentials("staff", "wrong"))
+	err := c.Do(http.MethodGet, "/users", nil, nil)
+	if e := new(Error); !errors.As(err, &e) || !IsUnauthorized(err) || e.Code != "invalid_credentials" {
+		t.Errorf("expected invalid credentials, got %v", err)
+	}
+	if _, requests := s.counts(); requests != 2 {
+		t.Errorf("expected no request after a failed login, got %d", requests-2)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	var auth []string
+	var logins int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/"+version.API+"/auth", func(w http.ResponseWriter, r *http.Request) {
+		logins++
+		writeJSON(w, http.StatusOK, &Session{SessionID: "session"})
+	})
+	mux.HandleFunc("/api/"+version.API+"/users", func(w http.ResponseWriter, r *http.Request) {
+		auth = append(auth, r.Header.Get("Authorization"))
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"code": 401, "description": "Unauthorized"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// the key is sent as a bearer token, and a 401 isn't retried with the credentials
+	c := New(srv.URL, WithAPIKey("ubk_key"), WithCredentials("staff", "password"))
+	if _, err := c.ListUsers(); !IsUnauthorized(err) {
+		t.Errorf("expected 401, got %v", err)
+	}
+	if len(auth) != 1 || auth[0] != "Bearer ubk_key" {
+		t.Errorf("expected one request with the API key, got %q", auth)
+	}
+	if logins != 0 {
+		t.Errorf("expected no login, got %d", logins)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, srv := newTestServer(t)
+	s.users = nil
+	c := New(srv.URL, WithCredentials("staff", "password"))
+
+	// an errResponse is user-facing
+	_, err := c.ListUsers()
+	e := new(Error)
+	if !errors.As(err, &e) || !IsNotFound(err) {
+		t.Fatalf("expected 404, got %v", err)
+	}
+	if e.Description != "User not found" || !e.UserFacing || e.Code != "not_found" {
+		t.Errorf("unexpected error: %+v", e)
+	}
+	if IsUnauthorized(err) || IsForbidden(err) || IsNotFound(errors.New("not found")) {
+		t.Error("unexpected status match")
+	}
+
+	// a jsonResponse isn't
+	e = newError(http.StatusInternalServerError, []byte(
+		`{"code":500,"description":"Internal Server Error","error_code":"directory_unavailable","debug":"timeout"}`))
+	if e.UserFacing || e.Code != "directory_unavailable" || e.Debug != "timeout" {
+		t.Errorf("unexpected error: %+v", e)
+	}
+
+	// a body that isn't JSON keeps the status text
+	if e = newError(http.StatusBadGateway, []byte("<html>")); e.Description != "Bad Gateway" || e.UserFacing {
+		t.Errorf("unexpected error: %+v", e)
+	}
+}
+
+func TestMFARequired(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/"+version.API+"/auth", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"username": "staff", "mfa_required": true, "mfa_enrolled": true, "mfa_token": "token",
+		})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL, WithCredentials("staff", "password"))
+	_, err := c.Login()
+	mfa := new(MFARequiredError)
+	if !errors.As(err, &mfa) {
+		t.Fatalf("expected *MFARequiredError, got %v", err)
+	}
+	if mfa.Username != "staff" || !mfa.Enrolled || mfa.Token != "token" {
+		t.Errorf("unexpected error: %+v", mfa)
+	}
+	if c.SessionID() != "" {
+		t.Errorf("expected no session, got %q", c.SessionID())
+	}
+
+	// requests needing a session return the error
+	if _, err = c.ListUsers(); !errors.As(err, &mfa) {
+		t.Errorf("expected *MFARequiredError, got %v", err)
+	}
+}
+
+func TestApprovalPending(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/"+version.API+"/users/jdoe12/reset", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"approval": &approval.Request{ID: "request", Username: "jdoe12", Status: approval.StatusPending},
+		})
+	})
+	mux.HandleFunc("/api/"+version.API+"/users/asmith7/reset", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"password": "new"})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := New(srv.URL, WithSession("session"))
+	_, err := c.ResetPassword("jdoe12")
+	pending := new(ApprovalPendingError)
+	if !errors.As(err, &pending) {
+		t.Fatalf("expected *ApprovalPendingError, got %v", err)
+	}
+	if pending.Request.ID != "request" || pending.Request.Status != approval.StatusPending {
+		t.Errorf("unexpected request: %+v", pending.Request)
+	}
+
+	if pass, err := c.ResetPassword("asmith7"); err != nil || pass != "new" {
+		t.Errorf("unexpected result: %q, %v", pass, err)
+	}
+}
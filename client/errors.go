@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/korylprince/userbrowser-server/v3/approval"
+)
+
+// Error is an error returned by the server
+type Error struct {
+	StatusCode int
+	// Description is the HTTP status text, or the user-facing message if the server returned one
+	Description string
+	// Debug is set if the server is running in debug mode
+	Debug string
+	// UserFacing is true if Description is a message meant to be shown to the user
+	UserFacing bool
+	// Code is a machine-readable code for the error, e.g. "not_found" or "directory_unavailable", if the server returned one
+	Code string
+}
+
+func (e *Error) Error() string {
+	if e.Debug != "" {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Description, e.Debug)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Description)
+}
+
+// newError decodes an error response body. The server returns either a jsonResponse or an errResponse
+func newError(code int, body []byte) *Error {
+	var resp struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+		Debug       string `json:"debug"`
+		Err         string `json:"error"`
+		ErrorCode   string `json:"error_code"`
+	}
+
+	e := &Error{StatusCode: code, Description: http.StatusText(code)}
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return e
+	}
+
+	e.Code = resp.ErrorCode
+
+	if resp.Err != "" {
+		e.Description = resp.Err
+		e.UserFacing = true
+		return e
+	}
+
+	if resp.Description != "" {
+		e.Description = resp.Description
+	}
+	e.Debug = resp.Debug
+
+	return e
+}
+
+// IsUnauthorized returns true if err is an *Error with status 401
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized)
+}
+
+// IsForbidden returns true if err is an *Error with status 403
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsNotFound returns true if err is an *Error with status 404
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+func hasStatus(err error, code int) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == code
+}
+
+// MFARequiredError is returned by Login when the user must provide a second factor.
+// Use Client.LoginMFA to complete the login
+type MFARequiredError struct {
+	Username string
+	Enrolled bool
+	Token    string
+}
+
+func (e *MFARequiredError) Error() string {
+	return fmt.Sprintf("Second factor required for %s", e.Username)
+}
+
+// ApprovalPendingError is returned when a reset requires approval by a second user.
+// The reset happens when the request is approved with Client.ApproveReset
+type ApprovalPendingError struct {
+	Request *approval.Request
+}
+
+func (e *ApprovalPendingError) Error() string {
+	return fmt.Sprintf("Reset for %s is waiting for approval (request %s)", e.Request.Username, e.Request.ID)
+}
@@ -0,0 +1,45 @@
+// Command auditverify walks a userbrowser-server audit log and reports the first record that breaks the hash chain.
+// The log is also checked against its checkpoint (the file with ".checkpoint" appended), so records removed from the
+// start or end of the log are reported.
+//
+// Usage:
+//
+//	auditverify [-file path] [-hmac-key key]
+//
+// The file and HMAC key default to $USERBROWSER_AUDITSTOREPATH and $USERBROWSER_AUDITHMACKEY.
+// The exit status is 0 if the log is intact, 1 if the chain is broken, and 2 on other errors.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/korylprince/userbrowser-server/v3/audit"
+	"github.com/korylprince/userbrowser-server/v3/audit/file"
+)
+
+func main() {
+	path := flag.String("file", os.Getenv("USERBROWSER_AUDITSTOREPATH"), "path to audit log")
+	key := flag.String("hmac-key", os.Getenv("USERBROWSER_AUDITHMACKEY"), "audit HMAC key; HMACs aren't checked if empty")
+	flag.Parse()
+
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	n, err := file.New(*path, []byte(*key)).Verify()
+
+	var chainErr *audit.ChainError
+	if errors.As(err, &chainErr) {
+		fmt.Println(chainErr)
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to verify audit log:", err)
+		os.Exit(2)
+	}
+
+	fmt.Printf("Verified %d records\n", n)
+}
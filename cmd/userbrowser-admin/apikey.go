@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	"github.com/korylprince/userbrowser-server/v3/apikey/file"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/config"
+)
+
+func apiKeyStore(conf *config.Config) apikey.Store {
+	if conf.APIKeyStorePath == "" {
+		fatal("USERBROWSER_APIKEYSTOREPATH is not set")
+	}
+	return file.New(conf.APIKeyStorePath)
+}
+
+type apiKeyOutput struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Permissions []auth.GradeRange `json:"permissions"`
+	Actions     []string          `json:"actions,omitempty"`
+	CreatedBy   string            `json:"created_by"`
+	Created     time.Time         `json:"created"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	Revoked     *time.Time        `json:"revoked,omitempty"`
+	LastUsed    *time.Time        `json:"last_used,omitempty"`
+	Token       string            `json:"token,omitempty"`
+}
+
+func newAPIKeyOutput(k *apikey.Key) *apiKeyOutput {
+	return &apiKeyOutput{
+		ID:          k.ID,
+		Name:        k.Name,
+		Permissions: k.Permissions,
+		Actions:     k.Actions,
+		CreatedBy:   k.CreatedBy,
+		Created:     k.Created,
+		Expires:     timePtr(k.Expires),
+		Revoked:     timePtr(k.Revoked),
+		LastUsed:    timePtr(k.LastUsed),
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func apiKeyRow(k *apikey.Key) string {
+	actions := "all"
+	if len(k.Actions) > 0 {
+		actions = strings.Join(k.Actions, ",")
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s", k.ID, k.Name, formatRanges(k.Permissions), actions,
+		k.CreatedBy, formatTime(k.Created), formatTime(k.Expires), formatTime(k.Revoked), formatTime(k.LastUsed))
+}
+
+const apiKeyHeader = "ID\tNAME\tPERMISSIONS\tACTIONS\tCREATED BY\tCREATED\tEXPIRES\tREVOKED\tLAST USED"
+
+func createAPIKey(conf *config.Config, args []string) {
+	fs := flag.NewFlagSet("apikey create", flag.ExitOnError)
+	name := fs.String("name", "", "name of the key, e.g. the integration using it")
+	perms := fs.String("permissions", "", `grade ranges the key can access, e.g. "9<>12;-1<>0"`)
+	acts := fs.String("actions", "", "comma separated actions the key can be used for: "+
+		strings.Join(apikey.Actions, ", ")+"; all actions if empty")
+	expires := fs.String("expires", "", "expiration date (YYYY-MM-DD); never expires if empty")
+	createdBy := fs.String("created-by", "", "user creating the key; defaults to the current OS user")
+	fs.Parse(args)
+
+	if *name == "" || *perms == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	permissions, err := auth.ParseGradeRanges(*perms)
+	if err != nil {
+		fatal("Invalid permissions:", err)
+	}
+
+	var actions []string
+	if *acts != "" {
+		for _, a := range strings.Split(*acts, ",") {
+			a = strings.TrimSpace(a)
+			if !apikey.ValidAction(a) {
+				fatal("Invalid action:", a)
+			}
+			actions = append(actions, a)
+		}
+	}
+
+	var exp time.Time
+	if *expires != "" {
+		if exp, err = time.ParseInLocation("2006-01-02", *expires, time.Local); err != nil {
+			fatal("Invalid expiration:", err)
+		}
+	}
+
+	if *createdBy == "" {
+		if u, err := user.Current(); err == nil {
+			*createdBy = u.Username
+		}
+	}
+
+	token, key, err := apikey.Generate(*name, permissions, actions, exp, *createdBy)
+	if err != nil {
+		fatal("Unable to generate key:", err)
+	}
+
+	if err = apiKeyStore(conf).Add(key); err != nil {
+		fatal("Unable to store key:", err)
+	}
+
+	out := newAPIKeyOutput(key)
+	out.Token = token
+
+	render(out, "ID\tTOKEN", []string{key.ID + "\t" + token})
+	if *format == "table" {
+		fmt.Println("The token can't be shown again.")
+	}
+}
+
+func listAPIKeys(conf *config.Config) {
+	keys, err := apiKeyStore(conf).List()
+	if err != nil {
+		fatal("Unable to list keys:", err)
+	}
+
+	out := make([]*apiKeyOutput, 0, len(keys))
+	rows := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, newAPIKeyOutput(k))
+		rows = append(rows, apiKeyRow(k))
+	}
+
+	render(out, apiKeyHeader, rows)
+}
+
+func revokeAPIKey(conf *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: userbrowser-admin apikey revoke <id>")
+		os.Exit(2)
+	}
+
+	store := apiKeyStore(conf)
+
+	key, err := store.Get(args[0])
+	if err != nil {
+		fatal("Unable to get key:", err)
+	}
+
+	if key == nil {
+		fatal("Key doesn't exist:", args[0])
+	}
+
+	if key.Revoked.IsZero() {
+		key.Revoked = time.Now()
+		if err = store.Update(key); err != nil {
+			fatal("Unable to revoke key:", err)
+		}
+	}
+
+	render(newAPIKeyOutput(key), apiKeyHeader, []string{apiKeyRow(key)})
+}
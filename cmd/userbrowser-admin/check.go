@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/config"
+)
+
+func formatRanges(ranges []auth.GradeRange) string {
+	strs := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		strs = append(strs, fmt.Sprintf("%d<>%d", r.MinGrade, r.MaxGrade))
+	}
+	return strings.Join(strs, ";")
+}
+
+func checkConfig(conf *config.Config) {
+	type permission struct {
+		Source string            `json:"source"`
+		Group  string            `json:"group"`
+		Ranges []auth.GradeRange `json:"ranges"`
+		Admin  bool              `json:"admin"`
+	}
+
+	var (
+		perms []*permission
+		rows  []string
+	)
+
+	add := func(source string, m map[string][]auth.GradeRange, adminGroups []string) {
+		admin := make(map[string]bool)
+		groups := make([]string, 0, len(m))
+		for g := range m {
+			groups = append(groups, g)
+		}
+		for _, g := range adminGroups {
+			if _, ok := m[g]; !ok {
+				groups = append(groups, g)
+			}
+			admin[g] = true
+		}
+		sort.Strings(groups)
+
+		for _, g := range groups {
+			perms = append(perms, &permission{Source: source, Group: g, Ranges: m[g], Admin: admin[g]})
+			rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%t", source, g, formatRanges(m[g]), admin[g]))
+		}
+	}
+
+	add("ldap", conf.PermissionsMap(), conf.AdminGroups)
+	if conf.OIDCIssuer != "" {
+		add("oidc", conf.OIDCPermissionsMap(), conf.OIDCAdminGroups)
+	}
+
+	render(perms, "SOURCE\tGROUP\tGRADES\tADMIN", rows)
+}
+
+func checkLDAP(conf *config.Config) {
+	type check struct {
+		Check string `json:"check"`
+		Error string `json:"error,omitempty"`
+	}
+
+	d := newDB(conf)
+
+	var (
+		checks []*check
+		rows   []string
+		failed bool
+	)
+
+	type namedCheck struct {
+		name string
+		f    func() error
+	}
+
+	var all []namedCheck
+
+	pool := conf.DCPool()
+	for _, server := range pool.Servers() {
+		config := *pool.Base()
+		config.Server, config.Port = server.Host, server.Port
+		all = append(all, namedCheck{"connect " + server.String(), func() error {
+			conn, err := config.Connect()
+			if err != nil {
+				return err
+			}
+			conn.Conn.Close()
+			return nil
+		}})
+	}
+
+	all = append(all,
+		namedCheck{"bind and search", func() error { return d.Check(context.Background()) }},
+		namedCheck{"secure token key", func() error { return d.CheckKey(conf.SecureTokenSample) }},
+	)
+
+	for _, c := range all {
+		result := &check{Check: c.name}
+		status := "ok"
+		if err := c.f(); err != nil {
+			result.Error = err.Error()
+			status = err.Error()
+			failed = true
+		}
+		checks = append(checks, result)
+		rows = append(rows, c.name+"\t"+status)
+	}
+
+	render(checks, "CHECK\tRESULT", rows)
+
+	if failed {
+		os.Exit(1)
+	}
+}
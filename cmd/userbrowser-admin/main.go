@@ -0,0 +1,135 @@
+// Command userbrowser-admin manages a userbrowser-server installation using the server's configuration.
+//
+// Usage:
+//
+//	userbrowser-admin [-format table|json] [-tenant name] <command> [options]
+//
+// Commands:
+//
+//	users list [-grade n] [-passwords]             list students
+//	users reset <username>                         reset a student's password on the running server
+//	token decrypt <username>                       show the password stored in a student's adminDescription
+//	token reencrypt [-old-key key] <username>...   re-encrypt students' adminDescription with the current key
+//	config check                                   validate the configuration and show parsed permissions
+//	ldap check                                     test connecting to each server, binding and searching
+//	sessions list|delete <id>                      manage sessions on the running server
+//	apikey create|list|revoke                      manage API keys
+//
+// Configuration is read from the same USERBROWSER_* environment variables as the server.
+// If the server hosts several tenants, -tenant selects the tenant to manage.
+// The users reset and sessions commands log in to the running server as an administrator;
+// see "userbrowser-admin sessions -h" for options.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/config"
+	"github.com/korylprince/userbrowser-server/v3/db/ldap"
+)
+
+var (
+	format     = flag.String("format", "table", "output format: table or json")
+	tenantName = flag.String("tenant", "", "tenant to manage; required if USERBROWSER_TENANTS is set")
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: userbrowser-admin [-format table|json] [-tenant name] users|token|config|ldap|sessions|apikey <command> [options]")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(v ...interface{}) {
+	fmt.Fprintln(os.Stderr, v...)
+	os.Exit(1)
+}
+
+// render writes v as JSON, or header and rows (tab separated columns) as a table
+func render(v interface{}, header string, rows []string) {
+	if *format == "json" {
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		if err := e.Encode(v); err != nil {
+			fatal("Unable to write JSON:", err)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, header)
+	for _, r := range rows {
+		fmt.Fprintln(w, r)
+	}
+	w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func newDB(conf *config.Config) *ldap.DB {
+	return ldap.New(conf.DCPool(), conf.LDAPBindUPN, conf.LDAPBindPassword, conf.SecureTokenKey, conf.Grades(), conf.Debug)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 2 || (*format != "table" && *format != "json") {
+		usage()
+	}
+
+	conf, err := config.Load()
+	if err != nil {
+		fatal(err)
+	}
+
+	if len(conf.TenantConfigs()) > 0 || *tenantName != "" {
+		if *tenantName == "" {
+			fatal("-tenant is required when USERBROWSER_TENANTS is set")
+		}
+		t := conf.Tenant(*tenantName)
+		if t == nil {
+			fatal("Unknown tenant:", *tenantName)
+		}
+		conf = t
+	}
+
+	cmd, sub, args := flag.Arg(0), flag.Arg(1), flag.Args()[2:]
+
+	switch strings.Join([]string{cmd, sub}, " ") {
+	case "users list":
+		listUsers(conf, args)
+	case "users reset":
+		resetPassword(conf, args)
+	case "token decrypt":
+		decryptToken(conf, args)
+	case "token reencrypt":
+		reencryptToken(conf, args)
+	case "config check":
+		checkConfig(conf)
+	case "ldap check":
+		checkLDAP(conf)
+	case "sessions list":
+		listSessions(conf, args)
+	case "sessions delete":
+		deleteSession(conf, args)
+	case "apikey create":
+		createAPIKey(conf, args)
+	case "apikey list":
+		listAPIKeys(conf)
+	case "apikey revoke":
+		revokeAPIKey(conf, args)
+	default:
+		usage()
+	}
+}
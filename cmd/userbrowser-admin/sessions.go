@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/korylprince/userbrowser-server/v3/client"
+	"github.com/korylprince/userbrowser-server/v3/config"
+)
+
+// newClient parses the server flags and returns a client logged in as an administrator
+func newClient(conf *config.Config, name string, args []string) (*client.Client, *flag.FlagSet) {
+	server := "http://localhost" + conf.ListenAddr + conf.Prefix + conf.PathPrefix
+	if _, port, err := net.SplitHostPort(conf.ListenAddr); err == nil {
+		server = "http://" + net.JoinHostPort("localhost", port) + conf.Prefix + conf.PathPrefix
+	}
+
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	url := fs.String("server", server, "URL of the server, including the prefix")
+	username := fs.String("username", os.Getenv("USERBROWSER_ADMINUSERNAME"), "administrator username; defaults to $USERBROWSER_ADMINUSERNAME. The password is read from $USERBROWSER_ADMINPASSWORD")
+	fs.Parse(args)
+
+	password := os.Getenv("USERBROWSER_ADMINPASSWORD")
+	if *username == "" || password == "" {
+		fatal("An administrator username and USERBROWSER_ADMINPASSWORD are required")
+	}
+
+	c := client.New(*url, client.WithCredentials(*username, password))
+	if _, err := c.Login(); err != nil {
+		if _, ok := err.(*client.MFARequiredError); ok {
+			fatal("Administrator accounts using MFA can't be used with userbrowser-admin")
+		}
+		fatal("Unable to log in:", err)
+	}
+
+	return c, fs
+}
+
+func listSessions(conf *config.Config, args []string) {
+	c, _ := newClient(conf, "sessions list", args)
+
+	sessions, err := c.ListSessions()
+	if err != nil {
+		fatal("Unable to list sessions:", err)
+	}
+
+	rows := make([]string, 0, len(sessions))
+	for _, s := range sessions {
+		rows = append(rows, fmt.Sprintf("%s\t%s\t%s\t%s", s.ID, s.Username, s.DisplayName, formatTime(s.Expires)))
+	}
+
+	render(sessions, "ID\tUSERNAME\tNAME\tEXPIRES", rows)
+}
+
+func deleteSession(conf *config.Config, args []string) {
+	c, fs := newClient(conf, "sessions delete", args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: userbrowser-admin sessions delete [options] <id>")
+		os.Exit(2)
+	}
+
+	if err := c.DeleteSession(fs.Arg(0)); err != nil {
+		if client.IsNotFound(err) {
+			fatal("Session doesn't exist:", fs.Arg(0))
+		}
+		fatal("Unable to delete session:", err)
+	}
+
+	fmt.Println("Deleted session", fs.Arg(0))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/korylprince/userbrowser-server/v3/config"
+)
+
+func decryptToken(conf *config.Config, args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: userbrowser-admin token decrypt <username>")
+		os.Exit(2)
+	}
+
+	pass, err := newDB(conf).DecryptToken(context.Background(), args[0])
+	if err != nil {
+		fatal("Unable to decrypt token:", err)
+	}
+
+	render(map[string]string{"username": args[0], "password": pass}, "USERNAME\tPASSWORD", []string{args[0] + "\t" + pass})
+}
+
+func reencryptToken(conf *config.Config, args []string) {
+	fs := flag.NewFlagSet("token reencrypt", flag.ExitOnError)
+	oldKey := fs.String("old-key", os.Getenv("USERBROWSER_OLDSECURETOKENKEY"), "key the tokens are currently encrypted with; defaults to $USERBROWSER_OLDSECURETOKENKEY, or the current key if empty")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	type result struct {
+		Username string `json:"username"`
+		Error    string `json:"error,omitempty"`
+	}
+
+	d := newDB(conf)
+
+	var (
+		results []*result
+		rows    []string
+		failed  bool
+	)
+	for _, username := range fs.Args() {
+		r := &result{Username: username}
+		status := "ok"
+		if err := d.ReencryptToken(context.Background(), username, *oldKey); err != nil {
+			r.Error = err.Error()
+			status = err.Error()
+			failed = true
+		}
+		results = append(results, r)
+		rows = append(rows, username+"\t"+status)
+	}
+
+	render(results, "USERNAME\tRESULT", rows)
+
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/korylprince/userbrowser-server/v3/client"
+	"github.com/korylprince/userbrowser-server/v3/config"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
+)
+
+func listUsers(conf *config.Config, args []string) {
+	fs := flag.NewFlagSet("users list", flag.ExitOnError)
+	grade := fs.Int("grade", -2, "only list students in this grade (-1 for Pre-K)")
+	passwords := fs.Bool("passwords", false, "include passwords")
+	fs.Parse(args)
+
+	users, err := newDB(conf).List(context.Background())
+	if err != nil {
+		fatal("Unable to list users:", err)
+	}
+
+	filtered := make([]*db.User, 0, len(users))
+	rows := make([]string, 0, len(users))
+	for _, u := range users {
+		if *grade != -2 && u.Grade != *grade {
+			continue
+		}
+
+		if !*passwords {
+			u.Password = ""
+		}
+
+		filtered = append(filtered, u)
+		row := fmt.Sprintf("%s\t%s\t%s\t%d", u.Username, u.FirstName, u.LastName, u.Grade)
+		if *passwords {
+			row += "\t" + u.Password
+		}
+		rows = append(rows, row)
+	}
+
+	header := "USERNAME\tFIRST NAME\tLAST NAME\tGRADE"
+	if *passwords {
+		header += "\tPASSWORD"
+	}
+
+	render(filtered, header, rows)
+}
+
+// resetPassword resets the password through the running server, so the reset is audited, fires webhooks,
+// follows the approval policy, and updates the server's cache
+func resetPassword(conf *config.Config, args []string) {
+	c, fs := newClient(conf, "users reset", args)
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: userbrowser-admin users reset [options] <username>")
+		os.Exit(2)
+	}
+	username := fs.Arg(0)
+
+	pass, results, err := c.ResetPasswordSync(username)
+	if err != nil {
+		var pending *client.ApprovalPendingError
+		if errors.As(err, &pending) {
+			fmt.Println(pending)
+			return
+		}
+		if client.IsNotFound(err) {
+			fatal("User doesn't exist:", username)
+		}
+		fatal("Unable to reset password:", err)
+	}
+
+	type output struct {
+		Username string           `json:"username"`
+		Password string           `json:"password"`
+		Sync     []*pwsync.Result `json:"sync,omitempty"`
+	}
+
+	var (
+		rows   []string
+		failed bool
+	)
+	for _, r := range results {
+		status := "ok"
+		if !r.Success {
+			status = "failed: " + r.Error
+			failed = true
+		}
+		rows = append(rows, username+"\t"+pass+"\t"+r.Target+": "+status)
+	}
+	if len(rows) == 0 {
+		rows = append(rows, username+"\t"+pass+"\t-")
+	}
+
+	render(&output{Username: username, Password: pass, Sync: results}, "USERNAME\tPASSWORD\tSYNC", rows)
+
+	if failed {
+		os.Exit(1)
+	}
+}
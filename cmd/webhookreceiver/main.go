@@ -0,0 +1,66 @@
+// Command webhookreceiver is a local HTTP receiver for testing userbrowser-server webhooks.
+// It verifies each payload's signature and prints the event.
+//
+// Usage:
+//
+//	webhookreceiver [-listen :9000] [-secret secret] [-status 200] [-tolerance 5m]
+//
+// The secret defaults to $USERBROWSER_WEBHOOKSECRET. Set -status to a non-2xx code to test retries.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+func main() {
+	listen := flag.String("listen", ":9000", "address to listen on")
+	secret := flag.String("secret", os.Getenv("USERBROWSER_WEBHOOKSECRET"), "webhook secret")
+	status := flag.Int("status", http.StatusOK, "status code to respond with after a valid delivery")
+	tolerance := flag.Duration("tolerance", 5*time.Minute, "maximum signature age; 0 disables the check")
+	flag.Parse()
+
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "A secret is required")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+		if err != nil {
+			log.Println("Unable to read body:", err)
+			http.Error(w, "Unable to read body", http.StatusBadRequest)
+			return
+		}
+
+		if err = webhook.Verify([]byte(*secret), r.Header.Get(webhook.HeaderSignature), body, *tolerance); err != nil {
+			log.Printf("Rejected delivery %s: %v\n", r.Header.Get(webhook.HeaderDelivery), err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		e := new(webhook.Event)
+		if err = json.Unmarshal(body, e); err != nil {
+			log.Println("Unable to decode event:", err)
+			http.Error(w, "Unable to decode event", http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Delivery %s: %s target=%s actor=%s api_key=%s time=%s\n", r.Header.Get(webhook.HeaderDelivery),
+			e.Type, e.Target, e.Actor, e.APIKey, e.Time.Format(time.RFC3339))
+
+		w.WriteHeader(*status)
+	})
+
+	log.Println("Listening on:", *listen)
+	log.Println(http.ListenAndServe(*listen, nil))
+}
@@ -0,0 +1,574 @@
+// Package config reads the server configuration from the environment
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	adauth "github.com/korylprince/go-ad-auth/v3"
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/dc"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
+	"github.com/korylprince/userbrowser-server/v3/pwsync/command"
+	"github.com/korylprince/userbrowser-server/v3/pwsync/endpoint"
+	"github.com/korylprince/userbrowser-server/v3/version"
+)
+
+// ParsePermissions parses the format "{Group Name}:{min-grade}<>{max-grade};{min-grade}<>{max-grade};...,..."
+func ParsePermissions(str string) (map[string][]auth.GradeRange, error) {
+	permissions := make(map[string][]auth.GradeRange)
+	for _, group := range strings.Split(str, ",") {
+		splits := strings.Split(group, ":")
+		if len(splits) != 2 {
+			return nil, fmt.Errorf("Unable to parse group: %s", group)
+		}
+
+		groupName := strings.TrimSpace(splits[0])
+		ranges := strings.TrimSpace(splits[1])
+
+		gradeRanges, err := auth.ParseGradeRanges(ranges)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions[groupName] = gradeRanges
+	}
+
+	if len(permissions) == 0 {
+		return nil, fmt.Errorf("No permissions found: %s", str)
+	}
+	return permissions, nil
+}
+
+// ParseGradeMap parses the format "{OU name}:{grade},..."
+func ParseGradeMap(str string) (map[string]int, error) {
+	grades := make(map[string]int)
+	for _, entry := range strings.Split(str, ",") {
+		idx := strings.LastIndex(entry, ":")
+		if idx == -1 {
+			return nil, fmt.Errorf("Unable to parse grade: %s", entry)
+		}
+
+		grade, err := strconv.Atoi(strings.TrimSpace(entry[idx+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse grade %s: %v", entry, err)
+		}
+
+		grades[strings.TrimSpace(entry[:idx])] = grade
+	}
+
+	return grades, nil
+}
+
+// newLogSink parses a log output in the format "{stdout|stderr|udp://host:port|tcp://host:port|unix:///path}[?format={json|cef}]".
+// stdout and stderr write one entry per line; the network outputs send RFC 5424 syslog messages
+func newLogSink(output string, appName string, facility int) (logsink.Sink, error) {
+	u, err := url.Parse(output)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse output %s: %v", output, err)
+	}
+
+	var formatter logsink.Formatter
+	switch f := u.Query().Get("format"); f {
+	case "", "json":
+		formatter = logsink.JSONFormatter{}
+	case "cef":
+		formatter = logsink.CEFFormatter{Vendor: "korylprince", Product: "userbrowser-server", Version: version.API}
+	default:
+		return nil, fmt.Errorf("Unknown format for output %s: %s", output, f)
+	}
+
+	switch u.Scheme {
+	case "":
+		switch u.Path {
+		case "stdout":
+			return logsink.NewWriter(os.Stdout, formatter), nil
+		case "stderr":
+			return logsink.NewWriter(os.Stderr, formatter), nil
+		}
+	case "udp", "tcp":
+		return logsink.NewSyslog(u.Scheme, u.Host, appName, facility, formatter), nil
+	case "unix":
+		return logsink.NewSyslog(u.Scheme, u.Path, appName, facility, formatter), nil
+	}
+
+	return nil, fmt.Errorf("Unknown output: %s", output)
+}
+
+// newSyncTarget parses a password sync target in the format "exec:///path/to/command[?arg=...&arg=...][#name]"
+// or "{http|https}://[user:pass@]host/path[#name]". The name defaults to the command's file name or the URL's host
+func newSyncTarget(target string, secret []byte, timeout time.Duration) (pwsync.Target, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse target %s: %v", target, err)
+	}
+
+	name := u.Fragment
+	u.Fragment = ""
+
+	switch u.Scheme {
+	case "exec":
+		if u.Path == "" {
+			return nil, fmt.Errorf("Missing command path: %s", target)
+		}
+		if name == "" {
+			name = path.Base(u.Path)
+		}
+		return command.New(name, u.Path, u.Query()["arg"], timeout), nil
+	case "http", "https":
+		if name == "" {
+			name = u.Host
+		}
+		return endpoint.New(name, u.String(), secret, timeout), nil
+	}
+
+	return nil, fmt.Errorf("Unknown target: %s", target)
+}
+
+// Config represents options given in the environment.
+// Each tenant is configured with every option except the process-wide options, prefixed with USERBROWSER_{NAME}_
+type Config struct {
+	Tenants    []string //names of tenants; if set the directory options below are only read for each tenant
+	Hosts      []string //tenant only: hostnames routed to the tenant
+	PathPrefix string   //tenant only: path routed to the tenant, e.g. /north
+	name       string
+	prefix     string
+	tenants    []*Config
+
+	SessionExpiration int `default:"15"` //in minutes
+
+	LDAPServer       []string //one or more servers "host[:port]", tried in order; required unless LDAPDiscoveryDomain is set
+	LDAPPort         int      `default:"389"`
+	LDAPBaseDN       string   //required
+	LDAPBindUPN      string   //required
+	LDAPBindPassword string   //required
+	LDAPSecurity     string   `default:"none"`
+	ldapSecurity     adauth.SecurityType
+	ldapServers      []dc.Server
+
+	LDAPDiscoveryDomain string //discover servers from the domain's _ldap._tcp.dc._msdcs SRV records, using LDAPPort; they're tried before LDAPServer
+	LDAPDNSServer       string //DNS server "host:port" used for discovery; defaults to the system resolver
+	LDAPRoundRobin      bool   `default:"false"` //spread connections across servers instead of preferring the first
+	LDAPBackoffSeconds  int    `default:"30"`    //how long a server is skipped after it fails
+
+	LDAPRetries                int `default:"2"`  //how many times reads are retried when the directory is unavailable; 0 disables
+	LDAPBreakerThreshold       int `default:"5"`  //failed operations in a row before requests fail fast; 0 disables
+	LDAPBreakerCooldownSeconds int `default:"30"` //how long requests fail fast before the directory is tried again
+
+	CacheSeconds int `default:"0"` //how long student lists are cached; 0 disables
+
+	LDAPIndex            bool `default:"false"` //serve student lists from an in-memory index updated from uSNChanged; can't be used with CacheSeconds
+	LDAPIndexSeconds     int  `default:"10"`    //how often the index is updated
+	LDAPIndexFullSeconds int  `default:"3600"`  //how often the index is replaced with a full search, to remove deleted students
+
+	// deadlines in seconds for directory operations made while handling a request; 0 disables the deadline
+	AuthTimeout  int `default:"10"`
+	GetTimeout   int `default:"10"`
+	ListTimeout  int `default:"30"`
+	ResetTimeout int `default:"15"`
+
+	GradeMap string //see ParseGradeMap for format; defaults to ldap.DefaultGrades
+	grades   map[string]int
+
+	Permissions string //required; see ParsePermissions for format
+	permissions map[string][]auth.GradeRange
+	AdminGroups []string //groups whose members can access every student and admin-only endpoints
+
+	SecureTokenKey    string //required
+	SecureTokenSample string //token created with SecureTokenKey, used by /readyz to verify the key
+
+	OIDCIssuer        string //enables OpenID Connect logins if set
+	OIDCClientID      string
+	OIDCClientSecret  string
+	OIDCRedirectURL   string
+	OIDCScopes        []string `default:"profile,email"`
+	OIDCUsernameClaim string   `default:"preferred_username"`
+	OIDCGroupsClaim   string   `default:"groups"`
+	OIDCPermissions   string   //same format as Permissions; defaults to Permissions
+	oidcPermissions   map[string][]auth.GradeRange
+	OIDCAdminGroups   []string //defaults to AdminGroups
+
+	MFAStorePath      string   //enables TOTP multi-factor authentication if set
+	MFAIssuer         string   `default:"User Browser"`
+	MFARequiredGroups []string //permission groups that must use MFA
+
+	WebAuthnStorePath string   //enables WebAuthn logins if set
+	WebAuthnRPID      string   //usually the domain name of the web frontend
+	WebAuthnRPName    string   `default:"User Browser"`
+	WebAuthnOrigins   []string //origins of the web frontend, e.g. https://userbrowser.example.com
+	WebAuthnRequireUV bool     `default:"false"` //require user verification (PIN or biometric), so logins satisfy MFARequiredGroups
+
+	APIKeyStorePath string //enables API keys if set
+
+	AuditStorePath     string //enables the audit log if set
+	AuditRetentionDays int    `default:"365"` //0 keeps records forever
+	AuditHMACKey       string //optional key used to sign audit records; should differ from SecureTokenKey
+
+	WebhookURLs          []string //endpoints notified of password resets and account changes
+	WebhookEvents        []string //event types sent; all if empty
+	WebhookSecret        string   //key used to sign payloads; required if WebhookURLs is set
+	WebhookStorePath     string   //delivery queue and history; required if WebhookURLs is set
+	WebhookMaxAttempts   int      `default:"10"`
+	WebhookRetentionDays int      `default:"30"` //0 keeps delivery history forever
+
+	ApprovalStorePath       string   //enables two-person approval for resets matching ApprovalGrades or ApprovalGroups if set
+	ApprovalGrades          string   //grade ranges requiring approval, e.g. "-1<>0;9<>12"
+	ApprovalGroups          []string //groups whose direct members require approval
+	ApprovalExpirationHours int      `default:"24"`
+	approvalGrades          []auth.GradeRange
+
+	PasswordSyncTargets     []string //see newSyncTarget for format
+	PasswordSyncSecret      string   //optional key used to sign requests to HTTP targets
+	PasswordSyncTimeout     int      `default:"10"` //in seconds
+	PasswordSyncMaxAttempts int      `default:"5"`
+
+	// process-wide options
+
+	LogOutputs        []string `default:"stdout"` //see newLogSink for format
+	LogBufferSize     int      `default:"1000"`
+	LogSyslogFacility int      `default:"16"` //local0
+	LogAppName        string   `default:"userbrowser"`
+
+	Metrics     bool   `default:"false"` //serve Prometheus metrics at /metrics on MetricsAddr
+	MetricsAddr string `default:":9090"` //listen address for metrics, separate from ListenAddr so tenants can't read them
+
+	HealthCacheSeconds int `default:"30"` //how long /readyz caches each check
+
+	WebUI bool `default:"false"` //serve the built-in web frontend at Prefix/
+
+	ListenAddr string `default:":8080" required:"true"` //addr format used for net.Dial; required
+	Prefix     string //url prefix to mount api to without trailing slash
+	Debug      bool   `default:"false"` //return debugging information to client
+	TrustProxy bool   `default:"false"` //use X-Forwarded-For to determine client IP
+}
+
+// processWide copies the options shared by every tenant from c to t
+func (c *Config) processWide(t *Config) {
+	t.LogOutputs = c.LogOutputs
+	t.LogBufferSize = c.LogBufferSize
+	t.LogSyslogFacility = c.LogSyslogFacility
+	t.LogAppName = c.LogAppName
+	t.Metrics = c.Metrics
+	t.MetricsAddr = c.MetricsAddr
+	t.HealthCacheSeconds = c.HealthCacheSeconds
+	t.WebUI = c.WebUI
+	t.ListenAddr = c.ListenAddr
+	t.Prefix = c.Prefix
+	t.Debug = c.Debug
+	t.TrustProxy = c.TrustProxy
+}
+
+var tenantNameRegexp = regexp.MustCompile("^[a-zA-Z0-9]+$")
+
+// Load reads and validates the configuration from the environment
+func Load() (*Config, error) {
+	config := &Config{prefix: "USERBROWSER"}
+
+	err := envconfig.Process(config.prefix, config)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading configuration from environment: %v", err)
+	}
+
+	for _, output := range config.LogOutputs {
+		if _, err := newLogSink(strings.TrimSpace(output), config.LogAppName, config.LogSyslogFacility); err != nil {
+			return nil, fmt.Errorf("Invalid USERBROWSER_LOGOUTPUTS: %v", err)
+		}
+	}
+
+	if config.Metrics && (config.MetricsAddr == "" || config.MetricsAddr == config.ListenAddr) {
+		return nil, fmt.Errorf("Invalid USERBROWSER_METRICSADDR: metrics must be served on a different address than USERBROWSER_LISTENADDR")
+	}
+
+	if len(config.Tenants) == 0 {
+		if err = config.validate(); err != nil {
+			return nil, err
+		}
+		if err = checkStorePaths(config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+
+	names := make(map[string]bool)
+	for _, name := range config.Tenants {
+		name = strings.TrimSpace(name)
+		if !tenantNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("Invalid USERBROWSER_TENANTS: tenant names must be alphanumeric: %s", name)
+		}
+		if names[strings.ToUpper(name)] {
+			return nil, fmt.Errorf("Invalid USERBROWSER_TENANTS: duplicate name: %s", name)
+		}
+		names[strings.ToUpper(name)] = true
+
+		t := &Config{name: name, prefix: "USERBROWSER_" + strings.ToUpper(name)}
+		if err = envconfig.Process(t.prefix, t); err != nil {
+			return nil, fmt.Errorf("Error reading configuration for tenant %s from environment: %v", name, err)
+		}
+
+		if len(t.Hosts) == 0 && t.PathPrefix == "" {
+			return nil, fmt.Errorf("%s_HOSTS or %s_PATHPREFIX is required", t.prefix, t.prefix)
+		}
+
+		config.processWide(t)
+
+		if err = t.validate(); err != nil {
+			return nil, err
+		}
+
+		config.tenants = append(config.tenants, t)
+	}
+
+	if err = checkStorePaths(config.tenants...); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// checkStorePaths returns an error if two stores, in the same or different configurations, use the same file
+func checkStorePaths(configs ...*Config) error {
+	used := make(map[string]string)
+	for _, c := range configs {
+		for _, store := range []struct{ key, path string }{
+			{"MFASTOREPATH", c.MFAStorePath},
+			{"WEBAUTHNSTOREPATH", c.WebAuthnStorePath},
+			{"APIKEYSTOREPATH", c.APIKeyStorePath},
+			{"AUDITSTOREPATH", c.AuditStorePath},
+			{"WEBHOOKSTOREPATH", c.WebhookStorePath},
+			{"APPROVALSTOREPATH", c.ApprovalStorePath},
+		} {
+			if store.path == "" {
+				continue
+			}
+			key := c.prefix + "_" + store.key
+			p := filepath.Clean(store.path)
+			if other, ok := used[p]; ok {
+				return fmt.Errorf("Invalid %s: %s already uses %s", key, other, store.path)
+			}
+			used[p] = key
+		}
+	}
+	return nil
+}
+
+// validate checks the directory and feature options and parses them
+func (c *Config) validate() error {
+	for _, required := range []struct{ key, value string }{
+		{"LDAPBASEDN", c.LDAPBaseDN},
+		{"LDAPBINDUPN", c.LDAPBindUPN},
+		{"LDAPBINDPASSWORD", c.LDAPBindPassword},
+		{"PERMISSIONS", c.Permissions},
+		{"SECURETOKENKEY", c.SecureTokenKey},
+	} {
+		if required.value == "" {
+			return fmt.Errorf("Error reading configuration from environment: required key %s_%s missing value", c.prefix, required.key)
+		}
+	}
+
+	if len(c.LDAPServer) == 0 && c.LDAPDiscoveryDomain == "" {
+		return fmt.Errorf("Error reading configuration from environment: required key %s_LDAPSERVER missing value", c.prefix)
+	}
+
+	for _, server := range c.LDAPServer {
+		s, err := dc.ParseServer(strings.TrimSpace(server), c.LDAPPort)
+		if err != nil {
+			return fmt.Errorf("Invalid %s_LDAPSERVER: %v", c.prefix, err)
+		}
+		c.ldapServers = append(c.ldapServers, s)
+	}
+
+	switch strings.ToLower(c.LDAPSecurity) {
+	case "", "none":
+		c.ldapSecurity = adauth.SecurityNone
+	case "tls":
+		c.ldapSecurity = adauth.SecurityTLS
+	case "starttls":
+		c.ldapSecurity = adauth.SecurityStartTLS
+	default:
+		return fmt.Errorf("Invalid %s_LDAPSECURITY: %s", c.prefix, c.LDAPSecurity)
+	}
+
+	var err error
+	if c.GradeMap != "" {
+		if c.grades, err = ParseGradeMap(c.GradeMap); err != nil {
+			return fmt.Errorf("Invalid %s_GRADEMAP: %v", c.prefix, err)
+		}
+	}
+
+	if c.permissions, err = ParsePermissions(c.Permissions); err != nil {
+		return fmt.Errorf("Invalid %s_PERMISSIONS: %v", c.prefix, err)
+	}
+
+	if c.LDAPIndex && (c.LDAPIndexSeconds <= 0 || c.LDAPIndexFullSeconds < c.LDAPIndexSeconds) {
+		return fmt.Errorf("%[1]s_LDAPINDEXSECONDS must be positive and at most %[1]s_LDAPINDEXFULLSECONDS", c.prefix)
+	}
+
+	if c.LDAPIndex && c.CacheSeconds > 0 {
+		return fmt.Errorf("%[1]s_CACHESECONDS can't be used with %[1]s_LDAPINDEX", c.prefix)
+	}
+
+	if c.WebAuthnStorePath != "" && (c.WebAuthnRPID == "" || len(c.WebAuthnOrigins) == 0) {
+		return fmt.Errorf("%[1]s_WEBAUTHNRPID and %[1]s_WEBAUTHNORIGINS are required when %[1]s_WEBAUTHNSTOREPATH is set", c.prefix)
+	}
+
+	if c.ApprovalStorePath != "" {
+		if c.ApprovalGrades == "" && len(c.ApprovalGroups) == 0 {
+			return fmt.Errorf("%[1]s_APPROVALGRADES or %[1]s_APPROVALGROUPS is required when %[1]s_APPROVALSTOREPATH is set", c.prefix)
+		}
+		if c.ApprovalGrades != "" {
+			if c.approvalGrades, err = auth.ParseGradeRanges(c.ApprovalGrades); err != nil {
+				return fmt.Errorf("Invalid %s_APPROVALGRADES: %v", c.prefix, err)
+			}
+		}
+	}
+
+	if _, err = c.SyncTargets(); err != nil {
+		return err
+	}
+
+	if len(c.WebhookURLs) > 0 {
+		if c.WebhookSecret == "" || c.WebhookStorePath == "" {
+			return fmt.Errorf("%[1]s_WEBHOOKSECRET and %[1]s_WEBHOOKSTOREPATH are required when %[1]s_WEBHOOKURLS is set", c.prefix)
+		}
+		for _, u := range c.WebhookURLs {
+			if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+				return fmt.Errorf("Invalid %s_WEBHOOKURLS: %s", c.prefix, u)
+			}
+		}
+	}
+
+	if c.OIDCIssuer != "" {
+		if c.OIDCClientID == "" || c.OIDCRedirectURL == "" {
+			return fmt.Errorf("%[1]s_OIDCCLIENTID and %[1]s_OIDCREDIRECTURL are required when %[1]s_OIDCISSUER is set", c.prefix)
+		}
+
+		c.oidcPermissions = c.permissions
+		if c.OIDCPermissions != "" {
+			if c.oidcPermissions, err = ParsePermissions(c.OIDCPermissions); err != nil {
+				return fmt.Errorf("Invalid %s_OIDCPERMISSIONS: %v", c.prefix, err)
+			}
+		}
+
+		if len(c.OIDCAdminGroups) == 0 {
+			c.OIDCAdminGroups = c.AdminGroups
+		}
+	}
+
+	return nil
+}
+
+// TenantConfigs returns the configuration of each tenant, or nil if Tenants isn't set
+func (c *Config) TenantConfigs() []*Config {
+	return c.tenants
+}
+
+// Tenant returns the configuration of the tenant with the given name, or nil if it doesn't exist
+func (c *Config) Tenant(name string) *Config {
+	for _, t := range c.tenants {
+		if strings.EqualFold(t.name, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Name returns the name of the tenant, or an empty string if c isn't a tenant's configuration
+func (c *Config) Name() string {
+	return c.name
+}
+
+// Grades returns the parsed GradeMap, or nil if it isn't set
+func (c *Config) Grades() map[string]int {
+	return c.grades
+}
+
+// DCPool returns a new pool of the configured and discovered domain controllers
+func (c *Config) DCPool() *dc.Pool {
+	config := &dc.Config{
+		Base:       &adauth.Config{BaseDN: c.LDAPBaseDN, Security: c.ldapSecurity},
+		Servers:    c.ldapServers,
+		Domain:     c.LDAPDiscoveryDomain,
+		Port:       c.LDAPPort,
+		RoundRobin: c.LDAPRoundRobin,
+		Backoff:    time.Duration(c.LDAPBackoffSeconds) * time.Second,
+
+		Retries:          c.LDAPRetries,
+		BreakerThreshold: c.LDAPBreakerThreshold,
+		BreakerCooldown:  time.Duration(c.LDAPBreakerCooldownSeconds) * time.Second,
+
+		Tenant: c.name,
+	}
+
+	if c.LDAPDNSServer != "" {
+		config.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return new(net.Dialer).DialContext(ctx, network, c.LDAPDNSServer)
+			},
+		}
+	}
+
+	return dc.New(config)
+}
+
+// PermissionsMap returns the parsed Permissions
+func (c *Config) PermissionsMap() map[string][]auth.GradeRange {
+	return c.permissions
+}
+
+// OIDCPermissionsMap returns the parsed OIDCPermissions, or the parsed Permissions if OIDCPermissions isn't set
+func (c *Config) OIDCPermissionsMap() map[string][]auth.GradeRange {
+	return c.oidcPermissions
+}
+
+// ApprovalPolicy returns the policy for which resets require approval
+func (c *Config) ApprovalPolicy() *approval.Policy {
+	var groups []string
+	for _, g := range c.ApprovalGroups {
+		groups = append(groups, strings.TrimSpace(g))
+	}
+	return &approval.Policy{Grades: c.approvalGrades, Groups: groups}
+}
+
+// SyncTargets returns the PasswordSyncTargets
+func (c *Config) SyncTargets() ([]pwsync.Target, error) {
+	var targets []pwsync.Target
+	names := make(map[string]bool)
+	for _, t := range c.PasswordSyncTargets {
+		target, err := newSyncTarget(strings.TrimSpace(t), []byte(c.PasswordSyncSecret), time.Duration(c.PasswordSyncTimeout)*time.Second)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s_PASSWORDSYNCTARGETS: %v", c.prefix, err)
+		}
+		if names[target.Name()] {
+			return nil, fmt.Errorf("Invalid %s_PASSWORDSYNCTARGETS: duplicate name: %s", c.prefix, target.Name())
+		}
+		names[target.Name()] = true
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// LogSink returns a new sink writing to every LogOutputs output
+func (c *Config) LogSink() (logsink.Sink, error) {
+	var sinks []logsink.Sink
+	for _, output := range c.LogOutputs {
+		sink, err := newLogSink(strings.TrimSpace(output), c.LogAppName, c.LogSyslogFacility)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid USERBROWSER_LOGOUTPUTS: %v", err)
+		}
+		sinks = append(sinks, logsink.NewBuffered(sink, c.LogBufferSize))
+	}
+	return logsink.Multi(sinks...), nil
+}
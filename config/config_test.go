@@ -0,0 +1,32 @@
+package config
+
+import "testing"
+
+func TestCheckStorePaths(t *testing.T) {
+	north := &Config{prefix: "USERBROWSER_NORTH", AuditStorePath: "/data/north/audit.log", MFAStorePath: "/data/north/mfa.json"}
+
+	for _, test := range []struct {
+		name  string
+		south *Config
+		valid bool
+	}{
+		{"separate", &Config{prefix: "USERBROWSER_SOUTH", AuditStorePath: "/data/south/audit.log"}, true},
+		{"unset", &Config{prefix: "USERBROWSER_SOUTH"}, true},
+		{"shared", &Config{prefix: "USERBROWSER_SOUTH", AuditStorePath: "/data/north/audit.log"}, false},
+		{"shared after cleaning", &Config{prefix: "USERBROWSER_SOUTH", AuditStorePath: "/data/south/../north/audit.log"}, false},
+		{"different store", &Config{prefix: "USERBROWSER_SOUTH", APIKeyStorePath: "/data/north/mfa.json"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkStorePaths(north, test.south)
+			if test.valid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if !test.valid && err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	if err := checkStorePaths(&Config{prefix: "USERBROWSER", AuditStorePath: "/data/store", WebhookStorePath: "/data/store"}); err == nil {
+		t.Error("expected error for stores sharing a file in one configuration")
+	}
+}
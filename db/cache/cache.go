@@ -0,0 +1,146 @@
+// Package cache provides a db.DB that caches the results of another db.DB
+package cache
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/db"
+)
+
+type entry struct {
+	users   []*db.User
+	expires time.Time
+}
+
+// call is an in-flight query shared by every caller that requests it before it completes
+type call struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	users   []*db.User
+	err     error
+}
+
+// DB caches the result of List from another DB for a fixed TTL. Concurrent requests for the list share one query.
+// Users returned from DB are shared between callers and must not be modified
+type DB struct {
+	db     db.DB
+	ttl    time.Duration
+	tenant string
+
+	mu   *sync.Mutex
+	list *entry
+	call *call
+	// gen is incremented when the list is invalidated, so queries started before then aren't cached
+	gen uint64
+}
+
+// New returns a new *DB that caches results from next for ttl. tenant labels its metrics
+func New(next db.DB, ttl time.Duration, tenant string) *DB {
+	return &DB{db: next, ttl: ttl, tenant: tenant, mu: new(sync.Mutex)}
+}
+
+// Get returns the user with the given username, nil if the user doesn't exist, or an error if one occurred.
+// Get isn't cached, since its groups and grade are used for authorization and approval decisions
+func (d *DB) Get(ctx context.Context, username string) (*db.User, error) {
+	return d.db.Get(ctx, username)
+}
+
+// List returns a list of all Users from the database or an error if one occurred. The cached list is returned if it
+// hasn't expired. Otherwise the list is queried, sharing a query in flight. The shared query is canceled if every
+// caller waiting on it gives up
+func (d *DB) List(ctx context.Context) ([]*db.User, error) {
+	d.mu.Lock()
+	if d.list != nil && time.Now().Before(d.list.expires) {
+		users := d.list.users
+		d.mu.Unlock()
+		requests.With(d.tenant, "hit").Inc()
+		return users, nil
+	}
+
+	c := d.call
+	if c != nil {
+		requests.With(d.tenant, "shared").Inc()
+	} else {
+		requests.With(d.tenant, "miss").Inc()
+
+		callCtx, cancel := context.WithCancel(context.Background())
+		c = &call{done: make(chan struct{}), cancel: cancel}
+		d.call = c
+		gen := d.gen
+
+		go func() {
+			defer cancel()
+			users, err := d.db.List(callCtx)
+
+			d.mu.Lock()
+			c.users, c.err = users, err
+			if d.call == c {
+				d.call = nil
+			}
+			if err == nil && users != nil && gen == d.gen {
+				d.list = &entry{users: users, expires: time.Now().Add(d.ttl)}
+			}
+			d.mu.Unlock()
+
+			close(c.done)
+		}()
+	}
+	c.waiters++
+	d.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.users, c.err
+	case <-ctx.Done():
+		d.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if d.call == c {
+				d.call = nil
+			}
+		}
+		d.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// ResetPassword sets a newly generated password for the user and returns it, or an error if one occurred.
+// The user's password in the cached list is updated, or the list is removed if the reset fails
+func (d *DB) ResetPassword(ctx context.Context, username string) (string, error) {
+	pass, err := d.db.ResetPassword(ctx, username)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.gen++
+	invalidations.With(d.tenant).Inc()
+
+	if d.list == nil {
+		return pass, err
+	}
+
+	if err != nil {
+		// the stored password may or may not have changed
+		d.list = nil
+		return pass, err
+	}
+
+	// copy on write, since callers may still be reading the old list
+	users := make([]*db.User, len(d.list.users))
+	copy(users, d.list.users)
+	for i, u := range users {
+		if strings.EqualFold(u.Username, username) {
+			updated := *u
+			updated.Password = pass
+			users[i] = &updated
+		}
+	}
+	d.list = &entry{users: users, expires: d.list.expires}
+
+	return pass, nil
+}
@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/db"
+)
+
+// testDB counts List queries, which block until a value is sent on unblock or their context is done
+type testDB struct {
+	mu       *sync.Mutex
+	users    []*db.User
+	lists    int
+	canceled int
+	unblock  chan struct{}
+	resetErr error
+}
+
+func newTestDB() *testDB {
+	return &testDB{
+		mu:    new(sync.Mutex),
+		users: []*db.User{{Username: "jdoe12", Password: "old"}, {Username: "asmith7", Password: "old"}},
+	}
+}
+
+func (d *testDB) Get(ctx context.Context, username string) (*db.User, error) {
+	return nil, nil
+}
+
+func (d *testDB) List(ctx context.Context) ([]*db.User, error) {
+	d.mu.Lock()
+	d.lists++
+	unblock := d.unblock
+	d.mu.Unlock()
+
+	if unblock != nil {
+		select {
+		case <-unblock:
+		case <-ctx.Done():
+			d.mu.Lock()
+			d.canceled++
+			d.mu.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	users := make([]*db.User, len(d.users))
+	for i, u := range d.users {
+		user := *u
+		users[i] = &user
+	}
+	return users, nil
+}
+
+func (d *testDB) ResetPassword(ctx context.Context, username string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.resetErr != nil {
+		return "", d.resetErr
+	}
+	for _, u := range d.users {
+		if strings.EqualFold(u.Username, username) {
+			u.Password = "new"
+		}
+	}
+	return "new", nil
+}
+
+func (d *testDB) counts() (lists, canceled int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.lists, d.canceled
+}
+
+// waitFor fails the test if cond doesn't become true
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", msg)
+		}
+	}
+}
+
+// waiters returns the number of callers waiting on the in-flight query
+func waiters(d *DB) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.call == nil {
+		return 0
+	}
+	return d.call.waiters
+}
+
+func password(users []*db.User, username string) string {
+	for _, u := range users {
+		if u.Username == username {
+			return u.Password
+		}
+	}
+	return ""
+}
+
+func TestListShared(t *testing.T) {
+	next := newTestDB()
+	next.unblock = make(chan struct{})
+	d := New(next, time.Hour, "test")
+
+	const n = 5
+	results := make(chan []*db.User, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			users, err := d.List(context.Background())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results <- users
+		}()
+	}
+	waitFor(t, "callers to share the query", func() bool { return waiters(d) == n })
+
+	close(next.unblock)
+	for i := 0; i < n; i++ {
+		if users := <-results; len(users) != 2 {
+			t.Errorf("unexpected users: %v", users)
+		}
+	}
+
+	// the result is cached
+	if _, err := d.List(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lists, _ := next.counts(); lists != 1 {
+		t.Errorf("expected 1 query, got %d", lists)
+	}
+}
+
+func TestListCanceled(t *testing.T) {
+	next := newTestDB()
+	next.unblock = make(chan struct{})
+	d := New(next, time.Hour, "test")
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		go func(ctx context.Context) {
+			_, err := d.List(ctx)
+			errs <- err
+		}(ctx)
+	}
+	waitFor(t, "callers to share the query", func() bool { return waiters(d) == 2 })
+
+	// the query continues while any caller is waiting
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, canceled := next.counts(); canceled != 0 {
+		t.Fatal("query canceled while a caller was waiting")
+	}
+
+	cancel2()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	waitFor(t, "the query to be canceled", func() bool {
+		_, canceled := next.counts()
+		return canceled == 1
+	})
+
+	// a canceled query isn't cached or shared with later callers
+	close(next.unblock)
+	if users, err := d.List(context.Background()); err != nil || len(users) != 2 {
+		t.Fatalf("unexpected result: %v, %v", users, err)
+	}
+	if lists, _ := next.counts(); lists != 2 {
+		t.Errorf("expected 2 queries, got %d", lists)
+	}
+}
+
+func TestListInvalidatedInFlight(t *testing.T) {
+	next := newTestDB()
+	next.unblock = make(chan struct{})
+	d := New(next, time.Hour, "test")
+
+	results := make(chan []*db.User)
+	go func() {
+		users, _ := d.List(context.Background())
+		results <- users
+	}()
+	waitFor(t, "the query to start", func() bool { return waiters(d) == 1 })
+
+	// a reset during the query makes its result stale, so it's returned but not cached
+	if _, err := d.ResetPassword(context.Background(), "jdoe12"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	next.unblock <- struct{}{}
+	<-results
+
+	close(next.unblock)
+	users, err := d.List(context.Background())
+	if err != nil || password(users, "jdoe12") != "new" {
+		t.Errorf("expected list with the new password, got %v, %v", users, err)
+	}
+	if lists, _ := next.counts(); lists != 2 {
+		t.Errorf("expected 2 queries, got %d", lists)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	next := newTestDB()
+	d := New(next, time.Hour, "test")
+
+	old, err := d.List(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the cached list is copied with the new password, without changing the list callers already have
+	if _, err = d.ResetPassword(context.Background(), "JDoe12"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	users, err := d.List(context.Background())
+	if err != nil || password(users, "jdoe12") != "new" || password(users, "asmith7") != "old" {
+		t.Errorf("unexpected cached list: %v, %v", users, err)
+	}
+	if password(old, "jdoe12") != "old" {
+		t.Error("reset changed a list already returned")
+	}
+	if old[1] != users[1] {
+		t.Error("expected unchanged users to be shared")
+	}
+	if lists, _ := next.counts(); lists != 1 {
+		t.Errorf("expected 1 query, got %d", lists)
+	}
+
+	// a failed reset removes the cached list
+	next.resetErr = errors.New("directory unavailable")
+	if _, err = d.ResetPassword(context.Background(), "asmith7"); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err = d.List(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if lists, _ := next.counts(); lists != 2 {
+		t.Errorf("expected 2 queries, got %d", lists)
+	}
+}
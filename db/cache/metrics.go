@@ -0,0 +1,11 @@
+package cache
+
+import "github.com/korylprince/userbrowser-server/v3/metrics"
+
+var (
+	requests = metrics.NewCounterVec("userbrowser_db_cache_requests_total",
+		"Number of student list reads by tenant and whether they hit the cache, shared an in-flight query, or missed.",
+		"tenant", "result")
+	invalidations = metrics.NewCounterVec("userbrowser_db_cache_invalidations_total",
+		"Number of times the cached student list was invalidated by a password reset, by tenant.", "tenant")
+)
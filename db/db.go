@@ -1,5 +1,7 @@
 package db
 
+import "context"
+
 // User represents a student user
 type User struct {
 	FirstName string `json:"first_name"`
@@ -7,16 +9,18 @@ type User struct {
 	Username  string `json:"username"`
 	Password  string `json:"password"`
 	Grade     int    `json:"grade"`
+	// Groups are the names of groups the user is a direct member of. Only set by Get
+	Groups []string `json:"-"`
 }
 
-// DB represents a user database
+// DB represents a user database. Operations are aborted when ctx is done
 type DB interface {
 	// Get returns the user with the given username, nil if the user doesn't exist, or an error if one occurred
-	Get(username string) (*User, error)
+	Get(ctx context.Context, username string) (*User, error)
 
 	// List returns a list of all Users from the database or an error if one occurred
-	List() ([]*User, error)
+	List(ctx context.Context) ([]*User, error)
 
 	// ResetPassword sets a newly generated password for the user and returns it, or an error if one occurred
-	ResetPassword(username string) (string, error)
+	ResetPassword(ctx context.Context, username string) (string, error)
 }
@@ -0,0 +1,65 @@
+package db
+
+import "fmt"
+
+// NotFoundError is returned when a user doesn't exist
+type NotFoundError struct {
+	Username string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("User %s not found", e.Username)
+}
+
+// UnknownGradeError is returned when a user's grade can't be determined from its location in the directory
+type UnknownGradeError struct {
+	DN string
+}
+
+func (e *UnknownGradeError) Error() string {
+	return fmt.Sprintf("Unknown grade for dn: %s", e.DN)
+}
+
+// PasswordPolicyError is returned when the directory rejects a new password, e.g. for being too short
+type PasswordPolicyError struct {
+	Username string
+	Err      error
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("Password for user %s violates password policy: %v", e.Username, e.Err)
+}
+
+func (e *PasswordPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// UnavailableError is returned when the directory can't be reached or doesn't respond in time
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("Directory unavailable: %v", e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// BindError is returned when the directory rejects the credentials used to connect to it
+type BindError struct {
+	User string
+	Err  error
+}
+
+func (e *BindError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("Invalid bind credentials for user %s", e.User)
+	}
+	return fmt.Sprintf("Unable to bind as user %s: %v", e.User, e.Err)
+}
+
+func (e *BindError) Unwrap() error {
+	return e.Err
+}
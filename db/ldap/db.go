@@ -1,22 +1,32 @@
 package ldap
 
 import (
+	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"regexp"
 	"sort"
+	"sync"
+	"time"
+	"unicode/utf16"
 
 	"github.com/go-ldap/ldap/v3"
 	adauth "github.com/korylprince/go-ad-auth/v3"
 	"github.com/korylprince/securetoken"
 	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/dc"
 )
 
 var gradeRegexp = regexp.MustCompile("^CN=.*?,OU=(.*?)(?: Grade)?,.*$")
 
-var gradeMap = map[string]int{
+// studentFilter matches enabled student accounts
+const studentFilter = "(&(objectCategory=Person)(employeeID=s*)(!(UserAccountControl:1.2.840.113556.1.4.803:=2)))"
+
+// DefaultGrades maps the names of grade OUs to grades
+var DefaultGrades = map[string]int{
 	"Pre-K":        -1,
 	"Kindergarten": 0,
 	"1st":          1,
@@ -35,92 +45,236 @@ var gradeMap = map[string]int{
 
 // DB represents a connection to an Active Directory server
 type DB struct {
-	config   *adauth.Config
+	pool     *dc.Pool
 	bindUser string
 	bindPass string
 	key      []byte
+	grades   map[string]int
 	debug    bool
+	locks    *userLocks
+	// resetConn binds the connection used by a reset. Tests replace it to reset passwords in a fake directory
+	resetConn func(ctx context.Context) (conn ldap.Client, baseDN string, done func(), detach func() bool, err error)
 }
 
-// New returns a new *DB with the given parameters
-func New(config *adauth.Config, username, password, key string, debug bool) *DB {
-	return &DB{config: config, bindUser: username, bindPass: password, key: []byte(key), debug: debug}
+// New returns a new *DB with the given parameters. grades maps the names of grade OUs to grades;
+// DefaultGrades is used if it's nil
+func New(pool *dc.Pool, username, password, key string, grades map[string]int, debug bool) *DB {
+	if grades == nil {
+		grades = DefaultGrades
+	}
+	d := &DB{pool: pool, bindUser: username, bindPass: password, key: []byte(key), grades: grades, debug: debug, locks: newUserLocks()}
+	d.resetConn = d.bindReset
+	return d
 }
 
-// Bind returns a bound connection to an Active Directory server
-func (d *DB) Bind() (*adauth.Conn, error) {
-	conn, err := d.config.Connect()
+// Bind returns a bound connection to an Active Directory server and a function that closes it.
+// The connection is closed early if ctx is done
+func (d *DB) Bind(ctx context.Context) (conn *adauth.Conn, done func(), err error) {
+	defer func(start time.Time) { d.observe("bind", start, err) }(time.Now())
+
+	conn, done, err = d.pool.Connect(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, &db.UnavailableError{Err: err}
 	}
 
 	status, err := conn.Bind(d.bindUser, d.bindPass)
 	if err != nil {
-		return nil, err
+		done()
+		return nil, nil, directoryError(ctx, err)
 	}
 
 	if !status {
-		return nil, fmt.Errorf("Invalid bind credentials for user %s", d.bindUser)
+		done()
+		return nil, nil, &db.BindError{User: d.bindUser}
 	}
 
-	return conn, nil
+	return conn, done, nil
 }
 
-// Get returns the user with the given username, nil if the user doesn't exist, or an error if one occurred
-func (d *DB) Get(username string) (*db.User, error) {
-	conn, err := d.Bind()
+// bindDetachable is like Bind, but the connection is only closed early if ctx is done before detach is called.
+// detach returns false if ctx is already done. It's used to finish a change once it's started, so the directory isn't
+// left in an unknown state
+func (d *DB) bindDetachable(ctx context.Context) (conn *adauth.Conn, done func(), detach func() bool, err error) {
+	connCtx, cancel := context.WithCancel(context.Background())
+
+	var (
+		mu       sync.Mutex
+		detached bool
+		stop     = make(chan struct{})
+	)
+	go func() {
+		select {
+		case <-ctx.Done():
+			mu.Lock()
+			if !detached {
+				cancel()
+			}
+			mu.Unlock()
+		case <-stop:
+		}
+	}()
+
+	conn, closeConn, err := d.Bind(connCtx)
 	if err != nil {
-		return nil, fmt.Errorf("Error binding to server: %v", err)
+		close(stop)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, nil, nil, &db.UnavailableError{Err: ctx.Err()}
+		}
+		return nil, nil, nil, err
 	}
-	defer conn.Conn.Close()
 
-	entry, err := conn.GetAttributes("sAMAccountName", username, []string{"sn", "givenname", "sAMAccountName", "adminDescription"})
+	detach = func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if ctx.Err() != nil {
+			return false
+		}
+		detached = true
+		return true
+	}
+
+	done = func() {
+		close(stop)
+		closeConn()
+		cancel()
+	}
+
+	return conn, done, detach, nil
+}
+
+// bindReset returns a connection from bindDetachable for a reset, and the base DN to search under
+func (d *DB) bindReset(ctx context.Context) (ldap.Client, string, func(), func() bool, error) {
+	conn, done, detach, err := d.bindDetachable(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Error searching for user: %v", err)
+		return nil, "", nil, nil, err
 	}
+	return conn.Conn, conn.Config.BaseDN, done, detach, nil
+}
 
-	if entry == nil {
-		return nil, nil
+// directoryError translates err from a directory operation into a *db.UnavailableError if the directory couldn't be
+// reached or ctx is done, since operations aborted by closing the connection fail with a less helpful error
+func directoryError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return &db.UnavailableError{Err: ctx.Err()}
+	}
+	if dc.IsUnavailable(err) {
+		return &db.UnavailableError{Err: err}
 	}
+	return err
+}
 
-	var (
-		grade int
-		ok    bool
-	)
-	if match := gradeRegexp.FindStringSubmatch(entry.DN); len(match) == 2 {
-		if grade, ok = gradeMap[match[1]]; !ok {
-			return nil, fmt.Errorf("Unknown grade: %s", match[1])
-		}
-	} else {
-		return nil, fmt.Errorf("Unknown grade for dn: %s", entry.DN)
+// retry runs the idempotent op with the pool's retries and circuit breaker
+func (d *DB) retry(ctx context.Context, op func() error) error {
+	return breakerError(d.pool.Retry(ctx, op))
+}
+
+// do runs op with the pool's circuit breaker, without retrying
+func (d *DB) do(ctx context.Context, op func() error) error {
+	return breakerError(d.pool.Do(ctx, op))
+}
+
+// breakerError returns a *db.UnavailableError if err is from the circuit breaker being open
+func breakerError(err error) error {
+	if errors.As(err, new(*dc.CircuitOpenError)) {
+		return &db.UnavailableError{Err: err}
+	}
+	return err
+}
+
+// decrypt returns the password stored in the entry's adminDescription, or an empty string if it can't be decrypted
+func (d *DB) decrypt(entry *ldap.Entry) string {
+	token := entry.GetRawAttributeValue("adminDescription")
+	if len(token) == 0 {
+		return ""
 	}
 
-	pass, err := securetoken.DecryptToken(entry.GetRawAttributeValue("adminDescription"), d.key, 0)
+	pass, err := securetoken.DecryptToken(token, d.key, 0)
 	if err != nil {
-		pass = []byte("")
+		decryptFailures.With(d.pool.Tenant()).Inc()
 		if d.debug {
-			log.Printf("Unable to decrypt password for user %s: %v\n", username, err)
+			log.Printf("Unable to decrypt password for user %s: %v\n", entry.GetAttributeValue("sAMAccountName"), err)
+		}
+		return ""
+	}
+
+	return string(pass)
+}
+
+// groupNames returns the CN of each group DN, skipping DNs that can't be parsed
+func groupNames(dns []string) []string {
+	var names []string
+	for _, dn := range dns {
+		parsed, err := ldap.ParseDN(dn)
+		if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+			continue
 		}
+		names = append(names, parsed.RDNs[0].Attributes[0].Value)
+	}
+	return names
+}
+
+// Get returns the user with the given username, nil if the user doesn't exist, or an error if one occurred.
+// The search is retried if the directory is unavailable
+func (d *DB) Get(ctx context.Context, username string) (user *db.User, err error) {
+	err = d.retry(ctx, func() error {
+		user, err = d.get(ctx, username)
+		return err
+	})
+	return user, err
+}
+
+func (d *DB) get(ctx context.Context, username string) (*db.User, error) {
+	conn, done, err := d.Bind(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Error binding to server: %w", err)
+	}
+	defer done()
+
+	start := time.Now()
+	entry, err := conn.GetAttributes("sAMAccountName", username, []string{"sn", "givenname", "sAMAccountName", "adminDescription", "memberOf"})
+	d.observe("search", start, err)
+	if err != nil {
+		return nil, fmt.Errorf("Error searching for user: %w", directoryError(ctx, err))
+	}
+
+	if entry == nil {
+		return nil, nil
+	}
+
+	grade, ok := d.grade(entry.DN)
+	if !ok {
+		return nil, &db.UnknownGradeError{DN: entry.DN}
 	}
 
 	user := &db.User{
 		FirstName: entry.GetAttributeValue("givenName"),
 		LastName:  entry.GetAttributeValue("sn"),
 		Username:  entry.GetAttributeValue("sAMAccountName"),
-		Password:  string(pass),
+		Password:  d.decrypt(entry),
 		Grade:     grade,
+		Groups:    groupNames(entry.GetAttributeValues("memberOf")),
 	}
 
 	return user, nil
 }
 
-// List returns a list of all Users from the database or an error if one occurred
-func (d *DB) List() ([]*db.User, error) {
-	conn, err := d.Bind()
+// List returns a list of all Users from the database or an error if one occurred.
+// The search is retried if the directory is unavailable
+func (d *DB) List(ctx context.Context) (users []*db.User, err error) {
+	err = d.retry(ctx, func() error {
+		users, err = d.list(ctx)
+		return err
+	})
+	return users, err
+}
+
+func (d *DB) list(ctx context.Context) ([]*db.User, error) {
+	conn, done, err := d.Bind(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("Error binding to server: %v", err)
+		return nil, fmt.Errorf("Error binding to server: %w", err)
 	}
-	defer conn.Conn.Close()
+	defer done()
 
 	request := ldap.NewSearchRequest(
 		conn.Config.BaseDN,
@@ -129,51 +283,60 @@ func (d *DB) List() ([]*db.User, error) {
 		0,
 		0,
 		false,
-		"(&(objectCategory=Person)(employeeID=s*)(!(UserAccountControl:1.2.840.113556.1.4.803:=2)))",
+		studentFilter,
 		[]string{"sn", "givenname", "sAMAccountName", "adminDescription"},
 		nil,
 	)
 
+	start := time.Now()
 	result, err := conn.Conn.SearchWithPaging(request, 1000)
+	d.observe("search", start, err)
 	if err != nil {
-		return nil, fmt.Errorf("Error searching: %v", err)
+		return nil, fmt.Errorf("Error searching: %w", directoryError(ctx, err))
 	}
 
 	var users []*db.User
 
 	for _, entry := range result.Entries {
-		var (
-			grade int
-			ok    bool
-		)
-		if match := gradeRegexp.FindStringSubmatch(entry.DN); len(match) == 2 {
-			if grade, ok = gradeMap[match[1]]; !ok {
-				log.Println("WARNING: Unknown grade for user", entry.DN)
-				continue
-			}
-		} else {
-			log.Println("WARNING: Unknown grade for user", entry.DN)
-			continue
+		if user := d.listUser(entry); user != nil {
+			users = append(users, user)
 		}
+	}
 
-		pass, err := securetoken.DecryptToken(entry.GetRawAttributeValue("adminDescription"), d.key, 0)
-		if err != nil {
-			pass = []byte("")
-			if d.debug {
-				log.Printf("Unable to decrypt password for user %s: %v\n", entry.GetAttributeValue("sAMAccountName"), err)
-			}
-		}
+	sortUsers(users)
+
+	return users, nil
+}
+
+// grade returns the grade of the user with the given DN, or false if it's unknown
+func (d *DB) grade(dn string) (int, bool) {
+	match := gradeRegexp.FindStringSubmatch(dn)
+	if len(match) != 2 {
+		return 0, false
+	}
+	grade, ok := d.grades[match[1]]
+	return grade, ok
+}
 
-		users = append(users, &db.User{
-			FirstName: entry.GetAttributeValue("givenName"),
-			LastName:  entry.GetAttributeValue("sn"),
-			Username:  entry.GetAttributeValue("sAMAccountName"),
-			Password:  string(pass),
-			Grade:     grade,
-		})
+// listUser returns the user for an entry from a student search, or nil if its grade is unknown
+func (d *DB) listUser(entry *ldap.Entry) *db.User {
+	grade, ok := d.grade(entry.DN)
+	if !ok {
+		log.Println("WARNING: Unknown grade for user", entry.DN)
+		return nil
+	}
 
+	return &db.User{
+		FirstName: entry.GetAttributeValue("givenName"),
+		LastName:  entry.GetAttributeValue("sn"),
+		Username:  entry.GetAttributeValue("sAMAccountName"),
+		Password:  d.decrypt(entry),
+		Grade:     grade,
 	}
+}
 
+// sortUsers sorts users by grade, then last name, then first name
+func sortUsers(users []*db.User) {
 	sort.Slice(users, func(i, j int) bool {
 		if users[i].Grade == users[j].Grade {
 			if users[i].LastName == users[j].LastName {
@@ -183,23 +346,57 @@ func (d *DB) List() ([]*db.User, error) {
 		}
 		return users[i].Grade < users[j].Grade
 	})
+}
 
-	return users, nil
+// ResetPassword sets a newly generated password for the user and returns it, or an error if one occurred.
+// The new password is stored before it's set; if setting it fails, the previous stored password is restored.
+// Resets of the same user are serialized, and once the change has started it's finished even if ctx is done.
+// The reset isn't retried
+func (d *DB) ResetPassword(ctx context.Context, username string) (pass string, err error) {
+	unlock, err := d.locks.lock(ctx, username)
+	if err != nil {
+		return "", fmt.Errorf("Error waiting for another reset of user %s: %w", username, err)
+	}
+	defer unlock()
+
+	err = d.do(ctx, func() error {
+		pass, err = d.resetPassword(ctx, username)
+		return err
+	})
+	return pass, err
 }
 
-// ResetPassword sets a newly generated password for the user and returns it, or an error if one occurred
-func (d *DB) ResetPassword(username string) (string, error) {
-	conn, err := d.Bind()
+func (d *DB) resetPassword(ctx context.Context, username string) (string, error) {
+	conn, baseDN, done, detach, err := d.resetConn(ctx)
 	if err != nil {
-		return "", fmt.Errorf("Error binding to server: %v", err)
+		return "", fmt.Errorf("Error binding to server: %w", err)
 	}
-	defer conn.Conn.Close()
+	defer done()
+
+	request := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.DerefAlways,
+		1,
+		0,
+		false,
+		fmt.Sprintf("(sAMAccountName=%s)", ldap.EscapeFilter(username)),
+		[]string{"adminDescription"},
+		nil,
+	)
 
-	entry, err := conn.GetAttributes("sAMAccountName", username, nil)
+	start := time.Now()
+	result, err := conn.Search(request)
+	d.observe("search", start, err)
 	if err != nil {
-		return "", fmt.Errorf("Error searching username %s: %v", username, err)
+		return "", fmt.Errorf("Error searching username %s: %w", username, directoryError(ctx, err))
 	}
 
+	if len(result.Entries) == 0 {
+		return "", &db.NotFoundError{Username: username}
+	}
+	entry := result.Entries[0]
+
 	r, err := rand.Int(rand.Reader, big.NewInt(10000))
 	if err != nil {
 		return "", fmt.Errorf("Error getting random value: %v", err)
@@ -212,16 +409,217 @@ func (d *DB) ResetPassword(username string) (string, error) {
 		return "", fmt.Errorf("Error generating token: %v", err)
 	}
 
-	req := ldap.NewModifyRequest(entry.DN, nil)
-	req.Replace("adminDescription", []string{string(token)})
-	if err = conn.Conn.Modify(req); err != nil {
-		return "", fmt.Errorf("Error updating token: %v", err)
+	if !detach() {
+		return "", fmt.Errorf("Error resetting password for user %s: %w", username, &db.UnavailableError{Err: ctx.Err()})
+	}
+
+	if err = d.setToken(conn, entry.DN, token); err != nil {
+		return "", fmt.Errorf("Error updating token: %w", directoryError(ctx, err))
 	}
 
-	err = conn.ModifyDNPassword(entry.DN, pass)
+	start = time.Now()
+	err = setPassword(conn, entry.DN, pass)
+	d.observe("modify_password", start, err)
 	if err != nil {
-		return "", fmt.Errorf("Error modifying password: %v", err)
+		if isPolicyViolation(err) {
+			err = &db.PasswordPolicyError{Username: username, Err: err}
+		} else {
+			err = directoryError(ctx, err)
+		}
+		if rerr := d.setToken(conn, entry.DN, entry.GetRawAttributeValue("adminDescription")); rerr != nil {
+			log.Printf("Unable to restore token for user %s after failed reset: %v\n", username, rerr)
+			return "", fmt.Errorf("Error modifying password: %w; unable to restore previous token: %v", err, rerr)
+		}
+		return "", fmt.Errorf("Error modifying password: %w", err)
 	}
 
 	return pass, nil
 }
+
+// isPolicyViolation returns true if err is the directory rejecting a new password
+func isPolicyViolation(err error) bool {
+	var lerr *ldap.Error
+	return errors.As(err, &lerr) &&
+		(lerr.ResultCode == ldap.LDAPResultConstraintViolation || lerr.ResultCode == ldap.LDAPResultUnwillingToPerform)
+}
+
+// setPassword sets the password of the object with the given DN
+func setPassword(conn ldap.Client, dn, pass string) error {
+	// unicodePwd is the quoted password encoded as UTF-16LE
+	encoded := utf16.Encode([]rune(`"` + pass + `"`))
+	b := make([]byte, 0, len(encoded)*2)
+	for _, c := range encoded {
+		b = append(b, byte(c), byte(c>>8))
+	}
+
+	req := ldap.NewModifyRequest(dn, nil)
+	req.Replace("unicodePwd", []string{string(b)})
+	return conn.Modify(req)
+}
+
+// setToken replaces the adminDescription of the object with the given DN with token, or removes it if token is empty
+func (d *DB) setToken(conn ldap.Client, dn string, token []byte) error {
+	var values []string
+	if len(token) > 0 {
+		values = []string{string(token)}
+	}
+
+	req := ldap.NewModifyRequest(dn, nil)
+	req.Replace("adminDescription", values)
+	start := time.Now()
+	err := conn.Modify(req)
+	d.observe("modify", start, err)
+	return err
+}
+
+// DecryptToken returns the password stored in the user's adminDescription, or an error if it's missing or can't be decrypted
+func (d *DB) DecryptToken(ctx context.Context, username string) (pass string, err error) {
+	err = d.retry(ctx, func() error {
+		pass, err = d.decryptToken(ctx, username)
+		return err
+	})
+	return pass, err
+}
+
+func (d *DB) decryptToken(ctx context.Context, username string) (string, error) {
+	conn, done, err := d.Bind(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Error binding to server: %w", err)
+	}
+	defer done()
+
+	start := time.Now()
+	entry, err := conn.GetAttributes("sAMAccountName", username, []string{"adminDescription"})
+	d.observe("search", start, err)
+	if err != nil {
+		return "", fmt.Errorf("Error searching username %s: %w", username, directoryError(ctx, err))
+	}
+
+	if entry == nil {
+		return "", &db.NotFoundError{Username: username}
+	}
+
+	token := entry.GetRawAttributeValue("adminDescription")
+	if len(token) == 0 {
+		return "", fmt.Errorf("No token stored for user %s", username)
+	}
+
+	pass, err := securetoken.DecryptToken(token, d.key, 0)
+	if err != nil {
+		return "", fmt.Errorf("Error decrypting token: %v", err)
+	}
+
+	return string(pass), nil
+}
+
+// ReencryptToken decrypts the user's adminDescription with oldKey and stores it encrypted with the current key.
+// If oldKey is empty, the current key is used to decrypt the token
+func (d *DB) ReencryptToken(ctx context.Context, username, oldKey string) error {
+	unlock, err := d.locks.lock(ctx, username)
+	if err != nil {
+		return fmt.Errorf("Error waiting for reset of user %s: %w", username, err)
+	}
+	defer unlock()
+
+	return d.do(ctx, func() error {
+		return d.reencryptToken(ctx, username, oldKey)
+	})
+}
+
+func (d *DB) reencryptToken(ctx context.Context, username, oldKey string) error {
+	conn, done, err := d.Bind(ctx)
+	if err != nil {
+		return fmt.Errorf("Error binding to server: %w", err)
+	}
+	defer done()
+
+	start := time.Now()
+	entry, err := conn.GetAttributes("sAMAccountName", username, []string{"adminDescription"})
+	d.observe("search", start, err)
+	if err != nil {
+		return fmt.Errorf("Error searching username %s: %w", username, directoryError(ctx, err))
+	}
+
+	if entry == nil {
+		return &db.NotFoundError{Username: username}
+	}
+
+	token := entry.GetRawAttributeValue("adminDescription")
+	if len(token) == 0 {
+		return fmt.Errorf("No token stored for user %s", username)
+	}
+
+	key := d.key
+	if oldKey != "" {
+		key = []byte(oldKey)
+	}
+
+	pass, err := securetoken.DecryptToken(token, key, 0)
+	if err != nil {
+		return fmt.Errorf("Error decrypting token: %v", err)
+	}
+
+	if token, err = securetoken.NewToken(pass, d.key); err != nil {
+		return fmt.Errorf("Error generating token: %v", err)
+	}
+
+	if err = d.setToken(conn.Conn, entry.DN, token); err != nil {
+		return fmt.Errorf("Error updating token: %w", directoryError(ctx, err))
+	}
+
+	return nil
+}
+
+// Check binds to the server and searches for the base DN, returning an error if either fails
+func (d *DB) Check(ctx context.Context) error {
+	return d.retry(ctx, func() error {
+		return d.check(ctx)
+	})
+}
+
+func (d *DB) check(ctx context.Context) error {
+	conn, done, err := d.Bind(ctx)
+	if err != nil {
+		return fmt.Errorf("Error binding to server: %w", err)
+	}
+	defer done()
+
+	request := ldap.NewSearchRequest(
+		conn.Config.BaseDN,
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		1,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"dn"},
+		nil,
+	)
+
+	start := time.Now()
+	_, err = conn.Conn.Search(request)
+	d.observe("search", start, err)
+	if err != nil {
+		return fmt.Errorf("Error searching base DN: %w", directoryError(ctx, err))
+	}
+
+	return nil
+}
+
+// CheckKey verifies the SecureToken key can decrypt sample, a token previously created with the key.
+// If sample is empty, a new token is created and decrypted instead
+func (d *DB) CheckKey(sample string) error {
+	token := []byte(sample)
+	if sample == "" {
+		var err error
+		if token, err = securetoken.NewToken([]byte("sample"), d.key); err != nil {
+			return fmt.Errorf("Error generating token: %v", err)
+		}
+	}
+
+	if _, err := securetoken.DecryptToken(token, d.key, 0); err != nil {
+		return fmt.Errorf("Error decrypting sample token: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package ldap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/korylprince/securetoken"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/dc"
+)
+
+const testKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+var usernameFilterRegexp = regexp.MustCompile(`^\(sAMAccountName=(.*)\)$`)
+
+// testResetDirectory is a directory of users by lowercased username, recording their passwords and adminDescriptions
+type testResetDirectory struct {
+	ldap.Client
+	mu        *sync.Mutex
+	tokens    map[string]string
+	passwords map[string]string
+	// passwordErr is returned when setting a password
+	passwordErr error
+	// started receives the username of each reset when it searches for the user, and setting passwords
+	// waits for unblock to be closed
+	started chan string
+	unblock chan struct{}
+}
+
+func newTestResetDirectory(usernames ...string) *testResetDirectory {
+	d := &testResetDirectory{mu: new(sync.Mutex), tokens: make(map[string]string), passwords: make(map[string]string)}
+	for _, u := range usernames {
+		d.tokens[strings.ToLower(u)] = ""
+	}
+	return d
+}
+
+func dn(username string) string {
+	return fmt.Sprintf("CN=%s,OU=5th Grade,OU=Students,DC=example,DC=com", strings.ToLower(username))
+}
+
+func (d *testResetDirectory) Search(r *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	m := usernameFilterRegexp.FindStringSubmatch(r.Filter)
+	if m == nil {
+		return nil, fmt.Errorf("unexpected filter: %s", r.Filter)
+	}
+	username := strings.ToLower(m[1])
+
+	if d.started != nil {
+		d.started <- username
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	token, ok := d.tokens[username]
+	if !ok {
+		return new(ldap.SearchResult), nil
+	}
+	attrs := map[string][]string{}
+	if token != "" {
+		attrs["adminDescription"] = []string{token}
+	}
+	return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(dn(username), attrs)}}, nil
+}
+
+func (d *testResetDirectory) Modify(r *ldap.ModifyRequest) error {
+	username := strings.ToLower(strings.TrimPrefix(strings.SplitN(r.DN, ",", 2)[0], "CN="))
+	for _, c := range r.Changes {
+		switch c.Modification.Type {
+		case "unicodePwd":
+			if d.unblock != nil {
+				<-d.unblock
+			}
+			if d.passwordErr != nil {
+				return d.passwordErr
+			}
+			d.mu.Lock()
+			d.passwords[username] = c.Modification.Vals[0]
+			d.mu.Unlock()
+		case "adminDescription":
+			d.mu.Lock()
+			d.tokens[username] = strings.Join(c.Modification.Vals, "")
+			d.mu.Unlock()
+		default:
+			return fmt.Errorf("unexpected modification: %s", c.Modification.Type)
+		}
+	}
+	return nil
+}
+
+// token returns the stored adminDescription of username
+func (d *testResetDirectory) token(username string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tokens[strings.ToLower(username)]
+}
+
+// newTestResetDB returns a *DB that resets passwords in dir
+func newTestResetDB(dir *testResetDirectory) *DB {
+	d := New(dc.New(new(dc.Config)), "", "", testKey, nil, false)
+	d.resetConn = func(ctx context.Context) (ldap.Client, string, func(), func() bool, error) {
+		return dir, "DC=example,DC=com", func() {}, func() bool { return ctx.Err() == nil }, nil
+	}
+	return d
+}
+
+func decryptToken(t *testing.T, token string) string {
+	t.Helper()
+	pass, err := securetoken.DecryptToken([]byte(token), []byte(testKey), 0)
+	if err != nil {
+		t.Fatalf("unable to decrypt token: %v", err)
+	}
+	return string(pass)
+}
+
+func TestResetPassword(t *testing.T) {
+	dir := newTestResetDirectory("jdoe12")
+	d := newTestResetDB(dir)
+
+	pass, err := d.ResetPassword(context.Background(), "jdoe12")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the password is stored, and set as a quoted UTF-16LE string
+	if stored := decryptToken(t, dir.token("jdoe12")); stored != pass {
+		t.Errorf("expected stored password %q, got %q", pass, stored)
+	}
+	var expected string
+	for _, c := range `"` + pass + `"` {
+		expected += string([]byte{byte(c), 0})
+	}
+	if dir.passwords["jdoe12"] != expected {
+		t.Errorf("unexpected unicodePwd: %q", dir.passwords["jdoe12"])
+	}
+
+	if _, err = d.ResetPassword(context.Background(), "asmith7"); !errors.As(err, new(*db.NotFoundError)) {
+		t.Errorf("expected *db.NotFoundError, got %v", err)
+	}
+}
+
+func TestResetPasswordFailed(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		err    error
+		policy bool
+	}{
+		{"constraint violation", ldap.NewError(ldap.LDAPResultConstraintViolation, errors.New("password too short")), true},
+		{"unwilling to perform", ldap.NewError(ldap.LDAPResultUnwillingToPerform, errors.New("password in history")), true},
+		{"insufficient access", ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("access denied")), false},
+		{"other", errors.New("connection reset"), false},
+	} {
+		for _, previous := range []string{"", "previous-token"} {
+			dir := newTestResetDirectory("jdoe12")
+			dir.tokens["jdoe12"] = previous
+			dir.passwordErr = test.err
+			d := newTestResetDB(dir)
+
+			_, err := d.ResetPassword(context.Background(), "JDoe12")
+			if err == nil {
+				t.Fatalf("%s: expected error", test.name)
+			}
+			if policy := errors.As(err, new(*db.PasswordPolicyError)); policy != test.policy {
+				t.Errorf("%s: expected *db.PasswordPolicyError %v, got %v", test.name, test.policy, err)
+			}
+
+			// the previous stored password is restored, or removed if there wasn't one
+			if token := dir.token("jdoe12"); token != previous {
+				t.Errorf("%s: expected token %q restored, got %q", test.name, previous, token)
+			}
+		}
+	}
+}
+
+func TestResetPasswordSerialized(t *testing.T) {
+	dir := newTestResetDirectory("jdoe12", "asmith7")
+	dir.started = make(chan string, 3)
+	dir.unblock = make(chan struct{})
+	d := newTestResetDB(dir)
+
+	reset := func(username string, done chan<- error) {
+		_, err := d.ResetPassword(context.Background(), username)
+		done <- err
+	}
+	expectStarted := func(username string) {
+		t.Helper()
+		select {
+		case u := <-dir.started:
+			if u != username {
+				t.Fatalf("expected reset of %s to start, got %s", username, u)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected reset of %s to start", username)
+		}
+	}
+
+	done := make(chan error, 3)
+	go reset("jdoe12", done)
+	expectStarted("jdoe12")
+
+	// another reset of the same user waits, but other users aren't blocked
+	go reset("JDOE12", done)
+	go reset("asmith7", done)
+	expectStarted("asmith7")
+	select {
+	case u := <-dir.started:
+		t.Fatalf("reset of %s started while another was in progress", u)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// a reset waiting for the lock gives up when ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := d.ResetPassword(ctx, "jdoe12"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	close(dir.unblock)
+	expectStarted("jdoe12")
+	for i := 0; i < 3; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	if len(d.locks.locks) != 0 {
+		t.Errorf("expected locks to be removed, got %d", len(d.locks.locks))
+	}
+}
@@ -0,0 +1,261 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/korylprince/userbrowser-server/v3/db"
+)
+
+// indexSyncTimeout is how long a single index sync can run
+const indexSyncTimeout = 5 * time.Minute
+
+// accountDisabled is the userAccountControl flag for disabled accounts
+const accountDisabled = 2
+
+// Index is a db.DB that serves List from an in-memory index of students. The index is kept current by polling the
+// directory for entries whose uSNChanged is above the last seen value, and periodically replaced by a full search to
+// catch deletions. Get and ResetPassword are passed through to the DB. Users returned from List are shared between
+// callers and must not be modified
+type Index struct {
+	db           *DB
+	interval     time.Duration
+	fullInterval time.Duration
+
+	mu *sync.RWMutex
+	// entries are keyed by objectGUID, so moved users replace their old entry
+	entries map[string]*db.User
+	// guids maps lowercase usernames to objectGUIDs
+	guids map[string]string
+	users []*db.User
+	// marks are the highest USN seen from each domain controller, keyed by dsServiceName.
+	// USNs are local to each domain controller, so a mark is only used with the one it came from
+	marks    map[string]int64
+	synced   time.Time
+	fullSync time.Time
+}
+
+// NewIndex returns a new *Index for d. The index is updated every interval and replaced every fullInterval.
+// List is passed through to d until the first sync completes, or if syncs fail for fullInterval
+func NewIndex(d *DB, interval, fullInterval time.Duration) *Index {
+	return &Index{
+		db:           d,
+		interval:     interval,
+		fullInterval: fullInterval,
+		mu:           new(sync.RWMutex),
+		entries:      make(map[string]*db.User),
+		guids:        make(map[string]string),
+		marks:        make(map[string]int64),
+	}
+}
+
+// Run syncs the index every interval. Run never returns
+func (i *Index) Run() {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), indexSyncTimeout)
+		if err := i.db.do(ctx, func() error { return i.sync(ctx) }); err != nil {
+			log.Println("Unable to sync directory index:", err)
+		}
+		cancel()
+
+		time.Sleep(i.interval)
+	}
+}
+
+// rootDSE returns the DN of the domain controller conn is connected to and its highest committed USN
+func rootDSE(conn ldap.Client) (dsa string, usn int64, err error) {
+	request := ldap.NewSearchRequest(
+		"",
+		ldap.ScopeBaseObject,
+		ldap.NeverDerefAliases,
+		1,
+		0,
+		false,
+		"(objectClass=*)",
+		[]string{"dsServiceName", "highestCommittedUSN"},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(result.Entries) != 1 {
+		return "", 0, fmt.Errorf("Unexpected number of root DSE entries: %d", len(result.Entries))
+	}
+
+	entry := result.Entries[0]
+	if usn, err = strconv.ParseInt(entry.GetAttributeValue("highestCommittedUSN"), 10, 64); err != nil {
+		return "", 0, fmt.Errorf("Unable to parse highestCommittedUSN: %v", err)
+	}
+
+	return entry.GetAttributeValue("dsServiceName"), usn, nil
+}
+
+// isStudent returns true if the entry from an incremental search matches studentFilter
+func isStudent(entry *ldap.Entry) bool {
+	uac, err := strconv.Atoi(entry.GetAttributeValue("userAccountControl"))
+	if err != nil || uac&accountDisabled != 0 {
+		return false
+	}
+	return strings.HasPrefix(strings.ToLower(entry.GetAttributeValue("employeeID")), "s")
+}
+
+func (i *Index) sync(ctx context.Context) error {
+	conn, done, err := i.db.Bind(ctx)
+	if err != nil {
+		return fmt.Errorf("Error binding to server: %w", err)
+	}
+	defer done()
+
+	return i.update(ctx, conn.Conn, conn.Config.BaseDN)
+}
+
+// update syncs the index from the directory conn is connected to, searching under baseDN
+func (i *Index) update(ctx context.Context, conn ldap.Client, baseDN string) error {
+	start := time.Now()
+	// the USN must be read before searching, so changes made during the search are seen by the next sync
+	dsa, usn, err := rootDSE(conn)
+	i.db.observe("search", start, err)
+	if err != nil {
+		return fmt.Errorf("Error reading root DSE: %w", directoryError(ctx, err))
+	}
+
+	i.mu.RLock()
+	mark, ok := i.marks[dsa]
+	full := !ok || time.Since(i.fullSync) >= i.fullInterval
+	i.mu.RUnlock()
+
+	// changes to entries that stop matching studentFilter must be seen to remove them, so incremental syncs search
+	// every person and filter locally
+	filter := studentFilter
+	if !full {
+		filter = fmt.Sprintf("(&(objectCategory=Person)(uSNChanged>=%d))", mark+1)
+	}
+
+	request := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.DerefAlways,
+		0,
+		0,
+		false,
+		filter,
+		[]string{"objectGUID", "sn", "givenname", "sAMAccountName", "adminDescription", "employeeID", "userAccountControl"},
+		nil,
+	)
+
+	start = time.Now()
+	result, err := conn.SearchWithPaging(request, 1000)
+	i.db.observe("search", start, err)
+	if err != nil {
+		return fmt.Errorf("Error searching: %w", directoryError(ctx, err))
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entries := i.entries
+	if full {
+		entries = make(map[string]*db.User, len(result.Entries))
+	}
+
+	for _, entry := range result.Entries {
+		guid := string(entry.GetRawAttributeValue("objectGUID"))
+		var user *db.User
+		if full || isStudent(entry) {
+			user = i.db.listUser(entry)
+		}
+		if user == nil {
+			delete(entries, guid)
+			continue
+		}
+		entries[guid] = user
+	}
+
+	if full || len(result.Entries) > 0 {
+		users := make([]*db.User, 0, len(entries))
+		guids := make(map[string]string, len(entries))
+		for guid, u := range entries {
+			users = append(users, u)
+			guids[strings.ToLower(u.Username)] = guid
+		}
+		sortUsers(users)
+		i.entries, i.guids, i.users = entries, guids, users
+	}
+
+	i.marks[dsa] = usn
+	i.synced = time.Now()
+	if full {
+		i.fullSync = i.synced
+		indexSyncs.With(i.db.pool.Tenant(), "full").Inc()
+	} else {
+		indexSyncs.With(i.db.pool.Tenant(), "incremental").Inc()
+	}
+
+	return nil
+}
+
+// Get returns the user with the given username, nil if the user doesn't exist, or an error if one occurred.
+// Get always searches the directory, since group memberships aren't indexed
+func (i *Index) Get(ctx context.Context, username string) (*db.User, error) {
+	return i.db.Get(ctx, username)
+}
+
+// List returns a list of all Users from the index, or from the directory if the index isn't current
+func (i *Index) List(ctx context.Context) ([]*db.User, error) {
+	i.mu.RLock()
+	users, synced := i.users, i.synced
+	i.mu.RUnlock()
+
+	if synced.IsZero() || time.Since(synced) >= i.fullInterval {
+		return i.db.List(ctx)
+	}
+
+	return users, nil
+}
+
+// ResetPassword sets a newly generated password for the user and returns it, or an error if one occurred.
+// The user's indexed password is updated immediately
+func (i *Index) ResetPassword(ctx context.Context, username string) (string, error) {
+	pass, err := i.db.ResetPassword(ctx, username)
+	if err != nil {
+		return pass, err
+	}
+
+	i.setPassword(username, pass)
+
+	return pass, nil
+}
+
+// setPassword sets the indexed password of the user with the given username, if it's indexed
+func (i *Index) setPassword(username, pass string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	guid, ok := i.guids[strings.ToLower(username)]
+	if !ok {
+		return
+	}
+	old := i.entries[guid]
+	updated := *old
+	updated.Password = pass
+	i.entries[guid] = &updated
+
+	// copy on write, since callers may still be reading the old list
+	users := make([]*db.User, len(i.users))
+	copy(users, i.users)
+	for idx, u := range users {
+		if u == old {
+			users[idx] = &updated
+			break
+		}
+	}
+	i.users = users
+}
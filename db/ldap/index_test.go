@@ -0,0 +1,293 @@
+package ldap
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/korylprince/userbrowser-server/v3/dc"
+)
+
+var usnFilterRegexp = regexp.MustCompile(`^\(&\(objectCategory=Person\)\(uSNChanged>=(\d+)\)\)$`)
+
+type testObject struct {
+	guid     string
+	dn       string
+	username string
+	employee string
+	disabled bool
+	usn      int64
+}
+
+// testDirectory is a directory with a domain controller per DSA name, which share objects but have their own USNs
+type testDirectory struct {
+	ldap.Client
+	dsa     string
+	usns    map[string]int64
+	objects map[string]*testObject
+	filters []string
+}
+
+func newTestDirectory() *testDirectory {
+	return &testDirectory{dsa: "dc1", usns: map[string]int64{"dc1": 100, "dc2": 5000}, objects: make(map[string]*testObject)}
+}
+
+// put adds or changes an object, giving it the next USN of the current domain controller
+func (d *testDirectory) put(o *testObject) {
+	d.usns[d.dsa]++
+	o.usn = d.usns[d.dsa]
+	d.objects[o.guid] = o
+}
+
+func (d *testDirectory) Search(r *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if r.BaseDN != "" || r.Scope != ldap.ScopeBaseObject {
+		return nil, fmt.Errorf("unexpected search: %s", r.BaseDN)
+	}
+	return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("", map[string][]string{
+		"dsServiceName":       {"CN=NTDS Settings,CN=" + d.dsa},
+		"highestCommittedUSN": {strconv.FormatInt(d.usns[d.dsa], 10)},
+	})}}, nil
+}
+
+func (d *testDirectory) SearchWithPaging(r *ldap.SearchRequest, size uint32) (*ldap.SearchResult, error) {
+	d.filters = append(d.filters, r.Filter)
+
+	var min int64 = -1
+	if m := usnFilterRegexp.FindStringSubmatch(r.Filter); m != nil {
+		min, _ = strconv.ParseInt(m[1], 10, 64)
+	} else if r.Filter != studentFilter {
+		return nil, fmt.Errorf("unexpected filter: %s", r.Filter)
+	}
+
+	result := new(ldap.SearchResult)
+	for _, o := range d.objects {
+		if min < 0 && (o.disabled || !strings.HasPrefix(o.employee, "s")) {
+			continue
+		}
+		// objects changed on another domain controller replicate with a new local USN, so every object is seen
+		// by the first search of a domain controller
+		if min >= 0 && o.usn < min {
+			continue
+		}
+		uac := "512"
+		if o.disabled {
+			uac = "514"
+		}
+		result.Entries = append(result.Entries, ldap.NewEntry(o.dn, map[string][]string{
+			"objectGUID":         {o.guid},
+			"sAMAccountName":     {o.username},
+			"givenName":          {o.username},
+			"sn":                 {"Student"},
+			"employeeID":         {o.employee},
+			"userAccountControl": {uac},
+		}))
+	}
+
+	return result, nil
+}
+
+func (d *testDirectory) lastFull() bool {
+	return d.filters[len(d.filters)-1] == studentFilter
+}
+
+func student(guid, username, grade string) *testObject {
+	return &testObject{
+		guid:     guid,
+		dn:       fmt.Sprintf("CN=%s,OU=%s Grade,OU=Students,DC=example,DC=com", username, grade),
+		username: username,
+		employee: "s" + guid,
+	}
+}
+
+func newTestIndex(fullInterval time.Duration) *Index {
+	return NewIndex(New(dc.New(new(dc.Config)), "", "", "", nil, false), time.Second, fullInterval)
+}
+
+func usernames(t *testing.T, i *Index) []string {
+	t.Helper()
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var names []string
+	for _, u := range i.users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+func expectUsers(t *testing.T, i *Index, want ...string) {
+	t.Helper()
+	if names := usernames(t, i); strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("expected users %v, got %v", want, names)
+	}
+}
+
+func TestIndexSync(t *testing.T) {
+	d := newTestDirectory()
+	d.put(student("1", "alice", "5th"))
+	d.put(student("2", "bob", "3rd"))
+	d.put(&testObject{guid: "3", dn: "CN=teacher,OU=Staff,DC=example,DC=com", username: "teacher", employee: "t3"})
+
+	i := newTestIndex(time.Hour)
+	ctx := context.Background()
+
+	if err := i.update(ctx, d, "DC=example,DC=com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.lastFull() {
+		t.Error("expected full sync first")
+	}
+	expectUsers(t, i, "bob", "alice")
+
+	// changes since the last sync are applied incrementally
+	d.put(student("4", "carol", "1st"))
+	moved := student("1", "alice", "6th")
+	d.put(moved)
+	disabled := student("2", "bob", "3rd")
+	disabled.disabled = true
+	d.put(disabled)
+
+	if err := i.update(ctx, d, "DC=example,DC=com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.lastFull() || d.filters[len(d.filters)-1] != "(&(objectCategory=Person)(uSNChanged>=104))" {
+		t.Errorf("unexpected incremental filter: %s", d.filters[len(d.filters)-1])
+	}
+	expectUsers(t, i, "carol", "alice")
+	if u := i.users[1]; u.Grade != 6 {
+		t.Errorf("moved user has grade %d", u.Grade)
+	}
+
+	// entries that stop being students are removed
+	notStudent := student("4", "carol", "1st")
+	notStudent.employee = "t4"
+	d.put(notStudent)
+	if err := i.update(ctx, d, "DC=example,DC=com"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectUsers(t, i, "alice")
+}
+
+func TestIndexDeletion(t *testing.T) {
+	d := newTestDirectory()
+	d.put(student("1", "alice", "5th"))
+	d.put(student("2", "bob", "3rd"))
+
+	i := newTestIndex(time.Hour)
+	ctx := context.Background()
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// deleted objects aren't returned by incremental searches, so they stay until the next full sync
+	delete(d.objects, "2")
+	d.usns[d.dsa]++
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectUsers(t, i, "bob", "alice")
+
+	i.fullSync = time.Now().Add(-time.Hour)
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.lastFull() {
+		t.Error("expected full sync after full interval")
+	}
+	expectUsers(t, i, "alice")
+}
+
+func TestIndexMarks(t *testing.T) {
+	d := newTestDirectory()
+	d.put(student("1", "alice", "5th"))
+
+	i := newTestIndex(time.Hour)
+	ctx := context.Background()
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// another domain controller's USNs aren't comparable, so the first sync from it is full
+	d.dsa = "dc2"
+	d.put(student("2", "bob", "3rd"))
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !d.lastFull() {
+		t.Error("expected full sync from new domain controller")
+	}
+	expectUsers(t, i, "bob", "alice")
+
+	d.put(student("3", "carol", "1st"))
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.filters[len(d.filters)-1] != "(&(objectCategory=Person)(uSNChanged>=5002))" {
+		t.Errorf("unexpected filter: %s", d.filters[len(d.filters)-1])
+	}
+
+	// each domain controller's mark is used with it
+	d.dsa = "dc1"
+	if err := i.update(ctx, d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.filters[len(d.filters)-1] != "(&(objectCategory=Person)(uSNChanged>=102))" {
+		t.Errorf("unexpected filter: %s", d.filters[len(d.filters)-1])
+	}
+	if i.marks["CN=NTDS Settings,CN=dc1"] != 101 || i.marks["CN=NTDS Settings,CN=dc2"] != 5002 {
+		t.Errorf("unexpected marks: %v", i.marks)
+	}
+}
+
+func TestIndexList(t *testing.T) {
+	d := newTestDirectory()
+	d.put(student("1", "alice", "5th"))
+
+	i := newTestIndex(time.Hour)
+	if err := i.update(context.Background(), d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	users, err := i.List(context.Background())
+	if err != nil || len(users) != 1 || users[0].Username != "alice" {
+		t.Errorf("unexpected users: %v, %v", users, err)
+	}
+}
+
+func TestIndexSetPassword(t *testing.T) {
+	d := newTestDirectory()
+	d.put(student("1", "alice", "5th"))
+	d.put(student("2", "bob", "3rd"))
+
+	i := newTestIndex(time.Hour)
+	if err := i.update(context.Background(), d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	before, _ := i.List(context.Background())
+	i.setPassword("ALICE", "new-password")
+	i.setPassword("missing", "password")
+	after, _ := i.List(context.Background())
+
+	if after[1].Username != "alice" || after[1].Password != "new-password" || i.entries["1"].Password != "new-password" {
+		t.Errorf("password not updated: %+v", after[1])
+	}
+	if before[1].Password != "" || after[0] != before[0] {
+		t.Error("previous list modified")
+	}
+
+	// the updated user is replaced by later syncs
+	moved := student("1", "alice", "6th")
+	d.put(moved)
+	if err := i.update(context.Background(), d, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	i.setPassword("alice", "newer-password")
+	if users, _ := i.List(context.Background()); users[1].Password != "newer-password" || users[1].Grade != 6 {
+		t.Errorf("unexpected user: %+v", users[1])
+	}
+}
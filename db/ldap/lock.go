@@ -0,0 +1,58 @@
+package ldap
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// userLock is held while a user's stored password is being changed
+type userLock struct {
+	ch      chan struct{}
+	waiters int
+}
+
+// userLocks serializes changes to the same user's stored password
+type userLocks struct {
+	mu    *sync.Mutex
+	locks map[string]*userLock
+}
+
+func newUserLocks() *userLocks {
+	return &userLocks{mu: new(sync.Mutex), locks: make(map[string]*userLock)}
+}
+
+// lock blocks until the lock for username is acquired and returns a function that releases it,
+// or returns ctx's error if ctx is done first. Usernames are case-insensitive
+func (l *userLocks) lock(ctx context.Context, username string) (unlock func(), err error) {
+	username = strings.ToLower(username)
+
+	l.mu.Lock()
+	ul, ok := l.locks[username]
+	if !ok {
+		ul = &userLock{ch: make(chan struct{}, 1)}
+		l.locks[username] = ul
+	}
+	ul.waiters++
+	l.mu.Unlock()
+
+	release := func() {
+		l.mu.Lock()
+		ul.waiters--
+		if ul.waiters == 0 {
+			delete(l.locks, username)
+		}
+		l.mu.Unlock()
+	}
+
+	select {
+	case ul.ch <- struct{}{}:
+		return func() {
+			<-ul.ch
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
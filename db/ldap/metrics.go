@@ -0,0 +1,26 @@
+package ldap
+
+import (
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/metrics"
+)
+
+var (
+	operationDuration = metrics.NewHistogramVec("userbrowser_ldap_operation_duration_seconds",
+		"Latency of LDAP operations by tenant.", nil, "tenant", "operation")
+	operationErrors = metrics.NewCounterVec("userbrowser_ldap_errors_total",
+		"Number of failed LDAP operations by tenant.", "tenant", "operation")
+	decryptFailures = metrics.NewCounterVec("userbrowser_password_decrypt_failures_total",
+		"Number of stored passwords that couldn't be decrypted, by tenant.", "tenant")
+	indexSyncs = metrics.NewCounterVec("userbrowser_ldap_index_syncs_total",
+		"Number of successful student index syncs by tenant.", "tenant", "type")
+)
+
+// observe records the duration and result of an LDAP operation started at start
+func (d *DB) observe(operation string, start time.Time, err error) {
+	operationDuration.With(d.pool.Tenant(), operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		operationErrors.With(d.pool.Tenant(), operation).Inc()
+	}
+}
@@ -0,0 +1,351 @@
+// Package dc selects Active Directory domain controllers with health-tracked failover
+package dc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	adauth "github.com/korylprince/go-ad-auth/v3"
+)
+
+// Server is the address of a domain controller
+type Server struct {
+	Host string
+	Port int
+}
+
+func (s Server) String() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+}
+
+// ParseServer parses the format "host[:port]", using defaultPort if the port is omitted
+func ParseServer(str string, defaultPort int) (Server, error) {
+	host, port, err := net.SplitHostPort(str)
+	if err != nil {
+		return Server{Host: str, Port: defaultPort}, nil
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return Server{}, fmt.Errorf("Invalid port: %s", str)
+	}
+
+	return Server{Host: host, Port: p}, nil
+}
+
+// ErrNoServers is returned by Connect if there are no configured or discovered servers
+var ErrNoServers = errors.New("No domain controllers available")
+
+// Resolver looks up DNS SRV records. *net.Resolver implements Resolver
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// Config configures a Pool
+type Config struct {
+	// Base is used to connect to every server. Its Server and Port are ignored
+	Base *adauth.Config
+	// Servers are tried in order after any discovered servers
+	Servers []Server
+	// Domain enables discovering servers from the _ldap._tcp.dc._msdcs.<Domain> SRV records
+	Domain string
+	// Port is used for discovered servers instead of the port in the SRV record if set, e.g. for LDAPS
+	Port int
+	// Resolver is used for discovery. Defaults to net.DefaultResolver
+	Resolver Resolver
+	// Refresh is how often discovered servers are looked up again. Defaults to 5 minutes
+	Refresh time.Duration
+	// RoundRobin spreads connections across available servers instead of preferring the first
+	RoundRobin bool
+	// Backoff is how long a server is skipped after connecting to it fails. Defaults to 30 seconds
+	Backoff time.Duration
+	// Retries is how many times an operation run with Retry is retried after the directory is unavailable.
+	// Zero disables retries
+	Retries int
+	// RetryDelay is the delay before the first retry, doubled for each retry after and jittered. Defaults to 100ms
+	RetryDelay time.Duration
+	// BreakerThreshold is how many operations in a row can fail because the directory is unavailable before
+	// operations fail immediately with a *CircuitOpenError. Zero disables the circuit breaker
+	BreakerThreshold int
+	// BreakerCooldown is how long operations fail immediately before one is allowed through to test the directory.
+	// Defaults to 30 seconds
+	BreakerCooldown time.Duration
+	// Tenant labels the metrics of the pool and the directories using it
+	Tenant string
+}
+
+// Pool represents a set of domain controllers
+type Pool struct {
+	config *Config
+
+	mu         *sync.Mutex
+	discovered []Server
+	lookedUp   time.Time
+	down       map[Server]time.Time
+	next       int
+
+	breaker *breaker
+}
+
+// New returns a new *Pool with the given configuration
+func New(config *Config) *Pool {
+	if config.Resolver == nil {
+		config.Resolver = net.DefaultResolver
+	}
+	if config.Refresh == 0 {
+		config.Refresh = 5 * time.Minute
+	}
+	if config.Backoff == 0 {
+		config.Backoff = 30 * time.Second
+	}
+	if config.RetryDelay == 0 {
+		config.RetryDelay = 100 * time.Millisecond
+	}
+	if config.BreakerCooldown == 0 {
+		config.BreakerCooldown = 30 * time.Second
+	}
+
+	return &Pool{
+		config:  config,
+		mu:      new(sync.Mutex),
+		down:    make(map[Server]time.Time),
+		breaker: &breaker{threshold: config.BreakerThreshold, cooldown: config.BreakerCooldown, tenant: config.Tenant, mu: new(sync.Mutex)},
+	}
+}
+
+// Tenant returns the tenant the pool belongs to, or an empty string if it isn't a tenant's
+func (p *Pool) Tenant() string {
+	return p.config.Tenant
+}
+
+// discover refreshes the discovered servers if they're stale. The caller must hold p.mu
+func (p *Pool) discover() {
+	if p.config.Domain == "" || time.Since(p.lookedUp) < p.config.Refresh {
+		return
+	}
+	p.lookedUp = time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, addrs, err := p.config.Resolver.LookupSRV(ctx, "ldap", "tcp", "dc._msdcs."+p.config.Domain)
+	if err != nil {
+		log.Printf("Unable to discover domain controllers for %s: %v\n", p.config.Domain, err)
+		return
+	}
+
+	servers := make([]Server, 0, len(addrs))
+	for _, a := range orderSRV(addrs) {
+		s := Server{Host: strings.TrimSuffix(a.Target, "."), Port: int(a.Port)}
+		if p.config.Port != 0 {
+			s.Port = p.config.Port
+		}
+		servers = append(servers, s)
+	}
+
+	p.discovered = servers
+}
+
+// orderSRV returns addrs ordered by priority, lowest first, with records of the same priority ordered by a weighted
+// random selection (RFC 2782). Resolvers other than *net.Resolver don't necessarily order records
+func orderSRV(addrs []*net.SRV) []*net.SRV {
+	sorted := make([]*net.SRV, len(addrs))
+	copy(sorted, addrs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	for i := 0; i < len(sorted); {
+		j := i
+		total := 0
+		for ; j < len(sorted) && sorted[j].Priority == sorted[i].Priority; j++ {
+			total += int(sorted[j].Weight)
+		}
+
+		// pick each position of the group in turn, weighted by the remaining records' weights.
+		// Records with weight 0 keep their order after every weighted record
+		for ; i < j && total > 0; i++ {
+			n := rand.Intn(total)
+			for k := i; k < j; k++ {
+				if n -= int(sorted[k].Weight); n < 0 {
+					r := sorted[k]
+					total -= int(r.Weight)
+					copy(sorted[i+1:k+1], sorted[i:k])
+					sorted[i] = r
+					break
+				}
+			}
+		}
+		i = j
+	}
+
+	return sorted
+}
+
+// Servers returns every known server in the order they'll be tried: available servers first, rotated if RoundRobin
+// is set, then unavailable servers, soonest to be retried first
+func (p *Pool) Servers() []Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.discover()
+
+	var (
+		all  []Server
+		seen = make(map[Server]bool)
+	)
+	for _, list := range [][]Server{p.discovered, p.config.Servers} {
+		for _, s := range list {
+			if !seen[s] {
+				seen[s] = true
+				all = append(all, s)
+			}
+		}
+	}
+
+	var up, down []Server
+	now := time.Now()
+	for _, s := range all {
+		if until, ok := p.down[s]; ok && now.Before(until) {
+			down = append(down, s)
+		} else {
+			up = append(up, s)
+		}
+	}
+
+	if p.config.RoundRobin && len(up) > 0 {
+		start := p.next % len(up)
+		p.next++
+		up = append(up[start:], up[:start]...)
+	}
+
+	sort.SliceStable(down, func(i, j int) bool {
+		return p.down[down[i]].Before(p.down[down[j]])
+	})
+
+	return append(up, down...)
+}
+
+// Available returns false if connecting to server failed within the last Backoff
+func (p *Pool) Available(server Server) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	until, ok := p.down[server]
+	return !ok || time.Now().After(until)
+}
+
+func (p *Pool) mark(server Server, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		delete(p.down, server)
+		return
+	}
+
+	if _, ok := p.down[server]; !ok {
+		log.Printf("Domain controller %s is unavailable: %v\n", server, err)
+	}
+	p.down[server] = time.Now().Add(p.config.Backoff)
+}
+
+// connect connects to the server in config, abandoning the attempt if ctx is done first
+func connect(ctx context.Context, config *adauth.Config) (*adauth.Conn, error) {
+	type result struct {
+		conn *adauth.Conn
+		err  error
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := config.Connect()
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Connect returns an open connection to the first reachable server or an error if none are reachable.
+// The connection is closed when ctx is done, aborting any in-flight operations, or when the returned function is called
+func (p *Pool) Connect(ctx context.Context) (*adauth.Conn, func(), error) {
+	servers := p.Servers()
+	if len(servers) == 0 {
+		return nil, nil, ErrNoServers
+	}
+
+	var err error
+	for _, s := range servers {
+		c := *p.config.Base
+		c.Server, c.Port = s.Host, s.Port
+
+		var conn *adauth.Conn
+		conn, err = connect(ctx, &c)
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		p.mark(s, err)
+		if err == nil {
+			ctx, cancel := context.WithCancel(ctx)
+			go func() {
+				<-ctx.Done()
+				conn.Conn.Close()
+			}()
+			return conn, cancel, nil
+		}
+	}
+
+	return nil, nil, err
+}
+
+// IsUnavailable returns true if err indicates no server is known or could be reached, a server didn't respond in time,
+// or is too busy to handle the operation
+func IsUnavailable(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+
+	if errors.As(err, new(*CircuitOpenError)) || errors.Is(err, ErrNoServers) {
+		return true
+	}
+
+	var lerr *ldap.Error
+	if errors.As(err, &lerr) {
+		return lerr.ResultCode == ldap.ErrorNetwork || lerr.ResultCode == ldap.LDAPResultBusy ||
+			lerr.ResultCode == ldap.LDAPResultUnavailable
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) {
+		return true
+	}
+
+	// go-ldap reports a connection dropped mid-operation with an untyped error
+	return err != nil && strings.Contains(err.Error(), "unable to read LDAP response packet")
+}
+
+// Base returns the configuration shared by every server
+func (p *Pool) Base() *adauth.Config {
+	return p.config.Base
+}
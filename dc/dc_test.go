@@ -0,0 +1,310 @@
+package dc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	adauth "github.com/korylprince/go-ad-auth/v3"
+)
+
+type testResolver struct {
+	mu      *sync.Mutex
+	addrs   []*net.SRV
+	err     error
+	lookups int
+}
+
+func (r *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	if name != "dc._msdcs.example.com" {
+		return "", nil, fmt.Errorf("unexpected name: %s", name)
+	}
+	return "", r.addrs, r.err
+}
+
+func (r *testResolver) set(addrs []*net.SRV, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addrs, r.err = addrs, err
+}
+
+func servers(hosts ...string) []Server {
+	var s []Server
+	for _, h := range hosts {
+		s = append(s, Server{Host: h, Port: 389})
+	}
+	return s
+}
+
+func TestParseServer(t *testing.T) {
+	cases := map[string]Server{
+		"dc1.example.com":      {Host: "dc1.example.com", Port: 636},
+		"dc1.example.com:3268": {Host: "dc1.example.com", Port: 3268},
+		"[::1]:389":            {Host: "::1", Port: 389},
+	}
+	for in, want := range cases {
+		if s, err := ParseServer(in, 636); err != nil || s != want {
+			t.Errorf("%s: expected %v, got %v, %v", in, want, s, err)
+		}
+	}
+
+	for _, in := range []string{"dc1.example.com:0", "dc1.example.com:65536", "dc1.example.com:ldap"} {
+		if _, err := ParseServer(in, 636); err == nil {
+			t.Errorf("%s: expected error", in)
+		}
+	}
+}
+
+func TestServersFailover(t *testing.T) {
+	p := New(&Config{Servers: servers("dc1", "dc2", "dc3")})
+
+	if s := p.Servers(); !reflect.DeepEqual(s, servers("dc1", "dc2", "dc3")) {
+		t.Fatalf("unexpected order: %v", s)
+	}
+
+	// unavailable servers are tried last, soonest to be retried first
+	p.mark(Server{Host: "dc1", Port: 389}, errors.New("down"))
+	p.mark(Server{Host: "dc2", Port: 389}, errors.New("down"))
+	p.down[Server{Host: "dc2", Port: 389}] = time.Now().Add(time.Second)
+	if s := p.Servers(); !reflect.DeepEqual(s, servers("dc3", "dc2", "dc1")) {
+		t.Errorf("unexpected order: %v", s)
+	}
+	if p.Available(Server{Host: "dc1", Port: 389}) || !p.Available(Server{Host: "dc3", Port: 389}) {
+		t.Error("unexpected availability")
+	}
+
+	// servers are available again after connecting succeeds or the backoff expires
+	p.mark(Server{Host: "dc1", Port: 389}, nil)
+	p.down[Server{Host: "dc2", Port: 389}] = time.Now().Add(-time.Second)
+	if s := p.Servers(); !reflect.DeepEqual(s, servers("dc1", "dc2", "dc3")) {
+		t.Errorf("unexpected order: %v", s)
+	}
+	if !p.Available(Server{Host: "dc1", Port: 389}) || !p.Available(Server{Host: "dc2", Port: 389}) {
+		t.Error("unexpected availability")
+	}
+}
+
+func TestServersRoundRobin(t *testing.T) {
+	p := New(&Config{Servers: servers("dc1", "dc2", "dc3"), RoundRobin: true})
+
+	for _, want := range [][]Server{
+		servers("dc1", "dc2", "dc3"),
+		servers("dc2", "dc3", "dc1"),
+		servers("dc3", "dc1", "dc2"),
+		servers("dc1", "dc2", "dc3"),
+	} {
+		if s := p.Servers(); !reflect.DeepEqual(s, want) {
+			t.Errorf("expected %v, got %v", want, s)
+		}
+	}
+
+	// unavailable servers are skipped in the rotation
+	p.mark(Server{Host: "dc2", Port: 389}, errors.New("down"))
+	for _, want := range [][]Server{
+		servers("dc1", "dc3", "dc2"),
+		servers("dc3", "dc1", "dc2"),
+	} {
+		if s := p.Servers(); !reflect.DeepEqual(s, want) {
+			t.Errorf("expected %v, got %v", want, s)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	r := &testResolver{mu: new(sync.Mutex), addrs: []*net.SRV{
+		{Target: "dc2.example.com.", Port: 389, Priority: 10},
+		{Target: "dc1.example.com.", Port: 389, Priority: 0},
+	}}
+	p := New(&Config{
+		Servers:  []Server{{Host: "dc1.example.com", Port: 389}, {Host: "backup", Port: 389}},
+		Domain:   "example.com",
+		Resolver: r,
+		Refresh:  time.Hour,
+	})
+
+	// discovered servers are tried first, and configured servers aren't repeated
+	want := []Server{{Host: "dc1.example.com", Port: 389}, {Host: "dc2.example.com", Port: 389}, {Host: "backup", Port: 389}}
+	if s := p.Servers(); !reflect.DeepEqual(s, want) {
+		t.Errorf("expected %v, got %v", want, s)
+	}
+
+	// records are cached until the refresh interval passes
+	r.set([]*net.SRV{{Target: "dc3.example.com.", Port: 389}}, nil)
+	p.Servers()
+	if r.lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", r.lookups)
+	}
+
+	p.lookedUp = time.Now().Add(-time.Hour)
+	want = []Server{{Host: "dc3.example.com", Port: 389}, {Host: "dc1.example.com", Port: 389}, {Host: "backup", Port: 389}}
+	if s := p.Servers(); !reflect.DeepEqual(s, want) {
+		t.Errorf("expected %v, got %v", want, s)
+	}
+
+	// failed lookups keep the last discovered servers
+	r.set(nil, errors.New("SERVFAIL"))
+	p.lookedUp = time.Now().Add(-time.Hour)
+	if s := p.Servers(); !reflect.DeepEqual(s, want) {
+		t.Errorf("expected %v, got %v", want, s)
+	}
+}
+
+func TestDiscoverPort(t *testing.T) {
+	r := &testResolver{mu: new(sync.Mutex), addrs: []*net.SRV{{Target: "dc1.example.com.", Port: 389}}}
+	p := New(&Config{Domain: "example.com", Resolver: r, Port: 636})
+
+	if s := p.Servers(); !reflect.DeepEqual(s, []Server{{Host: "dc1.example.com", Port: 636}}) {
+		t.Errorf("unexpected servers: %v", s)
+	}
+}
+
+func targets(addrs []*net.SRV) []string {
+	var t []string
+	for _, a := range addrs {
+		t = append(t, a.Target)
+	}
+	return t
+}
+
+func TestOrderSRV(t *testing.T) {
+	addrs := []*net.SRV{
+		{Target: "c", Priority: 20, Weight: 100},
+		{Target: "zero1", Priority: 10, Weight: 0},
+		{Target: "b", Priority: 10, Weight: 50},
+		{Target: "zero2", Priority: 10, Weight: 0},
+		{Target: "a", Priority: 0, Weight: 0},
+	}
+
+	// lower priorities come first, and records with weight 0 come after weighted records of the same priority
+	want := []string{"a", "b", "zero1", "zero2", "c"}
+	for i := 0; i < 20; i++ {
+		if o := targets(orderSRV(addrs)); !reflect.DeepEqual(o, want) {
+			t.Fatalf("expected %v, got %v", want, o)
+		}
+	}
+	if addrs[0].Target != "c" {
+		t.Error("records modified")
+	}
+
+	// records of the same priority are picked first in proportion to their weight
+	addrs = []*net.SRV{{Target: "light", Weight: 10}, {Target: "heavy", Weight: 30}}
+	heavy := 0
+	for i := 0; i < 4000; i++ {
+		o := orderSRV(addrs)
+		if len(o) != 2 {
+			t.Fatalf("unexpected order: %v", targets(o))
+		}
+		if o[0].Target == "heavy" {
+			heavy++
+		}
+	}
+	if heavy < 2800 || heavy > 3200 {
+		t.Errorf("expected heavy record first about 3000 times, got %d", heavy)
+	}
+}
+
+// listeners returns the address of a server that accepts connections, and of one that refuses them
+func listeners(t *testing.T) (up, down Server) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	addr := func(l net.Listener) Server {
+		a := l.Addr().(*net.TCPAddr)
+		return Server{Host: a.IP.String(), Port: a.Port}
+	}
+	return addr(l), addr(closed)
+}
+
+func TestConnect(t *testing.T) {
+	up, down := listeners(t)
+	p := New(&Config{Base: &adauth.Config{}, Servers: []Server{down, up}})
+
+	conn, done, err := p.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conn.Config.Server != up.Host || conn.Config.Port != up.Port {
+		t.Errorf("connected to %s:%d, expected %v", conn.Config.Server, conn.Config.Port, up)
+	}
+	done()
+
+	// the failed server is tried after the one that succeeded
+	if s := p.Servers(); !reflect.DeepEqual(s, []Server{up, down}) {
+		t.Errorf("unexpected order: %v", s)
+	}
+	if p.Available(down) {
+		t.Error("failed server available")
+	}
+}
+
+func TestConnectUnavailable(t *testing.T) {
+	_, down := listeners(t)
+
+	p := New(&Config{Base: &adauth.Config{}, Servers: []Server{down}})
+	if _, _, err := p.Connect(context.Background()); err == nil || !IsUnavailable(err) {
+		t.Errorf("expected unavailable error, got %v", err)
+	}
+
+	p = New(&Config{Base: &adauth.Config{}})
+	_, _, err := p.Connect(context.Background())
+	if !errors.Is(err, ErrNoServers) || !IsUnavailable(fmt.Errorf("Error connecting: %w", err)) {
+		t.Errorf("expected unavailable error, got %v", err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	p := New(&Config{Base: &adauth.Config{}, BreakerThreshold: 2, BreakerCooldown: time.Hour})
+	unavailable := func() error { return &net.OpError{Op: "dial", Err: errors.New("connection refused")} }
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	// failures after the caller gave up or ran out of time aren't counted, even if they look like connection errors
+	for i := 0; i < 5; i++ {
+		p.Do(canceled, func() error { return context.Canceled })
+		p.Do(expired, func() error { return context.DeadlineExceeded })
+		p.Do(expired, unavailable)
+		p.Retry(expired, unavailable)
+	}
+	if err := p.Do(context.Background(), func() error { return nil }); err != nil {
+		t.Fatalf("expected breaker to be closed, got %v", err)
+	}
+
+	// a deadline inside the operation, e.g. a slow server, is counted
+	p.Do(context.Background(), func() error { return fmt.Errorf("Error searching: %w", context.DeadlineExceeded) })
+	p.Do(context.Background(), unavailable)
+
+	var open *CircuitOpenError
+	if err := p.Do(context.Background(), func() error { return nil }); !errors.As(err, &open) {
+		t.Errorf("expected *CircuitOpenError, got %v", err)
+	}
+}
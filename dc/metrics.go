@@ -0,0 +1,10 @@
+package dc
+
+import "github.com/korylprince/userbrowser-server/v3/metrics"
+
+var (
+	retries = metrics.NewCounterVec("userbrowser_ldap_retries_total",
+		"Number of directory operations retried after the directory was unavailable, by tenant.", "tenant")
+	breakerTrips = metrics.NewCounterVec("userbrowser_ldap_circuit_breaker_trips_total",
+		"Number of times the directory circuit breaker opened, by tenant.", "tenant")
+)
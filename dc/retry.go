@@ -0,0 +1,129 @@
+package dc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// CircuitOpenError is returned instead of running an operation after the directory has been unavailable for
+// BreakerThreshold operations in a row
+type CircuitOpenError struct {
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("Directory has failed repeatedly; not retrying until %s", e.Until.Format(time.RFC3339))
+}
+
+// breaker is a circuit breaker that opens after threshold consecutive failures. After cooldown it lets a single
+// operation through, closing if it succeeds or reopening if it fails
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	tenant    string
+
+	mu       *sync.Mutex
+	failures int
+	until    time.Time
+	probing  bool
+}
+
+// allow returns a *CircuitOpenError if an operation shouldn't run
+func (b *breaker) allow() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+
+	if b.probing || time.Now().Before(b.until) {
+		return &CircuitOpenError{Until: b.until}
+	}
+
+	b.probing = true
+	return nil
+}
+
+// record records the result of an operation that allow let run. If ctx is done the result isn't counted, since the
+// operation failed because the caller gave up or ran out of time, e.g. by closing the connection, not because of the
+// directory
+func (b *breaker) record(ctx context.Context, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+
+	switch {
+	case ctx.Err() != nil:
+		// the caller gave up, which says nothing about the directory
+	case IsUnavailable(err):
+		b.failures++
+		if b.failures >= b.threshold {
+			b.until = time.Now().Add(b.cooldown)
+			if b.failures == b.threshold {
+				breakerTrips.With(b.tenant).Inc()
+				log.Printf("Directory failed %d operations in a row; failing operations until %s: %v\n",
+					b.failures, b.until.Format(time.RFC3339), err)
+			}
+		}
+	default:
+		if b.failures >= b.threshold {
+			log.Println("Directory recovered")
+		}
+		b.failures = 0
+	}
+}
+
+// Do runs op unless the circuit breaker is open. op isn't retried, so Do is safe for operations that aren't idempotent.
+// op should return when ctx is done
+func (p *Pool) Do(ctx context.Context, op func() error) error {
+	if err := p.breaker.allow(); err != nil {
+		return err
+	}
+
+	err := op()
+	p.breaker.record(ctx, err)
+	return err
+}
+
+// Retry runs op unless the circuit breaker is open, retrying with jittered exponential backoff while it fails because
+// the directory is unavailable, up to Retries times. op must be idempotent. Retry returns when ctx is done
+func (p *Pool) Retry(ctx context.Context, op func() error) error {
+	if err := p.breaker.allow(); err != nil {
+		return err
+	}
+
+	err := op()
+	delay := p.config.RetryDelay
+	for attempt := 0; attempt < p.config.Retries && IsUnavailable(err) && ctx.Err() == nil; attempt++ {
+		// sleep between half and all of delay so retrying clients don't move in lockstep
+		t := time.NewTimer(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			p.breaker.record(ctx, err)
+			return err
+		}
+
+		retries.With(p.config.Tenant).Inc()
+		err = op()
+		delay *= 2
+	}
+
+	p.breaker.record(ctx, err)
+	return err
+}
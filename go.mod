@@ -3,17 +3,18 @@ module github.com/korylprince/userbrowser-server/v3
 go 1.17
 
 require (
+	github.com/go-asn1-ber/asn1-ber v1.5.4
 	github.com/go-ldap/ldap/v3 v3.4.4
 	github.com/gofrs/uuid v4.4.0+incompatible
 	github.com/gorilla/mux v1.8.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/korylprince/go-ad-auth/v3 v3.3.0
 	github.com/korylprince/securetoken v0.0.0-20190611184920-89a29a7f448c
+	rsc.io/qr v0.2.0
 )
 
 require (
 	github.com/Azure/go-ntlmssp v0.0.0-20221128193559-754e69321358 // indirect
-	github.com/go-asn1-ber/asn1-ber v1.5.4 // indirect
 	golang.org/x/crypto v0.7.0 // indirect
 	golang.org/x/text v0.8.0 // indirect
 )
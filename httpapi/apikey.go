@@ -0,0 +1,140 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+type apiKeyResponse struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Permissions []auth.GradeRange `json:"permissions"`
+	Actions     []string          `json:"actions,omitempty"`
+	CreatedBy   string            `json:"created_by"`
+	Created     time.Time         `json:"created"`
+	Expires     *time.Time        `json:"expires,omitempty"`
+	Revoked     *time.Time        `json:"revoked,omitempty"`
+	LastUsed    *time.Time        `json:"last_used,omitempty"`
+	Token       string            `json:"token,omitempty"`
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func newAPIKeyResponse(k *apikey.Key) *apiKeyResponse {
+	return &apiKeyResponse{
+		ID:          k.ID,
+		Name:        k.Name,
+		Permissions: k.Permissions,
+		Actions:     k.Actions,
+		CreatedBy:   k.CreatedBy,
+		Created:     k.Created,
+		Expires:     timePtr(k.Expires),
+		Revoked:     timePtr(k.Revoked),
+		LastUsed:    timePtr(k.LastUsed),
+	}
+}
+
+func (s *Server) listAPIKeys(r *http.Request) (int, interface{}) {
+	keys, err := s.apiKeys.List()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list API keys: %v", err)
+	}
+
+	resp := make([]*apiKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, newAPIKeyResponse(k))
+	}
+
+	return http.StatusOK, resp
+}
+
+func (s *Server) createAPIKey(r *http.Request) (int, interface{}) {
+	type request struct {
+		Name        string            `json:"name"`
+		Permissions []auth.GradeRange `json:"permissions"`
+		Actions     []string          `json:"actions"`
+		Expires     time.Time         `json:"expires"`
+	}
+
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if req.Name == "" {
+		return http.StatusBadRequest, errors.New("Missing name")
+	}
+
+	if len(req.Permissions) == 0 {
+		return http.StatusBadRequest, errors.New("Missing permissions")
+	}
+
+	for _, p := range req.Permissions {
+		if p.MinGrade > p.MaxGrade {
+			return http.StatusBadRequest, fmt.Errorf("Invalid grade range: %d<>%d", p.MinGrade, p.MaxGrade)
+		}
+	}
+
+	for _, a := range req.Actions {
+		if !apikey.ValidAction(a) {
+			return http.StatusBadRequest, fmt.Errorf("Invalid action: %s", a)
+		}
+	}
+
+	if !req.Expires.IsZero() && req.Expires.Before(time.Now()) {
+		return http.StatusBadRequest, errors.New("Expiration is in the past")
+	}
+
+	token, key, err := apikey.Generate(req.Name, req.Permissions, req.Actions, req.Expires, user.Username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to generate API key: %v", err)
+	}
+
+	if err = s.apiKeys.Add(key); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to store API key: %v", err)
+	}
+
+	(r.Context().Value(contextKeyLogData)).(*logData).ActionID = key.ID
+
+	resp := newAPIKeyResponse(key)
+	resp.Token = token
+
+	return http.StatusOK, resp
+}
+
+func (s *Server) revokeAPIKey(r *http.Request) (int, interface{}) {
+	id := mux.Vars(r)["id"]
+
+	key, err := s.apiKeys.Get(id)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to get API key %s: %v", id, err)
+	}
+
+	if key == nil {
+		return http.StatusNotFound, nil
+	}
+
+	if key.Revoked.IsZero() {
+		key.Revoked = time.Now()
+		if err = s.apiKeys.Update(key); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Unable to revoke API key %s: %v", id, err)
+		}
+	}
+
+	return http.StatusOK, newAPIKeyResponse(key)
+}
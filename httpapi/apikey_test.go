@@ -0,0 +1,87 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	apikeyfile "github.com/korylprince/userbrowser-server/v3/apikey/file"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+func TestAPIKeyActions(t *testing.T) {
+	store := apikeyfile.New(filepath.Join(t.TempDir(), "apikeys.json"))
+	s := newTestServer(t, newTestDB(&db.User{Username: "jdoe12", Grade: 3}), newTestAuth(), WithAPIKeys(store))
+	h := s.Router()
+
+	token := func(actions ...string) string {
+		token, key, err := apikey.Generate("key", []auth.GradeRange{auth.AllGrades}, actions, time.Time{}, "admin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = store.Add(key); err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	all, list, reset := token(), token(apikey.ActionList), token(apikey.ActionReset)
+
+	cases := []struct {
+		token, method, path string
+		code                int
+	}{
+		{all, "GET", "/users", http.StatusOK},
+		{all, "POST", "/users/jdoe12/reset", http.StatusOK},
+		{list, "GET", "/users", http.StatusOK},
+		{list, "POST", "/users/jdoe12/reset", http.StatusForbidden},
+		{reset, "GET", "/users", http.StatusForbidden},
+		{reset, "POST", "/users/jdoe12/reset", http.StatusOK},
+		// API keys can never be used for administration
+		{all, "GET", "/apikeys", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		if w := do(t, h, c.method, c.path, c.token, nil); w.Code != c.code {
+			t.Errorf("%s %s with %s: expected %d, got %d: %s", c.method, c.path, c.token[:16], c.code, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAPIKeyCreateActions(t *testing.T) {
+	admin := &auth.User{Username: "admin", Admin: true, Permissions: []auth.GradeRange{auth.AllGrades}}
+	store := apikeyfile.New(filepath.Join(t.TempDir(), "apikeys.json"))
+	s := newTestServer(t, newTestDB(), newTestAuth(admin), WithAPIKeys(store))
+	h := s.Router()
+
+	id, err := s.sessionStore.Create((*session.Session)(admin))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := do(t, h, "POST", "/apikeys", id, map[string]interface{}{
+		"name": "sis", "permissions": []auth.GradeRange{auth.AllGrades}, "actions": []string{apikey.ActionList},
+	})
+	resp := new(apiKeyResponse)
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(resp) != nil {
+		t.Fatalf("unexpected response: %d: %s", w.Code, w.Body)
+	}
+	if len(resp.Actions) != 1 || resp.Actions[0] != apikey.ActionList || resp.Token == "" {
+		t.Errorf("unexpected key: %+v", resp)
+	}
+	if k, _ := store.Get(resp.ID); k == nil || !k.Allows(apikey.ActionList) || k.Allows(apikey.ActionReset) {
+		t.Errorf("unexpected stored key: %+v", k)
+	}
+
+	w = do(t, h, "POST", "/apikeys", id, map[string]interface{}{
+		"name": "sis", "permissions": []auth.GradeRange{auth.AllGrades}, "actions": []string{"delete"},
+	})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid action, got %d", w.Code)
+	}
+}
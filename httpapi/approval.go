@@ -0,0 +1,250 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+type approvalConfig struct {
+	store      approval.Store
+	policy     *approval.Policy
+	expiration time.Duration
+
+	// mu serializes creating requests, claiming them to decide, and storing decisions
+	mu *sync.Mutex
+	// deciding holds the IDs of requests claimed by an approval or rejection in progress
+	deciding map[string]bool
+}
+
+// requestApproval creates a pending request to reset resetUser's password
+func (s *Server) requestApproval(r *http.Request, user *auth.User, resetUser *db.User) (int, interface{}) {
+	s.approvals.mu.Lock()
+	defer s.approvals.mu.Unlock()
+
+	pending, err := s.approvals.store.List(approval.StatusPending)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list approval requests: %v", err)
+	}
+
+	for _, p := range pending {
+		if strings.EqualFold(p.Username, resetUser.Username) {
+			return http.StatusConflict, &errResponse{Err: fmt.Sprintf("A reset for %s is already waiting for approval", resetUser.Username)}
+		}
+	}
+
+	req, err := approval.NewRequest(resetUser, user.Username, s.approvals.expiration)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to create approval request: %v", err)
+	}
+
+	if err = s.approvals.store.Add(req); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to store approval request: %v", err)
+	}
+
+	(r.Context().Value(contextKeyLogData)).(*logData).ApprovalID = req.ID
+
+	return http.StatusAccepted, &resetResponse{Approval: req}
+}
+
+func (s *Server) listApprovals(r *http.Request) (int, interface{}) {
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	status := approval.Status(r.FormValue("status"))
+	switch status {
+	case "":
+		status = approval.StatusPending
+	case "all":
+		status = ""
+	case approval.StatusPending, approval.StatusApproved, approval.StatusRejected, approval.StatusExpired:
+	default:
+		return http.StatusBadRequest, fmt.Errorf("Invalid status: %s", status)
+	}
+
+	requests, err := s.approvals.store.List(status)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list approval requests: %v", err)
+	}
+
+	filtered := make([]*approval.Request, 0, len(requests))
+	for _, req := range requests {
+		if user.Authorized(req.Grade) {
+			filtered = append(filtered, req)
+		}
+	}
+
+	return http.StatusOK, filtered
+}
+
+// claim marks the pending request in the URL as being decided, so other decisions fail until it's released
+func (s *Server) claim(r *http.Request) (*approval.Request, int, error) {
+	id := mux.Vars(r)["id"]
+
+	s.approvals.mu.Lock()
+	defer s.approvals.mu.Unlock()
+
+	req, err := s.approvals.store.Get(id)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Unable to get approval request %s: %v", id, err)
+	}
+
+	if req == nil {
+		return nil, http.StatusNotFound, nil
+	}
+
+	l := (r.Context().Value(contextKeyLogData)).(*logData)
+	l.ActionID = req.Username
+	l.ApprovalID = req.ID
+	l.RequestedBy = req.RequestedBy
+
+	if req.Status != approval.StatusPending {
+		return nil, http.StatusConflict, &errResponse{Err: fmt.Sprintf("Request is already %s", req.Status)}
+	}
+
+	if s.approvals.deciding[req.ID] {
+		return nil, http.StatusConflict, &errResponse{Err: "Request is already being decided"}
+	}
+
+	s.approvals.deciding[req.ID] = true
+
+	return req, 0, nil
+}
+
+// release clears the mark set by claim
+func (s *Server) release(req *approval.Request) {
+	s.approvals.mu.Lock()
+	defer s.approvals.mu.Unlock()
+
+	delete(s.approvals.deciding, req.ID)
+}
+
+// save stores a claimed request
+func (s *Server) save(req *approval.Request) error {
+	s.approvals.mu.Lock()
+	defer s.approvals.mu.Unlock()
+
+	return s.approvals.store.Update(req)
+}
+
+// decide claims the pending request in the URL after checking the user can decide it.
+// If status is 0, the caller must release the request
+func (s *Server) decide(r *http.Request, user *auth.User) (*approval.Request, int, error) {
+	req, status, err := s.claim(r)
+	if status != 0 {
+		return nil, status, err
+	}
+
+	// check the student's current grade, since it may have changed since the request
+	ctx, cancel := withTimeout(r, s.timeouts.Get)
+	defer cancel()
+
+	resetUser, err := s.db.Get(ctx, req.Username)
+	if err != nil {
+		s.release(req)
+		status, err := directoryError(err, fmt.Sprintf("Unable to locate user %s", req.Username))
+		return nil, status, err
+	}
+
+	if resetUser == nil {
+		s.release(req)
+		status, err := directoryError(&db.NotFoundError{Username: req.Username}, "Unable to locate user")
+		return nil, status, err
+	}
+
+	if !user.Authorized(resetUser.Grade) {
+		s.release(req)
+		return nil, http.StatusForbidden, fmt.Errorf("User %s doesn't have permissions to modify %s", user.Username, req.Username)
+	}
+
+	return req, 0, nil
+}
+
+// approveReset resets the password of an approved request. s.approvals.mu isn't held during the reset,
+// so slow directory operations don't block other requests. The request is stored as approved before the reset,
+// so it can't be approved again if storing fails afterwards, and returned to pending if the reset fails
+func (s *Server) approveReset(r *http.Request) (int, interface{}) {
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	req, status, err := s.decide(r, user)
+	if status != 0 {
+		return status, err
+	}
+
+	if strings.EqualFold(req.RequestedBy, user.Username) {
+		s.release(req)
+		return http.StatusForbidden, &errResponse{Err: "Resets must be approved by a different user"}
+	}
+
+	defer s.release(req)
+
+	req.Status = approval.StatusApproved
+	req.DecidedBy = user.Username
+	req.Decided = time.Now()
+
+	if err = s.save(req); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to update approval request: %v", err)
+	}
+
+	status, body := s.reset(r, req.Username)
+	if status != http.StatusOK {
+		req.Status = approval.StatusPending
+		req.DecidedBy = ""
+		req.Decided = time.Time{}
+		if err = s.save(req); err != nil {
+			log.Printf("Unable to return approval request %s to pending after failed reset: %v\n", req.ID, err)
+		}
+		return status, body
+	}
+
+	resp := body.(*resetResponse)
+	resp.Approval = req
+
+	return http.StatusOK, resp
+}
+
+func (s *Server) rejectReset(r *http.Request) (int, interface{}) {
+	type request struct {
+		Reason string `json:"reason"`
+	}
+
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	req := new(request)
+	if r.ContentLength != 0 {
+		if err := jsonRequest(r, req); err != nil {
+			return http.StatusBadRequest, err
+		}
+	}
+
+	if len(req.Reason) > 500 {
+		return http.StatusBadRequest, errors.New("Reason is too long")
+	}
+
+	approvalReq, status, err := s.decide(r, user)
+	if status != 0 {
+		return status, err
+	}
+
+	approvalReq.Status = approval.StatusRejected
+	approvalReq.DecidedBy = user.Username
+	approvalReq.Decided = time.Now()
+	approvalReq.Reason = req.Reason
+
+	err = s.save(approvalReq)
+	s.release(approvalReq)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to update approval request: %v", err)
+	}
+
+	return http.StatusOK, approvalReq
+}
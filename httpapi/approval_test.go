@@ -0,0 +1,148 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	approvalfile "github.com/korylprince/userbrowser-server/v3/approval/file"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+// blockingDB blocks resets until unblock is closed, signaling started when each reset begins
+type blockingDB struct {
+	*testDB
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (d *blockingDB) ResetPassword(ctx context.Context, username string) (string, error) {
+	d.started <- struct{}{}
+	<-d.unblock
+	return d.testDB.ResetPassword(ctx, username)
+}
+
+func TestApproveResetUnlocked(t *testing.T) {
+	d := &blockingDB{
+		testDB:  newTestDB(&db.User{Username: "jdoe12", Grade: 3}, &db.User{Username: "asmith7", Grade: 3}),
+		started: make(chan struct{}, 1),
+		unblock: make(chan struct{}),
+	}
+	store := approvalfile.New(filepath.Join(t.TempDir(), "approvals.json"))
+	s := newTestServer(t, d, newTestAuth(),
+		WithApprovals(store, &approval.Policy{Grades: []auth.GradeRange{auth.AllGrades}}, time.Hour))
+	h := s.Router()
+
+	session := func(username string) string {
+		id, err := s.sessionStore.Create(&session.Session{Username: username, Permissions: []auth.GradeRange{auth.AllGrades}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	requester, approver, other := session("staff"), session("admin"), session("other")
+
+	req, err := approval.NewRequest(&db.User{Username: "jdoe12", Grade: 3}, "staff", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Add(req); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/approvals/" + req.ID + "/approve"
+	done := make(chan int)
+	go func() {
+		done <- do(t, h, "POST", path, approver, nil).Code
+	}()
+	select {
+	case <-d.started:
+	case code := <-done:
+		t.Fatalf("expected reset to start, approval returned %d", code)
+	}
+
+	// the request is stored as approved before the reset
+	if stored, err := store.Get(req.ID); err != nil || stored.Status != approval.StatusApproved {
+		t.Errorf("expected request to be stored as approved during the reset, got %+v, %v", stored, err)
+	}
+
+	// while the reset is in progress, other approval requests aren't blocked and the request can't be decided again
+	if code := do(t, h, "POST", "/users/asmith7/reset", requester, nil).Code; code != http.StatusAccepted {
+		t.Errorf("expected %d creating another request, got %d", http.StatusAccepted, code)
+	}
+	if code := do(t, h, "POST", path, other, nil).Code; code != http.StatusConflict {
+		t.Errorf("expected %d approving a request being approved, got %d", http.StatusConflict, code)
+	}
+	if code := do(t, h, "POST", "/approvals/"+req.ID+"/reject", other, nil).Code; code != http.StatusConflict {
+		t.Errorf("expected %d rejecting a request being approved, got %d", http.StatusConflict, code)
+	}
+
+	close(d.unblock)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, code)
+	}
+
+	stored, err := store.Get(req.ID)
+	if err != nil || stored.Status != approval.StatusApproved || stored.DecidedBy != "admin" {
+		t.Errorf("unexpected stored request: %+v, %v", stored, err)
+	}
+	if code := do(t, h, "POST", path, other, nil).Code; code != http.StatusConflict {
+		t.Errorf("expected %d approving an approved request, got %d", http.StatusConflict, code)
+	}
+}
+
+// failingDB fails resets while fail is true
+type failingDB struct {
+	*testDB
+	fail bool
+}
+
+func (d *failingDB) ResetPassword(ctx context.Context, username string) (string, error) {
+	if d.fail {
+		return "", errors.New("directory unavailable")
+	}
+	return d.testDB.ResetPassword(ctx, username)
+}
+
+func TestApproveResetFailed(t *testing.T) {
+	d := &failingDB{testDB: newTestDB(&db.User{Username: "jdoe12", Grade: 3}), fail: true}
+	store := approvalfile.New(filepath.Join(t.TempDir(), "approvals.json"))
+	s := newTestServer(t, d, newTestAuth(),
+		WithApprovals(store, &approval.Policy{Grades: []auth.GradeRange{auth.AllGrades}}, time.Hour))
+	h := s.Router()
+
+	approver, err := s.sessionStore.Create(&session.Session{Username: "admin", Permissions: []auth.GradeRange{auth.AllGrades}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := approval.NewRequest(&db.User{Username: "jdoe12", Grade: 3}, "staff", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Add(req); err != nil {
+		t.Fatal(err)
+	}
+
+	path := "/approvals/" + req.ID + "/approve"
+	if code := do(t, h, "POST", path, approver, nil).Code; code != http.StatusInternalServerError {
+		t.Fatalf("expected %d, got %d", http.StatusInternalServerError, code)
+	}
+
+	// a failed reset returns the request to pending so it can be approved again
+	stored, err := store.Get(req.ID)
+	if err != nil || stored.Status != approval.StatusPending || stored.DecidedBy != "" || !stored.Decided.IsZero() {
+		t.Errorf("expected pending request after failed reset, got %+v, %v", stored, err)
+	}
+
+	d.fail = false
+	if code := do(t, h, "POST", path, approver, nil).Code; code != http.StatusOK {
+		t.Errorf("expected %d approving again, got %d", http.StatusOK, code)
+	}
+}
@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/audit"
+)
+
+// defaultAuditLimit is the number of records returned if no limit is given
+const defaultAuditLimit = 100
+
+// parseTime parses an RFC 3339 timestamp or a YYYY-MM-DD date in the server's time zone
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+func (s *Server) queryAudit(r *http.Request) (int, interface{}) {
+	v := r.URL.Query()
+
+	q := &audit.Query{
+		Actor:  v.Get("actor"),
+		Target: v.Get("target"),
+		Action: v.Get("action"),
+		Limit:  defaultAuditLimit,
+	}
+
+	var err error
+
+	if since := v.Get("since"); since != "" {
+		if q.Since, err = parseTime(since); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("Unable to parse since: %v", err)
+		}
+	}
+
+	if until := v.Get("until"); until != "" {
+		if q.Until, err = parseTime(until); err != nil {
+			return http.StatusBadRequest, fmt.Errorf("Unable to parse until: %v", err)
+		}
+	}
+
+	if limit := v.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit < 0 {
+			return http.StatusBadRequest, fmt.Errorf("Invalid limit: %s", limit)
+		}
+	}
+
+	records, err := s.audit.Query(q)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to query audit records: %v", err)
+	}
+
+	if records == nil {
+		records = []*audit.Record{}
+	}
+
+	return http.StatusOK, records
+}
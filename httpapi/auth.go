@@ -2,11 +2,17 @@ package httpapi
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/auth/oidc"
 	"github.com/korylprince/userbrowser-server/v3/session"
 )
 
@@ -20,6 +26,7 @@ func (s *Server) authenticate(r *http.Request) (int, interface{}) {
 		Username    string `json:"username"`
 		DisplayName string `json:"display_name"`
 		SessionID   string `json:"session_id"`
+		Admin       bool   `json:"admin"`
 	}
 
 	req := new(request)
@@ -28,15 +35,124 @@ func (s *Server) authenticate(r *http.Request) (int, interface{}) {
 		return http.StatusBadRequest, err
 	}
 
-	user, err := s.auth.Authenticate(req.Username, req.Password)
+	(r.Context().Value(contextKeyLogData)).(*logData).User = req.Username
+
+	ctx, cancel := withTimeout(r, s.timeouts.Authenticate)
+	defer cancel()
+
+	user, err := s.auth.Authenticate(ctx, req.Username, req.Password)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("Unable to authenticate: %v", err)
+		if errors.As(err, new(*auth.InvalidCredentialsError)) {
+			s.observeAuthentication("password", authFailure)
+		} else {
+			s.observeAuthentication("password", authError)
+		}
+		return directoryError(err, "Unable to authenticate")
 	}
 
 	if user == nil {
+		s.observeAuthentication("password", authFailure)
 		return http.StatusUnauthorized, errors.New("Invalid username or password")
 	}
 
+	s.observeAuthentication("password", authSuccess)
+
+	if status, body := s.mfaChallenge(user); body != nil {
+		return status, body
+	}
+
+	id, err := s.sessionStore.Create((*session.Session)(user))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to create session: %v", err)
+	}
+
+	return http.StatusOK, &response{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		SessionID:   id,
+		Admin:       user.Admin,
+	}
+}
+
+// oidcStateCookie ties an OpenID Connect login to the browser that started it, so a state started by someone else
+// can't be used to log the browser in as them
+const oidcStateCookie = "userbrowser_oidc_state"
+
+type oidcLoginResponse struct {
+	URL   string `json:"url"`
+	State string `json:"state"`
+	// cookie is only sent to the browser that started the login
+	cookie *http.Cookie
+}
+
+func (r *oidcLoginResponse) cookies() []*http.Cookie {
+	return []*http.Cookie{r.cookie}
+}
+
+func (s *Server) oidcLogin(r *http.Request) (int, interface{}) {
+	url, state, err := s.oidc.AuthURL(r.Context())
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to start OpenID Connect login: %v", err)
+	}
+
+	return http.StatusOK, &oidcLoginResponse{URL: url, State: state, cookie: &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     r.URL.Path,
+		MaxAge:   int(oidc.LoginExpiration / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}}
+}
+
+func (s *Server) oidcAuthenticate(r *http.Request) (int, interface{}) {
+	type request struct {
+		State string `json:"state"`
+		Code  string `json:"code"`
+	}
+
+	type response struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+		SessionID   string `json:"session_id"`
+		Admin       bool   `json:"admin"`
+	}
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if cookie, err := r.Cookie(oidcStateCookie); err != nil ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(req.State)) != 1 {
+		s.observeAuthentication("oidc", authFailure)
+		return http.StatusUnauthorized, errors.New("Login wasn't started by this browser")
+	}
+
+	ctx, cancel := withTimeout(r, s.timeouts.Authenticate)
+	defer cancel()
+
+	user, err := s.oidc.Exchange(ctx, req.State, req.Code)
+	if err != nil {
+		s.observeAuthentication("oidc", authFailure)
+		return http.StatusUnauthorized, fmt.Errorf("Unable to authenticate: %v", err)
+	}
+
+	if user == nil {
+		s.observeAuthentication("oidc", authFailure)
+		return http.StatusUnauthorized, errors.New("User has no permissions")
+	}
+
+	s.observeAuthentication("oidc", authSuccess)
+
+	(r.Context().Value(contextKeyLogData)).(*logData).User = user.Username
+
+	if status, body := s.mfaChallenge(user); body != nil {
+		return status, body
+	}
+
 	id, err := s.sessionStore.Create((*session.Session)(user))
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Errorf("Unable to create session: %v", err)
@@ -46,18 +162,27 @@ func (s *Server) authenticate(r *http.Request) (int, interface{}) {
 		Username:    user.Username,
 		DisplayName: user.DisplayName,
 		SessionID:   id,
+		Admin:       user.Admin,
 	}
 }
 
-func withAuth(store session.Store, next returnHandlerFunc) returnHandlerFunc {
+func (s *Server) withAuth(next returnHandlerFunc) returnHandlerFunc {
 	return func(r *http.Request) (int, interface{}) {
 		header := strings.Split(r.Header.Get("Authorization"), " ")
 
-		if len(header) != 2 || header[0] != "Bearer" || len(header[1]) != 36 {
+		if len(header) != 2 || header[0] != "Bearer" {
 			return http.StatusBadRequest, errors.New("Invalid Authorization header")
 		}
 
-		session, err := store.Check(header[1])
+		if s.apiKeys != nil && strings.HasPrefix(header[1], apikey.Prefix) {
+			return s.withAPIKey(header[1], next)(r)
+		}
+
+		if len(header[1]) != 36 {
+			return http.StatusBadRequest, errors.New("Invalid Authorization header")
+		}
+
+		session, err := s.sessionStore.Check(header[1])
 		if err != nil {
 			return http.StatusInternalServerError, fmt.Errorf("Unexpected error when checking session id %s: %v", header[1], err)
 		}
@@ -74,3 +199,73 @@ func withAuth(store session.Store, next returnHandlerFunc) returnHandlerFunc {
 		return status, body
 	}
 }
+
+func (s *Server) withAPIKey(token string, next returnHandlerFunc) returnHandlerFunc {
+	return func(r *http.Request) (int, interface{}) {
+		id, secret, ok := apikey.Parse(token)
+		if !ok {
+			return http.StatusBadRequest, errors.New("Invalid API key")
+		}
+
+		key, err := s.apiKeys.Get(id)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Unexpected error when checking API key %s: %v", id, err)
+		}
+
+		now := time.Now()
+		if key == nil || !key.Check(secret) || !key.Valid(now) {
+			s.observeAuthentication("apikey", authFailure)
+			return http.StatusUnauthorized, fmt.Errorf("Invalid, expired, or revoked API key %s", id)
+		}
+
+		if err = s.apiKeys.Used(id, now); err != nil {
+			log.Printf("Unable to update last used time for API key %s: %v\n", id, err)
+		}
+
+		user := key.User()
+
+		l := (r.Context().Value(contextKeyLogData)).(*logData)
+		l.User = user.Username
+		l.APIKey = key.ID
+
+		ctx := context.WithValue(r.Context(), contextKeyUser, (*session.Session)(user))
+		ctx = context.WithValue(ctx, contextKeyAPIKey, key)
+
+		return next(r.WithContext(ctx))
+	}
+}
+
+// withSessionOnly rejects requests authenticated with an API key
+func withSessionOnly(next returnHandlerFunc) returnHandlerFunc {
+	return func(r *http.Request) (int, interface{}) {
+		if r.Context().Value(contextKeyAPIKey) != nil {
+			return http.StatusForbidden, errors.New("API keys can't be used for this action")
+		}
+
+		return next(r)
+	}
+}
+
+// withAction rejects requests authenticated with an API key that can't be used for action
+func withAction(action string, next returnHandlerFunc) returnHandlerFunc {
+	return func(r *http.Request) (int, interface{}) {
+		if key, ok := r.Context().Value(contextKeyAPIKey).(*apikey.Key); ok && !key.Allows(action) {
+			return http.StatusForbidden, fmt.Errorf("API key %s can't be used for action %s", key.ID, action)
+		}
+
+		return next(r)
+	}
+}
+
+// withAdmin only allows administrators. API keys are never allowed
+func withAdmin(next returnHandlerFunc) returnHandlerFunc {
+	return func(r *http.Request) (int, interface{}) {
+		user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+		if r.Context().Value(contextKeyAPIKey) != nil || !user.Admin {
+			return http.StatusForbidden, fmt.Errorf("User %s is not an administrator", user.Username)
+		}
+
+		return next(r)
+	}
+}
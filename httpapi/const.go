@@ -10,4 +10,5 @@ type contextKey int
 const (
 	contextKeyUser contextKey = iota
 	contextKeyLogData
+	contextKeyAPIKey
 )
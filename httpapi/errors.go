@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+)
+
+// machine-readable codes returned in the error_code field of error responses
+const (
+	errorCodeNotFound           = "not_found"
+	errorCodeUnknownGrade       = "unknown_grade"
+	errorCodePasswordPolicy     = "password_policy"
+	errorCodeUnavailable        = "directory_unavailable"
+	errorCodeTimeout            = "timeout"
+	errorCodeInvalidCredentials = "invalid_credentials"
+	errorCodeBindFailure        = "bind_failure"
+)
+
+// codedError is an error with a machine-readable code. Unlike an errResponse, its message isn't shown to the user
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// directoryError returns the status and response for err, returned from a db.DB or auth.Auth operation.
+// msg describes the operation that failed
+func directoryError(err error, msg string) (int, error) {
+	var (
+		notFound        *db.NotFoundError
+		unknownGrade    *db.UnknownGradeError
+		policy          *db.PasswordPolicyError
+		dbUnavailable   *db.UnavailableError
+		authUnavailable *auth.UnavailableError
+		dbBind          *db.BindError
+		authBind        *auth.BindError
+		invalid         *auth.InvalidCredentialsError
+	)
+
+	wrapped := fmt.Errorf("%s: %w", msg, err)
+
+	switch {
+	case errors.As(err, &notFound):
+		return http.StatusNotFound, &errResponse{Err: notFound.Error(), ErrorCode: errorCodeNotFound}
+	case errors.As(err, &invalid):
+		return http.StatusUnauthorized, &errResponse{Err: "Invalid username or password", ErrorCode: errorCodeInvalidCredentials}
+	case errors.As(err, &policy):
+		return http.StatusUnprocessableEntity, &errResponse{
+			Err: "The new password was rejected by the directory's password policy", ErrorCode: errorCodePasswordPolicy,
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, &codedError{code: errorCodeTimeout, err: wrapped}
+	case errors.As(err, &dbUnavailable), errors.As(err, &authUnavailable):
+		return http.StatusServiceUnavailable, &codedError{code: errorCodeUnavailable, err: wrapped}
+	case errors.As(err, &dbBind), errors.As(err, &authBind):
+		return http.StatusBadGateway, &codedError{code: errorCodeBindFailure, err: wrapped}
+	case errors.As(err, &unknownGrade):
+		return http.StatusInternalServerError, &codedError{code: errorCodeUnknownGrade, err: wrapped}
+	}
+
+	return http.StatusInternalServerError, wrapped
+}
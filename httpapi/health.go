@@ -0,0 +1,116 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// healthCheckTimeout is how long a readiness check can run before it fails
+const healthCheckTimeout = 10 * time.Second
+
+// HealthCheck is a named readiness check. Check should return when ctx is done
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+	Checked  time.Time `json:"checked"`
+}
+
+type healthChecker struct {
+	checks []*HealthCheck
+	ttl    time.Duration
+
+	mu      *sync.Mutex
+	results map[string]*checkResult
+}
+
+func newHealthChecker(ttl time.Duration, checks []*HealthCheck) *healthChecker {
+	return &healthChecker{checks: checks, ttl: ttl, mu: new(sync.Mutex), results: make(map[string]*checkResult)}
+}
+
+// run returns the result of the check, running it if the cached result is older than the TTL
+func (h *healthChecker) run(c *HealthCheck) *checkResult {
+	h.mu.Lock()
+	r, ok := h.results[c.Name]
+	h.mu.Unlock()
+	if ok && time.Since(r.Checked) < h.ttl {
+		return r
+	}
+
+	// the result is shared with other requests, so the check isn't canceled if this request is
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.Check(ctx)
+	r = &checkResult{Status: "ok", Duration: time.Since(start).String(), Checked: start}
+	if err != nil {
+		r.Status = "fail"
+		r.Error = err.Error()
+	}
+
+	h.mu.Lock()
+	h.results[c.Name] = r
+	h.mu.Unlock()
+
+	return r
+}
+
+func writeHealth(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set(headerContentType, mediaTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Println("Error writing JSON response:", err)
+	}
+}
+
+// healthz reports that the process is alive
+func healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz runs every check in parallel and reports whether the server can serve requests
+func (h *healthChecker) readyz(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Status string                  `json:"status"`
+		Checks map[string]*checkResult `json:"checks"`
+	}
+
+	resp := &response{Status: "ok", Checks: make(map[string]*checkResult)}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c *HealthCheck) {
+			defer wg.Done()
+			result := h.run(c)
+			mu.Lock()
+			resp.Checks[c.Name] = result
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	for _, result := range resp.Checks {
+		if result.Status != "ok" {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeHealth(w, status, resp)
+}
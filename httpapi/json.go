@@ -12,9 +12,15 @@ import (
 
 type returnHandlerFunc func(*http.Request) (int, interface{})
 
+// cookieSetter is implemented by response bodies that set cookies
+type cookieSetter interface {
+	cookies() []*http.Cookie
+}
+
 type jsonResponse struct {
 	Code        int    `json:"code"`
 	Description string `json:"description"`
+	ErrorCode   string `json:"error_code,omitempty"`
 	Debug       string `json:"debug,omitempty"`
 }
 
@@ -23,18 +29,29 @@ func withJSONResponse(next returnHandlerFunc) http.Handler {
 		code, body := next(r)
 
 		if err, ok := body.(error); ok || body == nil {
-			resp := jsonResponse{Code: code, Description: http.StatusText(code)}
+			resp := &jsonResponse{Code: code, Description: http.StatusText(code)}
 			body = resp
 			if err != nil {
 				(r.Context().Value(contextKeyLogData)).(*logData).Error = err.Error()
 				if er, ok := err.(*errResponse); ok {
 					body = er
-				} else if Debug {
-					resp.Debug = err.Error()
+				} else {
+					if ce, ok := err.(*codedError); ok {
+						resp.ErrorCode = ce.code
+					}
+					if Debug {
+						resp.Debug = err.Error()
+					}
 				}
 			}
 		}
 
+		if c, ok := body.(cookieSetter); ok {
+			for _, cookie := range c.cookies() {
+				http.SetCookie(w, cookie)
+			}
+		}
+
 		w.Header().Set(headerContentType, "application/json")
 		w.WriteHeader(code)
 
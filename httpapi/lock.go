@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"strings"
+	"sync"
+)
+
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// keyLocks serializes operations on the same key, e.g. a username
+type keyLocks struct {
+	mu    *sync.Mutex
+	locks map[string]*keyLock
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{mu: new(sync.Mutex), locks: make(map[string]*keyLock)}
+}
+
+// lock blocks until the lock for key is acquired and returns a function that releases it. Keys are case-insensitive
+func (l *keyLocks) lock(key string) (unlock func()) {
+	key = strings.ToLower(key)
+
+	l.mu.Lock()
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = new(keyLock)
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.mu.Lock()
+
+	return func() {
+		kl.mu.Unlock()
+
+		l.mu.Lock()
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
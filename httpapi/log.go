@@ -2,19 +2,23 @@ package httpapi
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/audit"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
 )
 
 // Debug will pass debugging information to the client if true
 var Debug = false
 
+// TrustProxy will use the X-Forwarded-For header to determine the client IP if true
+var TrustProxy = false
+
 type statusWriter struct {
 	http.ResponseWriter
 	Status int
@@ -25,19 +29,26 @@ func (w *statusWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
-type logData struct {
-	Action   string        `json:"action"`
-	ActionID string        `json:"action_id,omitempty"`
-	User     string        `json:"user,omitempty"`
-	Result   int           `json:"result"`
-	Error    string        `json:"error,omitempty"`
-	Time     time.Time     `json:"time"`
-	Duration time.Duration `json:"duration"`
+type logData = logsink.Entry
+
+// clientIP returns the IP address of the client that made the request
+func clientIP(r *http.Request) string {
+	if TrustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
 
-func withLogging(action string, output io.Writer, next http.Handler) http.Handler {
+func (s *Server) withLogging(action string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		l := &logData{Action: action}
+		l := &logData{Action: action, ClientIP: clientIP(r), UserAgent: r.UserAgent(), Tenant: s.tenant}
 
 		if id, ok := mux.Vars(r)["id"]; ok {
 			l.ActionID = id
@@ -53,13 +64,35 @@ func withLogging(action string, output io.Writer, next http.Handler) http.Handle
 		l.Time = time.Now()
 		l.Duration = l.Time.Sub(t)
 
-		j, err := json.Marshal(l)
-		if err != nil {
-			log.Println("Unable to marshal JSON:", err)
-		}
-		_, err = fmt.Fprintln(output, string(j))
+		s.observeRequest(action, l.Result, l.Duration)
+
+		err := s.output.Write(l)
 		if err != nil {
 			log.Println("Unable to output log:", err)
 		}
+
+		if s.audit != nil {
+			err = s.audit.Append(&audit.Record{
+				Time:      l.Time,
+				Actor:     l.User,
+				APIKey:    l.APIKey,
+				Action:    l.Action,
+				Target:    l.ActionID,
+				ClientIP:  l.ClientIP,
+				UserAgent: l.UserAgent,
+				Result:    l.Result,
+				Error:     l.Error,
+
+				ApprovalID:  l.ApprovalID,
+				RequestedBy: l.RequestedBy,
+			})
+			if err != nil {
+				log.Println("Unable to write audit record:", err)
+			}
+		}
+
+		if s.webhooks != nil {
+			s.notify(l)
+		}
 	})
 }
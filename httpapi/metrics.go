@@ -0,0 +1,47 @@
+package httpapi
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/metrics"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+var (
+	requestsTotal = metrics.NewCounterVec("userbrowser_http_requests_total",
+		"Number of API requests by tenant, action and status code.", "tenant", "action", "code")
+	requestDuration = metrics.NewHistogramVec("userbrowser_http_request_duration_seconds",
+		"Latency of API requests by tenant and action.", nil, "tenant", "action")
+	authenticationsTotal = metrics.NewCounterVec("userbrowser_authentications_total",
+		"Number of authentication attempts by tenant, method and result.", "tenant", "method", "result")
+	activeSessions = metrics.NewGaugeFuncVec("userbrowser_active_sessions",
+		"Number of active sessions by tenant.", "tenant")
+)
+
+// authentication results
+const (
+	authSuccess = "success"
+	authFailure = "failure"
+	authError   = "error"
+)
+
+func (s *Server) observeRequest(action string, status int, duration time.Duration) {
+	requestsTotal.With(s.tenant, action, strconv.Itoa(status)).Inc()
+	requestDuration.With(s.tenant, action).Observe(duration.Seconds())
+}
+
+func (s *Server) observeAuthentication(method, result string) {
+	authenticationsTotal.With(s.tenant, method, result).Inc()
+}
+
+// registerSessionGauge exposes the number of active sessions in store, labeled with the server's tenant
+func (s *Server) registerSessionGauge(store session.Store) {
+	activeSessions.Func(func() float64 {
+		n, err := store.Count()
+		if err != nil {
+			return -1
+		}
+		return float64(n)
+	}, s.tenant)
+}
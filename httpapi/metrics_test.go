@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/metrics"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+// scrape returns the lines of metrics.Default for the named metric and tenant
+func scrape(t *testing.T, name, tenant string) []string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metrics.Default.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	var lines []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, name+`{tenant="`+tenant+`"`) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestRequestMetrics(t *testing.T) {
+	students := newTestDB(&db.User{Username: "jdoe12", Grade: 3}, &db.User{Username: "asmith7", Grade: 3})
+	s := newTestServer(t, students, newTestAuth(), WithMetrics(), WithTenant("request-metrics"))
+	h := s.Router()
+	id, err := s.sessionStore.Create(&session.Session{Username: "admin", Permissions: []auth.GradeRange{auth.AllGrades}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/users/jdoe12/reset", "/users/asmith7/reset", "/users/nobody1/reset"} {
+		do(t, h, "POST", path, id, nil)
+	}
+	// unmatched paths aren't counted
+	do(t, h, "GET", "/users/jdoe12/unknown", id, nil)
+	do(t, h, "GET", "/jdoe12", id, nil)
+
+	// requests are labeled with the route's action, never the username or path
+	lines := scrape(t, "userbrowser_http_requests_total", "request-metrics")
+	expected := []string{
+		`userbrowser_http_requests_total{tenant="request-metrics",action="ResetPassword",code="200"} 2`,
+		`userbrowser_http_requests_total{tenant="request-metrics",action="ResetPassword",code="404"} 1`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(lines, "\n"))
+	}
+	for _, line := range scrape(t, "userbrowser_http_request_duration_seconds_count", "request-metrics") {
+		if !strings.Contains(line, `action="ResetPassword"`) {
+			t.Errorf("unexpected duration series: %s", line)
+		}
+	}
+}
+
+func TestMetricsNotRouted(t *testing.T) {
+	s := newTestServer(t, newTestDB(), newTestAuth(), WithMetrics(), WithWebUI())
+	h := s.Router()
+
+	// metrics cover every tenant, so the API doesn't serve them
+	for _, path := range []string{"/metrics", "/api/metrics", apiPath + "/metrics"} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "userbrowser_") {
+			t.Errorf("%s: expected metrics not to be served, got %d", path, w.Code)
+		}
+	}
+}
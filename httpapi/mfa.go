@@ -0,0 +1,381 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/mfa"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+// mfaExpiration is how long a user has to provide a code after entering their password
+const mfaExpiration = 5 * time.Minute
+
+type mfaConfig struct {
+	store          mfa.Store
+	pending        *mfa.Pending
+	issuer         string
+	requiredGroups []string
+
+	// locks serializes reading, verifying and saving each user's enrollment, so a code can only be used once
+	locks *keyLocks
+}
+
+type enrollResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"`
+}
+
+// required returns true if MFA is mandatory for the given user
+func (c *mfaConfig) required(user *auth.User) bool {
+	return user.InGroup(c.requiredGroups...)
+}
+
+// enroll creates a new unconfirmed enrollment for the given user, replacing any existing unconfirmed enrollment
+func (c *mfaConfig) enroll(username string) (int, interface{}) {
+	unlock := c.locks.lock(username)
+	defer unlock()
+
+	e, err := c.store.Get(username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to get enrollment for %s: %v", username, err)
+	}
+
+	if e != nil && e.Confirmed {
+		return http.StatusConflict, &errResponse{Err: "User is already enrolled"}
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to generate secret: %v", err)
+	}
+
+	e = &mfa.Enrollment{Username: username, Secret: secret, Created: time.Now()}
+	if err = c.store.Put(e); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to save enrollment for %s: %v", username, err)
+	}
+
+	uri := mfa.URI(c.issuer, username, secret)
+	png, err := mfa.QRCode(uri)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to generate QR code: %v", err)
+	}
+
+	return http.StatusOK, &enrollResponse{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}
+}
+
+// check verifies code against e and saves the attempt. The user's lock must be held
+func (c *mfaConfig) check(e *mfa.Enrollment, code string) (status int, err error) {
+	now := time.Now()
+	if e.Locked(now) {
+		return http.StatusTooManyRequests, &errResponse{Err: "Too many invalid codes. Try again later"}
+	}
+
+	ok := e.Verify(code, now)
+
+	if err = c.store.Put(e); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to save enrollment for %s: %v", e.Username, err)
+	}
+
+	if !ok {
+		return http.StatusUnauthorized, &errResponse{Err: "Invalid code"}
+	}
+
+	return http.StatusOK, nil
+}
+
+// confirm confirms the user's pending enrollment with the given code and returns new recovery codes
+func (c *mfaConfig) confirm(username, code string) (recoveryCodes []string, status int, err error) {
+	unlock := c.locks.lock(username)
+	defer unlock()
+
+	e, err := c.store.Get(username)
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Unable to get enrollment for %s: %v", username, err)
+	}
+
+	if e == nil || e.Confirmed {
+		return nil, http.StatusConflict, &errResponse{Err: "No pending enrollment"}
+	}
+
+	if status, err = c.check(e, code); err != nil {
+		return nil, status, err
+	}
+
+	codes, hashes, err := mfa.NewRecoveryCodes()
+	if err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Unable to generate recovery codes: %v", err)
+	}
+
+	e.Confirmed = true
+	e.RecoveryCodes = hashes
+
+	if err = c.store.Put(e); err != nil {
+		return nil, http.StatusInternalServerError, fmt.Errorf("Unable to save enrollment for %s: %v", username, err)
+	}
+
+	return codes, http.StatusOK, nil
+}
+
+// verify checks the code against the user's confirmed enrollment. If the code is valid and update isn't nil,
+// update is called with the enrollment before the user's lock is released
+func (c *mfaConfig) verify(username, code string, update func(e *mfa.Enrollment) (int, error)) (status int, err error) {
+	unlock := c.locks.lock(username)
+	defer unlock()
+
+	e, err := c.store.Get(username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to get enrollment for %s: %v", username, err)
+	}
+
+	if e == nil || !e.Confirmed {
+		return http.StatusConflict, &errResponse{Err: "User is not enrolled"}
+	}
+
+	if status, err = c.check(e, code); err != nil || update == nil {
+		return status, err
+	}
+
+	return update(e)
+}
+
+// mfaChallenge returns a response requiring a second factor if the user needs one, or nil if not
+func (s *Server) mfaChallenge(user *auth.User) (int, interface{}) {
+	type response struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+		MFARequired bool   `json:"mfa_required"`
+		MFAEnrolled bool   `json:"mfa_enrolled"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	if s.mfa == nil {
+		return 0, nil
+	}
+
+	e, err := s.mfa.store.Get(user.Username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to get enrollment for %s: %v", user.Username, err)
+	}
+
+	enrolled := e != nil && e.Confirmed
+	if !enrolled && !s.mfa.required(user) {
+		return 0, nil
+	}
+
+	token, err := s.mfa.pending.Create(user)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to create MFA token: %v", err)
+	}
+
+	return http.StatusOK, &response{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		MFARequired: true,
+		MFAEnrolled: enrolled,
+		MFAToken:    token,
+	}
+}
+
+// mfaAuthenticate completes a login with a TOTP or recovery code. Users required to use MFA that haven't
+// enrolled yet confirm their enrollment here and receive their recovery codes
+func (s *Server) mfaAuthenticate(r *http.Request) (int, interface{}) {
+	type request struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	type response struct {
+		Username      string   `json:"username"`
+		DisplayName   string   `json:"display_name"`
+		SessionID     string   `json:"session_id"`
+		Admin         bool     `json:"admin"`
+		RecoveryCodes []string `json:"recovery_codes,omitempty"`
+	}
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	user := s.mfa.pending.Get(req.MFAToken)
+	if user == nil {
+		return http.StatusUnauthorized, errors.New("Invalid or expired MFA token")
+	}
+
+	(r.Context().Value(contextKeyLogData)).(*logData).User = user.Username
+
+	e, err := s.mfa.store.Get(user.Username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to get enrollment for %s: %v", user.Username, err)
+	}
+
+	var (
+		codes  []string
+		status int
+	)
+
+	if e != nil && e.Confirmed {
+		status, err = s.mfa.verify(user.Username, req.Code, nil)
+	} else {
+		codes, status, err = s.mfa.confirm(user.Username, req.Code)
+	}
+
+	if err != nil {
+		if status == http.StatusUnauthorized || status == http.StatusTooManyRequests {
+			s.observeAuthentication("totp", authFailure)
+			s.mfa.pending.Fail(req.MFAToken)
+		}
+		return status, err
+	}
+
+	s.observeAuthentication("totp", authSuccess)
+
+	s.mfa.pending.Delete(req.MFAToken)
+
+	id, err := s.sessionStore.Create((*session.Session)(user))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to create session: %v", err)
+	}
+
+	return http.StatusOK, &response{
+		Username:      user.Username,
+		DisplayName:   user.DisplayName,
+		SessionID:     id,
+		Admin:         user.Admin,
+		RecoveryCodes: codes,
+	}
+}
+
+// mfaPendingEnroll starts enrollment for a user that is required to use MFA but hasn't enrolled yet
+func (s *Server) mfaPendingEnroll(r *http.Request) (int, interface{}) {
+	type request struct {
+		MFAToken string `json:"mfa_token"`
+	}
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	user := s.mfa.pending.Get(req.MFAToken)
+	if user == nil {
+		return http.StatusUnauthorized, errors.New("Invalid or expired MFA token")
+	}
+
+	(r.Context().Value(contextKeyLogData)).(*logData).User = user.Username
+
+	return s.mfa.enroll(user.Username)
+}
+
+func (s *Server) mfaEnroll(r *http.Request) (int, interface{}) {
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	return s.mfa.enroll(user.Username)
+}
+
+func (s *Server) mfaConfirm(r *http.Request) (int, interface{}) {
+	type request struct {
+		Code string `json:"code"`
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	codes, status, err := s.mfa.confirm(user.Username, req.Code)
+	if err != nil {
+		return status, err
+	}
+
+	return http.StatusOK, &response{RecoveryCodes: codes}
+}
+
+func (s *Server) mfaRecoveryCodes(r *http.Request) (int, interface{}) {
+	type request struct {
+		Code string `json:"code"`
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	var codes []string
+
+	status, err := s.mfa.verify(user.Username, req.Code, func(e *mfa.Enrollment) (int, error) {
+		var hashes []string
+		var err error
+		if codes, hashes, err = mfa.NewRecoveryCodes(); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Unable to generate recovery codes: %v", err)
+		}
+
+		e.RecoveryCodes = hashes
+		if err = s.mfa.store.Put(e); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Unable to save enrollment for %s: %v", user.Username, err)
+		}
+
+		return http.StatusOK, nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	return http.StatusOK, &response{RecoveryCodes: codes}
+}
+
+func (s *Server) mfaDisable(r *http.Request) (int, interface{}) {
+	type request struct {
+		Code string `json:"code"`
+	}
+
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	if s.mfa.required(user) {
+		return http.StatusForbidden, &errResponse{Err: "MFA is required for this user"}
+	}
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	status, err := s.mfa.verify(user.Username, req.Code, func(e *mfa.Enrollment) (int, error) {
+		if err := s.mfa.store.Delete(user.Username); err != nil {
+			return http.StatusInternalServerError, fmt.Errorf("Unable to delete enrollment for %s: %v", user.Username, err)
+		}
+		return http.StatusOK, nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	return http.StatusOK, nil
+}
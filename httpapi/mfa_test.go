@@ -0,0 +1,175 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/mfa"
+	mfafile "github.com/korylprince/userbrowser-server/v3/mfa/file"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+// slowStore delays reads, so concurrent requests that aren't serialized all read the enrollment before any saves it
+type slowStore struct {
+	mfa.Store
+}
+
+func (s slowStore) Get(username string) (*mfa.Enrollment, error) {
+	e, err := s.Store.Get(username)
+	time.Sleep(10 * time.Millisecond)
+	return e, err
+}
+
+// newMFAServer returns a *Server with MFA enabled and a confirmed enrollment for user
+func newMFAServer(t *testing.T, user *auth.User) (*Server, *mfa.Enrollment, []string) {
+	t.Helper()
+	store := mfafile.New(filepath.Join(t.TempDir(), "mfa.json"), testTokenKey)
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := mfa.NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &mfa.Enrollment{Username: user.Username, Secret: secret, Confirmed: true, RecoveryCodes: hashes, Created: time.Now()}
+	if err = store.Put(e); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestServer(t, newTestDB(), newTestAuth(user), WithMFA(slowStore{store}, "User Browser", nil)), e, codes
+}
+
+// concurrently sends n requests made by body to the API at once and returns the status codes
+func concurrently(t *testing.T, h http.Handler, n int, path string, sessionID string, body func(i int) interface{}) []int {
+	t.Helper()
+	codes := make([]int, n)
+	start := make(chan struct{})
+	wg := new(sync.WaitGroup)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			codes[i] = do(t, h, "POST", path, sessionID, body(i)).Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return codes
+}
+
+func count(codes []int, code int) int {
+	var n int
+	for _, c := range codes {
+		if c == code {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMFACodeUsedOnce(t *testing.T) {
+	user := &auth.User{Username: "staff", Permissions: []auth.GradeRange{auth.AllGrades}}
+	s, e, _ := newMFAServer(t, user)
+	h := s.Router()
+
+	code, err := mfa.Code(e.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 10
+	tokens := make([]string, n)
+	for i := range tokens {
+		if tokens[i], err = s.mfa.pending.Create(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	codes := concurrently(t, h, n, "/auth/mfa", "", func(i int) interface{} {
+		return map[string]string{"mfa_token": tokens[i], "code": code}
+	})
+	if count(codes, http.StatusOK) != 1 {
+		t.Errorf("expected code to be accepted once, got statuses %v", codes)
+	}
+}
+
+func TestMFARecoveryCodeUsedOnce(t *testing.T) {
+	user := &auth.User{Username: "staff", Permissions: []auth.GradeRange{auth.AllGrades}}
+	s, _, recovery := newMFAServer(t, user)
+	h := s.Router()
+
+	id, err := s.sessionStore.Create((*session.Session)(user))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codes := concurrently(t, h, 5, "/mfa/recovery-codes", id, func(i int) interface{} {
+		return map[string]string{"code": recovery[0]}
+	})
+	if count(codes, http.StatusOK) != 1 {
+		t.Errorf("expected recovery code to be accepted once, got statuses %v", codes)
+	}
+}
+
+func TestMFALockout(t *testing.T) {
+	user := &auth.User{Username: "staff", Permissions: []auth.GradeRange{auth.AllGrades}}
+	s, e, _ := newMFAServer(t, user)
+	h := s.Router()
+
+	login := func(code string) int {
+		// each login gets a new token, so the per-token attempt limit doesn't apply
+		token, err := s.mfa.pending.Create(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return do(t, h, "POST", "/auth/mfa", "", map[string]string{"mfa_token": token, "code": code}).Code
+	}
+
+	var locked bool
+	for i := 0; i < 20; i++ {
+		status := login("invalid")
+		if status == http.StatusTooManyRequests {
+			locked = true
+			break
+		}
+		if status != http.StatusUnauthorized {
+			t.Fatalf("expected %d, got %d", http.StatusUnauthorized, status)
+		}
+	}
+	if !locked {
+		t.Fatal("invalid codes weren't rate limited")
+	}
+
+	code, err := mfa.Code(e.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := login(code); status != http.StatusTooManyRequests {
+		t.Errorf("expected valid code to be rejected while locked with %d, got %d", http.StatusTooManyRequests, status)
+	}
+}
+
+func TestMFAChallenge(t *testing.T) {
+	user := &auth.User{Username: "staff", Permissions: []auth.GradeRange{auth.AllGrades}}
+	s, _, _ := newMFAServer(t, user)
+
+	w := do(t, s.Router(), "POST", "/auth", "", map[string]string{"username": "staff", "password": "password"})
+	var resp struct {
+		SessionID string `json:"session_id"`
+		MFAToken  string `json:"mfa_token"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || resp.SessionID != "" || resp.MFAToken == "" {
+		t.Errorf("expected MFA challenge, got %d: %+v", w.Code, resp)
+	}
+}
@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/korylprince/userbrowser-server/v3/auth/oidc"
+)
+
+func TestOIDCStateCookie(t *testing.T) {
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+		})
+	}))
+	defer idp.Close()
+
+	s := newTestServer(t, newTestDB(), newTestAuth(),
+		WithOIDC(oidc.New(&oidc.Config{Issuer: idp.URL, ClientID: "userbrowser"}, nil)))
+	h := s.Router()
+	sink := s.output.(*testSink)
+
+	w := do(t, h, "GET", "/auth/oidc", "", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Code)
+	}
+	resp := new(oidcLoginResponse)
+	if err := json.NewDecoder(w.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || cookie.Value != resp.State || !cookie.HttpOnly || cookie.MaxAge <= 0 {
+		t.Fatalf("unexpected state cookie: %+v", cookie)
+	}
+
+	// authenticate sends the state and code, with the cookie if set, and returns the logged error
+	authenticate := func(c *http.Cookie) string {
+		t.Helper()
+		r := httptest.NewRequest("POST", apiPath+"/auth/oidc",
+			strings.NewReader(`{"state": "`+resp.State+`", "code": "code"}`))
+		r.Header.Set(headerContentType, mediaTypeJSON)
+		if c != nil {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %d, got %d", http.StatusUnauthorized, w.Code)
+		}
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		return sink.entries[len(sink.entries)-1].Error
+	}
+
+	const rejected = "Login wasn't started by this browser"
+	if err := authenticate(nil); err != rejected {
+		t.Errorf("expected login without cookie to be rejected, got %q", err)
+	}
+	if err := authenticate(&http.Cookie{Name: oidcStateCookie, Value: "other"}); err != rejected {
+		t.Errorf("expected login with another state's cookie to be rejected, got %q", err)
+	}
+	// the state isn't used up by rejected logins, so the exchange is attempted
+	if err := authenticate(cookie); err == rejected || strings.Contains(err, "Unknown or expired state") {
+		t.Errorf("expected login with cookie to reach the identity provider, got %q", err)
+	}
+}
@@ -0,0 +1,14 @@
+package httpapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerContentType, mediaTypeJSON)
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,493 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"go/ast"
+	"go/constant"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	apikeyfile "github.com/korylprince/userbrowser-server/v3/apikey/file"
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	approvalfile "github.com/korylprince/userbrowser-server/v3/approval/file"
+	auditfile "github.com/korylprince/userbrowser-server/v3/audit/file"
+	"github.com/korylprince/userbrowser-server/v3/auth/oidc"
+	"github.com/korylprince/userbrowser-server/v3/auth/webauthn"
+	webauthnfile "github.com/korylprince/userbrowser-server/v3/auth/webauthn/file"
+	mfafile "github.com/korylprince/userbrowser-server/v3/mfa/file"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+	webhookfile "github.com/korylprince/userbrowser-server/v3/webhook/file"
+)
+
+// operations maps each documented operation to the handler and the helpers it returns successful responses from
+var operations = map[string][]string{
+	"GET /openapi.json":                 nil,
+	"POST /auth":                        {"authenticate", "mfaChallenge"},
+	"GET /auth/oidc":                    {"oidcLogin"},
+	"POST /auth/oidc":                   {"oidcAuthenticate", "mfaChallenge"},
+	"POST /auth/mfa":                    {"mfaAuthenticate"},
+	"POST /auth/mfa/enroll":             {"mfaPendingEnroll", "enroll"},
+	"POST /mfa/enroll":                  {"mfaEnroll", "enroll"},
+	"POST /mfa/confirm":                 {"mfaConfirm"},
+	"POST /mfa/recovery-codes":          {"mfaRecoveryCodes"},
+	"POST /mfa/disable":                 {"mfaDisable"},
+	"POST /auth/webauthn/begin":         {"webauthnLoginBegin"},
+	"POST /auth/webauthn/finish":        {"webauthnLoginFinish", "mfaChallenge"},
+	"POST /webauthn/register/begin":     {"webauthnRegisterBegin"},
+	"POST /webauthn/register/finish":    {"webauthnRegisterFinish"},
+	"GET /webauthn/credentials":         {"webauthnListCredentials"},
+	"DELETE /webauthn/credentials/{id}": {"webauthnDeleteCredential"},
+	"GET /apikeys":                      {"listAPIKeys"},
+	"POST /apikeys":                     {"createAPIKey"},
+	"DELETE /apikeys/{id}":              {"revokeAPIKey"},
+	"GET /sessions":                     {"listSessions"},
+	"DELETE /sessions/{id}":             {"deleteSession"},
+	"GET /audit":                        {"queryAudit"},
+	"GET /webhooks/deliveries":          {"listWebhookDeliveries"},
+	"GET /users":                        {"listUsers"},
+	"POST /users/{username}/reset":      {"resetPassword", "reset", "requestApproval"},
+	"GET /approvals":                    {"listApprovals"},
+	"POST /approvals/{id}/approve":      {"approveReset", "reset"},
+	"POST /approvals/{id}/reject":       {"rejectReset"},
+}
+
+type specSchema struct {
+	Ref        string                 `json:"$ref"`
+	Type       string                 `json:"type"`
+	Properties map[string]*specSchema `json:"properties"`
+	Items      *specSchema            `json:"items"`
+	OneOf      []*specSchema          `json:"oneOf"`
+	AllOf      []*specSchema          `json:"allOf"`
+}
+
+type specContent struct {
+	Content map[string]struct {
+		Schema *specSchema `json:"schema"`
+	} `json:"content"`
+	Ref string `json:"$ref"`
+}
+
+type specOperation struct {
+	RequestBody *specContent            `json:"requestBody"`
+	Responses   map[string]*specContent `json:"responses"`
+}
+
+type spec struct {
+	Paths      map[string]map[string]*specOperation `json:"paths"`
+	Components struct {
+		Responses map[string]*specContent `json:"responses"`
+		Schemas   map[string]*specSchema  `json:"schemas"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) *spec {
+	t.Helper()
+	s := new(spec)
+	if err := json.Unmarshal(openAPISpec, s); err != nil {
+		t.Fatalf("Unable to parse OpenAPI document: %v", err)
+	}
+	return s
+}
+
+// resolve returns the schema a $ref points to, or the object schema combining the schemas in allOf
+func (s *spec) resolve(schema *specSchema) *specSchema {
+	for schema != nil && schema.Ref != "" {
+		schema = s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	if schema == nil || len(schema.AllOf) == 0 {
+		return schema
+	}
+
+	combined := &specSchema{Type: "object", Properties: make(map[string]*specSchema)}
+	for _, part := range schema.AllOf {
+		for name, prop := range s.resolve(part).Properties {
+			combined.Properties[name] = prop
+		}
+	}
+	return combined
+}
+
+// jsonSchema returns the JSON schema of c, resolving response references
+func (s *spec) jsonSchema(c *specContent) *specSchema {
+	if c == nil {
+		return nil
+	}
+	if c.Ref != "" {
+		return s.jsonSchema(s.Components.Responses[strings.TrimPrefix(c.Ref, "#/components/responses/")])
+	}
+	return c.Content[mediaTypeJSON].Schema
+}
+
+// newFullServer returns a *Server with every optional feature enabled
+func newFullServer(t *testing.T) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	a := newTestAuth()
+
+	return newTestServer(t, newTestDB(), a,
+		WithOIDC(oidc.New(&oidc.Config{Issuer: "https://idp.example.com", ClientID: "userbrowser"}, nil)),
+		WithMFA(mfafile.New(filepath.Join(dir, "mfa.json"), testTokenKey), "User Browser", nil),
+		WithWebAuthn(webauthn.New(&webauthn.Config{RPID: "example.com", Origins: []string{"https://example.com"}},
+			webauthnfile.New(filepath.Join(dir, "webauthn.json"))), a),
+		WithAPIKeys(apikeyfile.New(filepath.Join(dir, "apikeys.json"))),
+		WithAudit(auditfile.New(filepath.Join(dir, "audit.log"), nil)),
+		WithMetrics(),
+		WithHealthChecks(time.Second),
+		WithWebUI(),
+		WithWebhooks(webhook.New(&webhook.Config{}, webhookfile.New(filepath.Join(dir, "webhooks.json")))),
+		WithPasswordSync(pwsync.New(nil, 1)),
+		WithApprovals(approvalfile.New(filepath.Join(dir, "approvals.json")), &approval.Policy{}, time.Hour),
+		WithTenant("test"),
+		WithTimeouts(Timeouts{}),
+	)
+}
+
+// pathVarRegexp matches the pattern in a mux path variable, e.g. {id:[0-9a-f]{12}}
+var pathVarRegexp = regexp.MustCompile(`\{([^:{}]+):(?:[^{}]|\{[^{}]*\})*\}`)
+
+func TestSpecRoutes(t *testing.T) {
+	s := loadSpec(t)
+
+	documented := make(map[string]bool)
+	for path, ops := range s.Paths {
+		for method := range ops {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	routed := make(map[string]bool)
+	err := newFullServer(t).Router().(*mux.Router).Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tmpl, apiPath+"/") {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		path := pathVarRegexp.ReplaceAllString(strings.TrimPrefix(tmpl, apiPath), "{$1}")
+		for _, m := range methods {
+			routed[m+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unable to walk routes: %v", err)
+	}
+
+	for r := range routed {
+		if !documented[r] {
+			t.Errorf("undocumented route: %s", r)
+		}
+	}
+	for d := range documented {
+		if !routed[d] {
+			t.Errorf("documented route without handler: %s", d)
+		}
+		if _, ok := operations[d]; !ok {
+			t.Errorf("documented route missing from operations: %s", d)
+		}
+	}
+}
+
+// checkPackage type checks the package's non-test files and returns its handler functions by name
+func checkPackage(t *testing.T) (map[string]*ast.FuncDecl, *types.Package, *types.Info) {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("Unable to parse package: %v", err)
+	}
+
+	var files []*ast.File
+	funcs := make(map[string]*ast.FuncDecl)
+	for _, f := range pkgs["httpapi"].Files {
+		files = append(files, f)
+		for _, d := range f.Decls {
+			if fn, ok := d.(*ast.FuncDecl); ok {
+				funcs[fn.Name.Name] = fn
+			}
+		}
+	}
+
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	info := &types.Info{Types: make(map[ast.Expr]types.TypeAndValue)}
+	pkg, err := conf.Check("httpapi", fset, files, info)
+	if err != nil {
+		t.Fatalf("Unable to type check package: %v", err)
+	}
+
+	return funcs, pkg, info
+}
+
+// bodies returns the type of the request decoded by fn, and the types of its responses by status code.
+// Returns nested in function literals aren't included
+func bodies(fn *ast.FuncDecl, pkg *types.Package, info *types.Info) (types.Type, map[int][]types.Type) {
+	var request types.Type
+	responses := make(map[int][]types.Type)
+
+	ast.Inspect(fn.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.CallExpr:
+			if id, ok := n.Fun.(*ast.Ident); ok && id.Name == "jsonRequest" && len(n.Args) == 2 {
+				request = info.Types[n.Args[1]].Type
+			}
+		case *ast.ReturnStmt:
+			if len(n.Results) != 2 {
+				return true
+			}
+			status := info.Types[n.Results[0]].Value
+			if status == nil || status.Kind() != constant.Int {
+				return true
+			}
+			code, _ := constant.Int64Val(status)
+			if code < 200 || code > 299 {
+				return true
+			}
+			typ := info.Types[n.Results[1]].Type
+			if b, ok := typ.(*types.Basic); ok && b.Kind() == types.UntypedNil {
+				typ = pkg.Scope().Lookup("jsonResponse").Type()
+			}
+			responses[int(code)] = append(responses[int(code)], typ)
+		}
+		return true
+	})
+
+	return request, responses
+}
+
+// jsonFields returns the JSON object fields of st, including those of embedded structs
+func jsonFields(st *types.Struct) map[string]types.Type {
+	fields := make(map[string]types.Type)
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		tag := reflect.StructTag(st.Tag(i)).Get("json")
+		name := strings.Split(tag, ",")[0]
+		if name == "-" || (!f.Exported() && !f.Embedded()) {
+			continue
+		}
+		if f.Embedded() && name == "" {
+			typ := f.Type()
+			if p, ok := typ.(*types.Pointer); ok {
+				typ = p.Elem()
+			}
+			if embedded, ok := typ.Underlying().(*types.Struct); ok {
+				for n, t := range jsonFields(embedded) {
+					fields[n] = t
+				}
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name()
+		}
+		fields[name] = f.Type()
+	}
+	return fields
+}
+
+// marshaler is true if typ or a pointer to it implements json.Marshaler
+func marshaler(typ types.Type) bool {
+	for _, t := range []types.Type{typ, types.NewPointer(typ)} {
+		if sel := types.NewMethodSet(t).Lookup(nil, "MarshalJSON"); sel != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// compare returns the differences between typ and schema
+func (s *spec) compare(path string, typ types.Type, schema *specSchema) []string {
+	schema = s.resolve(schema)
+	if schema == nil {
+		return []string{path + ": missing schema"}
+	}
+
+	if len(schema.OneOf) > 0 {
+		var problems []string
+		for _, variant := range schema.OneOf {
+			if problems = s.compare(path, typ, variant); len(problems) == 0 {
+				return nil
+			}
+		}
+		return []string{path + ": matches no oneOf schema: " + strings.Join(problems, ", ")}
+	}
+
+	for {
+		p, ok := typ.(*types.Pointer)
+		if !ok {
+			break
+		}
+		typ = p.Elem()
+	}
+
+	if named, ok := typ.(*types.Named); ok && named.Obj().Pkg() != nil &&
+		named.Obj().Pkg().Path() == "time" && named.Obj().Name() == "Time" {
+		return s.expectType(path, schema, "string")
+	}
+	if marshaler(typ) {
+		return nil
+	}
+
+	switch u := typ.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsString != 0:
+			return s.expectType(path, schema, "string")
+		case u.Info()&types.IsBoolean != 0:
+			return s.expectType(path, schema, "boolean")
+		case u.Info()&types.IsInteger != 0:
+			return s.expectType(path, schema, "integer")
+		case u.Info()&types.IsFloat != 0:
+			return s.expectType(path, schema, "number")
+		}
+	case *types.Slice:
+		if b, ok := u.Elem().(*types.Basic); ok && b.Kind() == types.Byte {
+			return s.expectType(path, schema, "string")
+		}
+		if problems := s.expectType(path, schema, "array"); len(problems) > 0 {
+			return problems
+		}
+		return s.compare(path+"[]", u.Elem(), schema.Items)
+	case *types.Map, *types.Interface:
+		return s.expectType(path, schema, "object")
+	case *types.Struct:
+		if schema.Type != "" && schema.Type != "object" {
+			return []string{path + ": documented as " + schema.Type + ", is object"}
+		}
+		var problems []string
+		fields := jsonFields(u)
+		for name, t := range fields {
+			prop, ok := schema.Properties[name]
+			if !ok {
+				problems = append(problems, path+"."+name+": undocumented field")
+				continue
+			}
+			problems = append(problems, s.compare(path+"."+name, t, prop)...)
+		}
+		for name := range schema.Properties {
+			if _, ok := fields[name]; !ok {
+				problems = append(problems, path+"."+name+": documented field missing")
+			}
+		}
+		return problems
+	}
+
+	return []string{path + ": unsupported type " + typ.String()}
+}
+
+func (s *spec) expectType(path string, schema *specSchema, typ string) []string {
+	if schema.Type != typ {
+		return []string{path + ": documented as " + schema.Type + ", is " + typ}
+	}
+	return nil
+}
+
+func TestSpecBodies(t *testing.T) {
+	s := loadSpec(t)
+	funcs, pkg, info := checkPackage(t)
+
+	for _, name := range []string{"jsonResponse", "errResponse"} {
+		for _, p := range s.compare(name, pkg.Scope().Lookup(name).Type(), s.Components.Schemas[name]) {
+			t.Error(p)
+		}
+	}
+
+	var keys []string
+	for key := range operations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		handlers := operations[key]
+		if handlers == nil {
+			continue
+		}
+		parts := strings.SplitN(key, " ", 2)
+		op := s.Paths[parts[1]][strings.ToLower(parts[0])]
+		if op == nil {
+			t.Errorf("%s: not documented", key)
+			continue
+		}
+
+		var request types.Type
+		responses := make(map[int][]types.Type)
+		for _, name := range handlers {
+			fn, ok := funcs[name]
+			if !ok {
+				t.Errorf("%s: handler %s not found", key, name)
+				continue
+			}
+			req, resps := bodies(fn, pkg, info)
+			if req != nil {
+				request = req
+			}
+			for code, typs := range resps {
+				responses[code] = append(responses[code], typs...)
+			}
+		}
+
+		switch {
+		case request == nil && op.RequestBody != nil:
+			t.Errorf("%s: documented request body isn't read", key)
+		case request != nil && op.RequestBody == nil:
+			t.Errorf("%s: request body isn't documented", key)
+		case request != nil:
+			for _, p := range s.compare("request", request, s.jsonSchema(op.RequestBody)) {
+				t.Errorf("%s: %s", key, p)
+			}
+		}
+
+		for code, typs := range responses {
+			resp, ok := op.Responses[strconv.Itoa(code)]
+			if !ok {
+				t.Errorf("%s: response %d isn't documented", key, code)
+				continue
+			}
+			for _, typ := range typs {
+				for _, p := range s.compare("response "+strconv.Itoa(code), typ, s.jsonSchema(resp)) {
+					t.Errorf("%s: %s", key, p)
+				}
+			}
+		}
+
+		for code := range op.Responses {
+			c, _ := strconv.Atoi(code)
+			if c >= 200 && c <= 299 && len(responses[c]) == 0 {
+				t.Errorf("%s: documented response %d isn't returned", key, c)
+			}
+		}
+	}
+}
+
+func TestSpecServed(t *testing.T) {
+	r, err := http.NewRequest("GET", apiPath+"/openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	newFullServer(t).Router().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || !json.Valid(w.Body.Bytes()) {
+		t.Errorf("GET openapi.json: got %d, valid JSON %v", w.Code, json.Valid(w.Body.Bytes()))
+	}
+}
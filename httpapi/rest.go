@@ -5,13 +5,16 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/approval"
 	"github.com/korylprince/userbrowser-server/v3/auth"
 	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
 	"github.com/korylprince/userbrowser-server/v3/session"
 )
 
 type errResponse struct {
-	Err string `json:"error"`
+	Err       string `json:"error"`
+	ErrorCode string `json:"error_code,omitempty"`
 }
 
 func (e *errResponse) Error() string {
@@ -21,9 +24,12 @@ func (e *errResponse) Error() string {
 func (s *Server) listUsers(r *http.Request) (int, interface{}) {
 	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
 
-	users, err := s.db.List()
+	ctx, cancel := withTimeout(r, s.timeouts.List)
+	defer cancel()
+
+	users, err := s.db.List(ctx)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("Unable to get user list: %v", err)
+		return directoryError(err, "Unable to get user list")
 	}
 
 	var filteredUsers []*db.User
@@ -37,29 +43,55 @@ func (s *Server) listUsers(r *http.Request) (int, interface{}) {
 	return http.StatusOK, filteredUsers
 }
 
-func (s *Server) resetPassword(r *http.Request) (int, interface{}) {
-	type response struct {
-		Password string `json:"password"`
+type resetResponse struct {
+	Password string            `json:"password"`
+	Sync     []*pwsync.Result  `json:"sync,omitempty"`
+	Approval *approval.Request `json:"approval,omitempty"`
+}
+
+// reset resets the user's password and syncs it to the password sync targets
+func (s *Server) reset(r *http.Request, username string) (int, interface{}) {
+	ctx, cancel := withTimeout(r, s.timeouts.ResetPassword)
+	defer cancel()
+
+	passwd, err := s.db.ResetPassword(ctx, username)
+	if err != nil {
+		return directoryError(err, fmt.Sprintf("Unable to reset password for user %s", username))
+	}
+
+	resp := &resetResponse{Password: passwd}
+	if s.pwsync != nil {
+		resp.Sync = s.pwsync.Sync(username, passwd)
 	}
 
+	return http.StatusOK, resp
+}
+
+func (s *Server) resetPassword(r *http.Request) (int, interface{}) {
 	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
 	username := mux.Vars(r)["username"]
 
-	resetUser, err := s.db.Get(username)
+	ctx, cancel := withTimeout(r, s.timeouts.Get)
+	defer cancel()
+
+	resetUser, err := s.db.Get(ctx, username)
 	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("Unable locate user %s: %v", username, err)
+		return directoryError(err, fmt.Sprintf("Unable to locate user %s", username))
 	}
 
-	if resetUser == nil || !user.Authorized(resetUser.Grade) {
+	if resetUser == nil {
+		return directoryError(&db.NotFoundError{Username: username}, "Unable to locate user")
+	}
+
+	if !user.Authorized(resetUser.Grade) {
 		return http.StatusForbidden, fmt.Errorf("User %s doesn't have permissions to modify %s", user.Username, username)
 	}
 
 	(r.Context().Value(contextKeyLogData)).(*logData).ActionID = username
 
-	passwd, err := s.db.ResetPassword(username)
-	if err != nil {
-		return http.StatusInternalServerError, fmt.Errorf("Unable to reset password for user %s: %v", username, err)
+	if s.approvals != nil && s.approvals.policy.Required(resetUser) {
+		return s.requestApproval(r, user, resetUser)
 	}
 
-	return http.StatusOK, &response{Password: passwd}
+	return s.reset(r, username)
 }
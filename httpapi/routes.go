@@ -4,36 +4,182 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	"github.com/korylprince/userbrowser-server/v3/version"
+	"github.com/korylprince/userbrowser-server/v3/web"
 )
 
 // API is the current API version
-const API = "3.0"
+const API = version.API
 const apiPath = "/api/" + API
 
 // Router returns a new API router
 func (s *Server) Router() http.Handler {
 	r := mux.NewRouter()
 
+	r.Methods("GET").Path("/healthz").HandlerFunc(healthz)
+	r.Methods("GET").Path("/readyz").HandlerFunc(s.health.readyz)
+
 	api := r.PathPrefix(apiPath).Subrouter()
 
 	api.NotFoundHandler = withJSONResponse(func(r *http.Request) (int, interface{}) {
 		return http.StatusNotFound, nil
 	})
 
+	api.Methods("GET").Path("/openapi.json").HandlerFunc(openAPI)
+
 	api.Methods("POST").Path("/auth").Handler(
-		withLogging("Authenticate", s.output,
+		s.withLogging("Authenticate",
 			withJSONResponse(
 				s.authenticate)))
 
+	if s.oidc != nil {
+		api.Methods("GET").Path("/auth/oidc").Handler(
+			s.withLogging("OIDCLogin",
+				withJSONResponse(
+					s.oidcLogin)))
+
+		api.Methods("POST").Path("/auth/oidc").Handler(
+			s.withLogging("OIDCAuthenticate",
+				withJSONResponse(
+					s.oidcAuthenticate)))
+	}
+
+	if s.mfa != nil {
+		api.Methods("POST").Path("/auth/mfa").Handler(
+			s.withLogging("MFAAuthenticate",
+				withJSONResponse(
+					s.mfaAuthenticate)))
+
+		api.Methods("POST").Path("/auth/mfa/enroll").Handler(
+			s.withLogging("MFAPendingEnroll",
+				withJSONResponse(
+					s.mfaPendingEnroll)))
+
+		api.Methods("POST").Path("/mfa/enroll").Handler(
+			s.withLogging("MFAEnroll",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.mfaEnroll)))))
+
+		api.Methods("POST").Path("/mfa/confirm").Handler(
+			s.withLogging("MFAConfirm",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.mfaConfirm)))))
+
+		api.Methods("POST").Path("/mfa/recovery-codes").Handler(
+			s.withLogging("MFARecoveryCodes",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.mfaRecoveryCodes)))))
+
+		api.Methods("POST").Path("/mfa/disable").Handler(
+			s.withLogging("MFADisable",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.mfaDisable)))))
+	}
+
+	if s.webauthn != nil {
+		api.Methods("POST").Path("/auth/webauthn/begin").Handler(
+			s.withLogging("WebAuthnLoginBegin",
+				withJSONResponse(
+					s.webauthnLoginBegin)))
+
+		api.Methods("POST").Path("/auth/webauthn/finish").Handler(
+			s.withLogging("WebAuthnLoginFinish",
+				withJSONResponse(
+					s.webauthnLoginFinish)))
+
+		api.Methods("POST").Path("/webauthn/register/begin").Handler(
+			s.withLogging("WebAuthnRegisterBegin",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.webauthnRegisterBegin)))))
+
+		api.Methods("POST").Path("/webauthn/register/finish").Handler(
+			s.withLogging("WebAuthnRegisterFinish",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.webauthnRegisterFinish)))))
+
+		api.Methods("GET").Path("/webauthn/credentials").Handler(
+			s.withLogging("WebAuthnListCredentials",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.webauthnListCredentials)))))
+
+		api.Methods("DELETE").Path("/webauthn/credentials/{id:[A-Za-z0-9_-]+}").Handler(
+			s.withLogging("WebAuthnDeleteCredential",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.webauthnDeleteCredential)))))
+	}
+
+	if s.apiKeys != nil {
+		api.Methods("GET").Path("/apikeys").Handler(
+			s.withLogging("ListAPIKeys",
+				withJSONResponse(
+					s.withAuth(withAdmin(s.listAPIKeys)))))
+
+		api.Methods("POST").Path("/apikeys").Handler(
+			s.withLogging("CreateAPIKey",
+				withJSONResponse(
+					s.withAuth(withAdmin(s.createAPIKey)))))
+
+		api.Methods("DELETE").Path("/apikeys/{id:[0-9a-f]{12}}").Handler(
+			s.withLogging("RevokeAPIKey",
+				withJSONResponse(
+					s.withAuth(withAdmin(s.revokeAPIKey)))))
+	}
+
+	api.Methods("GET").Path("/sessions").Handler(
+		s.withLogging("ListSessions",
+			withJSONResponse(
+				s.withAuth(withAdmin(s.listSessions)))))
+
+	api.Methods("DELETE").Path("/sessions/{id:[0-9a-f]{16}}").Handler(
+		s.withLogging("DeleteSession",
+			withJSONResponse(
+				s.withAuth(withAdmin(s.deleteSession)))))
+
+	if s.audit != nil {
+		api.Methods("GET").Path("/audit").Handler(
+			s.withLogging("QueryAudit",
+				withJSONResponse(
+					s.withAuth(withAdmin(s.queryAudit)))))
+	}
+
+	if s.webhooks != nil {
+		api.Methods("GET").Path("/webhooks/deliveries").Handler(
+			s.withLogging("ListWebhookDeliveries",
+				withJSONResponse(
+					s.withAuth(withAdmin(s.listWebhookDeliveries)))))
+	}
+
 	api.Methods("GET").Path("/users").Handler(
-		withLogging("ListUsers", s.output,
+		s.withLogging("ListUsers",
 			withJSONResponse(
-				withAuth(s.sessionStore, s.listUsers))))
+				s.withAuth(withAction(apikey.ActionList, s.listUsers)))))
 
 	api.Methods("POST").Path("/users/{username:[a-zA-Z]{2,6}[0-9]{1,2}}/reset").Handler(
-		withLogging("ResetPassword", s.output,
+		s.withLogging("ResetPassword",
 			withJSONResponse(
-				withAuth(s.sessionStore, s.resetPassword))))
+				s.withAuth(withAction(apikey.ActionReset, s.resetPassword)))))
+
+	if s.approvals != nil {
+		api.Methods("GET").Path("/approvals").Handler(
+			s.withLogging("ListApprovals",
+				withJSONResponse(
+					s.withAuth(withAction(apikey.ActionApprovals, s.listApprovals)))))
+
+		api.Methods("POST").Path("/approvals/{id:[0-9a-f]{16}}/approve").Handler(
+			s.withLogging("ApproveReset",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.approveReset)))))
+
+		api.Methods("POST").Path("/approvals/{id:[0-9a-f]{16}}/reject").Handler(
+			s.withLogging("RejectReset",
+				withJSONResponse(
+					s.withAuth(withSessionOnly(s.rejectReset)))))
+	}
+
+	if s.webUI {
+		r.PathPrefix("/").Handler(web.Handler(&web.Config{OIDC: s.oidc != nil}))
+	}
 
 	return r
 }
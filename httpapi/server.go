@@ -1,11 +1,23 @@
 package httpapi
 
 import (
-	"io"
+	"context"
+	"net/http"
+	"sync"
+	"time"
 
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	"github.com/korylprince/userbrowser-server/v3/approval"
+	"github.com/korylprince/userbrowser-server/v3/audit"
 	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/auth/oidc"
+	"github.com/korylprince/userbrowser-server/v3/auth/webauthn"
 	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
+	"github.com/korylprince/userbrowser-server/v3/mfa"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
 	"github.com/korylprince/userbrowser-server/v3/session"
+	"github.com/korylprince/userbrowser-server/v3/webhook"
 )
 
 // Server represents shared resources
@@ -13,10 +25,164 @@ type Server struct {
 	db           db.DB
 	auth         auth.Auth
 	sessionStore session.Store
-	output       io.Writer
+	output       logsink.Sink
+
+	oidc *oidc.Provider
+	mfa  *mfaConfig
+
+	webauthn *webauthnConfig
+
+	apiKeys apikey.Store
+
+	audit audit.Store
+
+	metrics bool
+
+	health *healthChecker
+
+	webUI bool
+
+	webhooks *webhook.Dispatcher
+
+	pwsync *pwsync.Syncer
+
+	approvals *approvalConfig
+
+	tenant string
+
+	timeouts Timeouts
+}
+
+// Timeouts are the deadlines for directory operations made while handling a request. A zero value means no deadline,
+// though operations are still canceled if the client disconnects
+type Timeouts struct {
+	Authenticate  time.Duration
+	Get           time.Duration
+	List          time.Duration
+	ResetPassword time.Duration
+}
+
+// withTimeout returns a copy of the request's context that's canceled after d, or when the request is canceled
+// if d is zero
+func withTimeout(r *http.Request, d time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), d)
+}
+
+// Option configures optional Server features
+type Option func(*Server)
+
+// WithOIDC enables OpenID Connect logins with the given provider
+func WithOIDC(provider *oidc.Provider) Option {
+	return func(s *Server) {
+		s.oidc = provider
+	}
+}
+
+// WithMFA enables TOTP second factor logins for enrolled users, using store to persist enrollments.
+// issuer is the name shown in authenticator apps. Members of requiredGroups must enroll before they can log in
+func WithMFA(store mfa.Store, issuer string, requiredGroups []string) Option {
+	return func(s *Server) {
+		s.mfa = &mfaConfig{
+			store:          store,
+			pending:        mfa.NewPending(mfaExpiration),
+			issuer:         issuer,
+			requiredGroups: requiredGroups,
+			locks:          newKeyLocks(),
+		}
+	}
+}
+
+// WithWebAuthn enables WebAuthn credential registration and logins. lookup is used to get the permissions of users
+// that log in with a credential
+func WithWebAuthn(w *webauthn.WebAuthn, lookup auth.Lookup) Option {
+	return func(s *Server) {
+		s.webauthn = &webauthnConfig{webauthn: w, lookup: lookup}
+	}
+}
+
+// WithAPIKeys enables API key authentication and management with the given store
+func WithAPIKeys(store apikey.Store) Option {
+	return func(s *Server) {
+		s.apiKeys = store
+	}
+}
+
+// WithAudit records every action to the given store and enables querying it
+func WithAudit(store audit.Store) Option {
+	return func(s *Server) {
+		s.audit = store
+	}
+}
+
+// WithMetrics adds the number of active sessions to metrics.Default. The metrics aren't served by Router,
+// since they cover every tenant in the process; serve metrics.Default on a separate listener
+func WithMetrics() Option {
+	return func(s *Server) {
+		s.metrics = true
+	}
+}
+
+// WithHealthChecks runs the given checks for /readyz, caching each result for ttl
+func WithHealthChecks(ttl time.Duration, checks ...*HealthCheck) Option {
+	return func(s *Server) {
+		s.health = newHealthChecker(ttl, checks)
+	}
+}
+
+// WithWebUI serves the built-in web frontend at the root of Router
+func WithWebUI() Option {
+	return func(s *Server) {
+		s.webUI = true
+	}
+}
+
+// WithWebhooks notifies d of successful account changes and enables the delivery history endpoint
+func WithWebhooks(d *webhook.Dispatcher) Option {
+	return func(s *Server) {
+		s.webhooks = d
+	}
+}
+
+// WithPasswordSync syncs passwords with syncer after each successful reset
+func WithPasswordSync(syncer *pwsync.Syncer) Option {
+	return func(s *Server) {
+		s.pwsync = syncer
+	}
+}
+
+// WithApprovals requires a second user to approve resets of students matching policy. Requests expire after expiration
+func WithApprovals(store approval.Store, policy *approval.Policy, expiration time.Duration) Option {
+	return func(s *Server) {
+		s.approvals = &approvalConfig{store: store, policy: policy, expiration: expiration, mu: new(sync.Mutex),
+			deciding: make(map[string]bool)}
+	}
+}
+
+// WithTenant adds the tenant's name to log entries
+func WithTenant(name string) Option {
+	return func(s *Server) {
+		s.tenant = name
+	}
+}
+
+// WithTimeouts sets the deadlines for directory operations
+func WithTimeouts(t Timeouts) Option {
+	return func(s *Server) {
+		s.timeouts = t
+	}
 }
 
 // NewServer returns a new server with the given resources
-func NewServer(db db.DB, auth auth.Auth, sessionStore session.Store, output io.Writer) *Server {
-	return &Server{db: db, auth: auth, sessionStore: sessionStore, output: output}
+func NewServer(db db.DB, auth auth.Auth, sessionStore session.Store, output logsink.Sink, opts ...Option) *Server {
+	s := &Server{db: db, auth: auth, sessionStore: sessionStore, output: output, health: newHealthChecker(0, nil)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.metrics {
+		s.registerSessionGauge(sessionStore)
+	}
+	return s
 }
@@ -0,0 +1,446 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/apikey"
+	apikeyfile "github.com/korylprince/userbrowser-server/v3/apikey/file"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/auth/oidc"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
+	"github.com/korylprince/userbrowser-server/v3/session"
+	"github.com/korylprince/userbrowser-server/v3/session/memory"
+)
+
+// testTokenKey is a SecureToken key for stores that encrypt their contents
+const testTokenKey = "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8="
+
+type testDB struct {
+	mu    *sync.Mutex
+	users map[string]*db.User
+}
+
+func newTestDB(users ...*db.User) *testDB {
+	d := &testDB{mu: new(sync.Mutex), users: make(map[string]*db.User)}
+	for _, u := range users {
+		d.users[strings.ToLower(u.Username)] = u
+	}
+	return d
+}
+
+func (d *testDB) Get(ctx context.Context, username string) (*db.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, ok := d.users[strings.ToLower(username)]
+	if !ok {
+		return nil, nil
+	}
+	user := *u
+	return &user, nil
+}
+
+func (d *testDB) List(ctx context.Context) ([]*db.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var users []*db.User
+	for _, u := range d.users {
+		user := *u
+		users = append(users, &user)
+	}
+	return users, nil
+}
+
+func (d *testDB) ResetPassword(ctx context.Context, username string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u, ok := d.users[strings.ToLower(username)]
+	if !ok {
+		return "", &db.NotFoundError{Username: username}
+	}
+	u.Password = "new-" + u.Username
+	return u.Password, nil
+}
+
+// testAuth authenticates users with the password "password"
+type testAuth struct {
+	users map[string]*auth.User
+}
+
+func newTestAuth(users ...*auth.User) *testAuth {
+	a := &testAuth{users: make(map[string]*auth.User)}
+	for _, u := range users {
+		a.users[strings.ToLower(u.Username)] = u
+	}
+	return a
+}
+
+func (a *testAuth) Authenticate(ctx context.Context, username, password string) (*auth.User, error) {
+	u, ok := a.users[strings.ToLower(username)]
+	if !ok || password != "password" {
+		return nil, &auth.InvalidCredentialsError{Username: username}
+	}
+	user := *u
+	return &user, nil
+}
+
+func (a *testAuth) Lookup(ctx context.Context, username string) (*auth.User, error) {
+	u, ok := a.users[strings.ToLower(username)]
+	if !ok {
+		return nil, nil
+	}
+	user := *u
+	return &user, nil
+}
+
+type testSink struct {
+	mu      *sync.Mutex
+	entries []*logsink.Entry
+}
+
+func (s *testSink) Write(e *logsink.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+// newTestServer returns a new *Server backed by d and a, with the given options
+func newTestServer(t *testing.T, d db.DB, a auth.Auth, opts ...Option) *Server {
+	t.Helper()
+	return NewServer(d, a, memory.New(time.Hour), &testSink{mu: new(sync.Mutex)}, opts...)
+}
+
+// do sends a request with the JSON encoding of body, if it isn't nil, to the API and returns the response
+func do(t *testing.T, h http.Handler, method, path, sessionID string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if body != nil {
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(method, apiPath+path, buf)
+	if body != nil {
+		r.Header.Set(headerContentType, mediaTypeJSON)
+	}
+	if sessionID != "" {
+		r.Header.Set("Authorization", "Bearer "+sessionID)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// errDB returns err from List
+type errDB struct {
+	*testDB
+	err error
+}
+
+func (d *errDB) List(ctx context.Context) ([]*db.User, error) {
+	return nil, d.err
+}
+
+func TestDirectoryErrors(t *testing.T) {
+	cause := errors.New("connection reset")
+	for _, test := range []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"not found", &db.NotFoundError{Username: "jdoe12"}, http.StatusNotFound, errorCodeNotFound},
+		{"invalid credentials", &auth.InvalidCredentialsError{Username: "jdoe12"}, http.StatusUnauthorized, errorCodeInvalidCredentials},
+		{"password policy", &db.PasswordPolicyError{Username: "jdoe12", Err: cause}, http.StatusUnprocessableEntity, errorCodePasswordPolicy},
+		{"deadline exceeded", context.DeadlineExceeded, http.StatusGatewayTimeout, errorCodeTimeout},
+		{"unavailable deadline exceeded", &db.UnavailableError{Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, errorCodeTimeout},
+		{"directory unavailable", &db.UnavailableError{Err: cause}, http.StatusServiceUnavailable, errorCodeUnavailable},
+		{"auth unavailable", &auth.UnavailableError{Err: cause}, http.StatusServiceUnavailable, errorCodeUnavailable},
+		{"directory bind", &db.BindError{User: "svc"}, http.StatusBadGateway, errorCodeBindFailure},
+		{"auth bind", &auth.BindError{User: "svc", Err: cause}, http.StatusBadGateway, errorCodeBindFailure},
+		{"unknown grade", &db.UnknownGradeError{DN: "CN=jdoe12"}, http.StatusInternalServerError, errorCodeUnknownGrade},
+		{"other", cause, http.StatusInternalServerError, ""},
+	} {
+		for _, wrap := range []bool{false, true} {
+			err := test.err
+			if wrap {
+				err = fmt.Errorf("Unable to list users: %w", err)
+			}
+
+			d := &errDB{testDB: newTestDB(), err: err}
+			s := newTestServer(t, d, newTestAuth())
+			id, e := s.sessionStore.Create(&session.Session{Username: "staff", Permissions: []auth.GradeRange{auth.AllGrades}})
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			w := do(t, s.Router(), "GET", "/users", id, nil)
+			var resp struct {
+				ErrorCode string `json:"error_code"`
+			}
+			if e = json.NewDecoder(w.Body).Decode(&resp); e != nil {
+				t.Fatalf("%s (wrapped: %v): unable to decode response: %v", test.name, wrap, e)
+			}
+			if w.Code != test.status || resp.ErrorCode != test.code {
+				t.Errorf("%s (wrapped: %v): expected %d %q, got %d %q", test.name, wrap, test.status, test.code, w.Code, resp.ErrorCode)
+			}
+		}
+	}
+}
+
+// login authenticates username with the password "password" and returns the session ID
+func login(t *testing.T, h http.Handler, username string) string {
+	t.Helper()
+	w := do(t, h, "POST", "/auth", "", map[string]string{"username": username, "password": "password"})
+	var resp struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK || resp.SessionID == "" {
+		t.Fatalf("unable to log in as %s: %d, %v", username, w.Code, err)
+	}
+	return resp.SessionID
+}
+
+// usernames returns the sorted usernames in a user list response
+func usernames(t *testing.T, w *httptest.ResponseRecorder) []string {
+	t.Helper()
+	var users []*db.User
+	if err := json.NewDecoder(w.Body).Decode(&users); err != nil {
+		t.Fatalf("unable to decode users: %v", err)
+	}
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAdmin(t *testing.T) {
+	students := newTestDB(&db.User{Username: "jdoe3", Grade: 3}, &db.User{Username: "asmith11", Grade: 11})
+	admin := &auth.User{Username: "admin", Admin: true}
+	teacher := &auth.User{Username: "teacher", Permissions: []auth.GradeRange{{MinGrade: 1, MaxGrade: 5}}}
+	store := apikeyfile.New(filepath.Join(t.TempDir(), "apikeys.json"))
+	s := newTestServer(t, students, newTestAuth(admin, teacher), WithAPIKeys(store))
+	h := s.Router()
+
+	token, key, err := apikey.Generate("key", []auth.GradeRange{auth.AllGrades}, nil, time.Time{}, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Add(key); err != nil {
+		t.Fatal(err)
+	}
+	adminID, teacherID := login(t, h, "admin"), login(t, h, "teacher")
+
+	// the admin role grants every grade without any grade permissions
+	if names := usernames(t, do(t, h, "GET", "/users", adminID, nil)); strings.Join(names, ",") != "asmith11,jdoe3" {
+		t.Errorf("expected admin to see every grade, got %v", names)
+	}
+	if names := usernames(t, do(t, h, "GET", "/users", teacherID, nil)); strings.Join(names, ",") != "jdoe3" {
+		t.Errorf("expected teacher to see grades 1-5, got %v", names)
+	}
+
+	for _, test := range []struct {
+		name, token, method, path string
+		code                      int
+	}{
+		{"admin reset outside grade permissions", adminID, "POST", "/users/asmith11/reset", http.StatusOK},
+		{"teacher reset outside grade permissions", teacherID, "POST", "/users/asmith11/reset", http.StatusForbidden},
+		{"admin", adminID, "GET", "/sessions", http.StatusOK},
+		{"admin", adminID, "GET", "/apikeys", http.StatusOK},
+		{"non-admin", teacherID, "GET", "/sessions", http.StatusForbidden},
+		{"non-admin", teacherID, "GET", "/apikeys", http.StatusForbidden},
+		// an API key is rejected even with every grade
+		{"API key", token, "GET", "/sessions", http.StatusForbidden},
+		{"API key", token, "GET", "/apikeys", http.StatusForbidden},
+	} {
+		if w := do(t, h, test.method, test.path, test.token, nil); w.Code != test.code {
+			t.Errorf("%s: %s %s: expected %d, got %d", test.name, test.method, test.path, test.code, w.Code)
+		}
+	}
+}
+
+func TestWithAdmin(t *testing.T) {
+	ok := func(r *http.Request) (int, interface{}) { return http.StatusOK, nil }
+	for _, test := range []struct {
+		name  string
+		admin bool
+		key   *apikey.Key
+		code  int
+	}{
+		{"admin session", true, nil, http.StatusOK},
+		{"non-admin session", false, nil, http.StatusForbidden},
+		// withAdmin doesn't rely on API key users never being admins
+		{"admin with API key", true, &apikey.Key{ID: "0123456789ab"}, http.StatusForbidden},
+	} {
+		ctx := context.WithValue(context.Background(), contextKeyUser, &session.Session{Username: "user", Admin: test.admin})
+		if test.key != nil {
+			ctx = context.WithValue(ctx, contextKeyAPIKey, test.key)
+		}
+		r := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		if code, _ := withAdmin(ok)(r); code != test.code {
+			t.Errorf("%s: expected %d, got %d", test.name, test.code, code)
+		}
+	}
+}
+
+// testIdP is an OpenID Connect provider that issues an RS256 ID token with the given groups for any code
+type testIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    *sync.Mutex
+	token string
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{key: key, mu: new(sync.Mutex)}
+
+	enc := base64.RawURLEncoding.EncodeToString
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "key", "use": "sig", "n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idp.token})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return idp
+}
+
+// issue sets the ID token returned for the login started at authURL
+func (idp *testIdP) issue(t *testing.T, authURL, username string, groups ...string) {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	now := time.Now()
+	signed := enc(map[string]string{"alg": "RS256", "kid": "key", "typ": "JWT"}) + "." + enc(map[string]interface{}{
+		"iss":                idp.server.URL,
+		"aud":                "userbrowser",
+		"sub":                username,
+		"exp":                now.Add(time.Hour).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              u.Query().Get("nonce"),
+		"preferred_username": username,
+		"groups":             groups,
+	})
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp.mu.Lock()
+	idp.token = signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	idp.mu.Unlock()
+}
+
+func TestOIDCAdmin(t *testing.T) {
+	idp := newTestIdP(t)
+	students := newTestDB(&db.User{Username: "jdoe3", Grade: 3}, &db.User{Username: "asmith11", Grade: 11})
+	s := newTestServer(t, students, newTestAuth(), WithOIDC(oidc.New(&oidc.Config{
+		Issuer:      idp.server.URL,
+		ClientID:    "userbrowser",
+		AdminGroups: []string{"Domain Admins"},
+	}, oidc.Permissions{"teachers": {{MinGrade: 1, MaxGrade: 5}}})))
+	h := s.Router()
+
+	// oidcLogin logs in through the provider as username with groups and returns the session
+	oidcLogin := func(username string, groups ...string) (string, bool) {
+		t.Helper()
+		w := do(t, h, "GET", "/auth/oidc", "", nil)
+		begin := new(oidcLoginResponse)
+		if err := json.NewDecoder(w.Body).Decode(begin); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unable to start login: %d, %v", w.Code, err)
+		}
+		idp.issue(t, begin.URL, username, groups...)
+
+		r := httptest.NewRequest("POST", apiPath+"/auth/oidc",
+			strings.NewReader(`{"state": "`+begin.State+`", "code": "code"}`))
+		r.Header.Set(headerContentType, mediaTypeJSON)
+		r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: begin.State})
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		var resp struct {
+			SessionID string `json:"session_id"`
+			Admin     bool   `json:"admin"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("unable to log in as %s: %d, %v", username, w.Code, err)
+		}
+		return resp.SessionID, resp.Admin
+	}
+
+	// an admin group grants the admin role without grade permissions
+	adminID, admin := oidcLogin("admin", "Domain Admins")
+	if !admin {
+		t.Error("expected member of an admin group to be an admin")
+	}
+	teacherID, admin := oidcLogin("teacher", "teachers")
+	if admin {
+		t.Error("expected teacher not to be an admin")
+	}
+
+	if names := usernames(t, do(t, h, "GET", "/users", adminID, nil)); strings.Join(names, ",") != "asmith11,jdoe3" {
+		t.Errorf("expected admin to see every grade, got %v", names)
+	}
+	if w := do(t, h, "GET", "/sessions", adminID, nil); w.Code != http.StatusOK {
+		t.Errorf("expected admin to list sessions, got %d", w.Code)
+	}
+	if w := do(t, h, "GET", "/sessions", teacherID, nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected teacher to be forbidden, got %d", w.Code)
+	}
+}
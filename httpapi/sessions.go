@@ -0,0 +1,81 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+type sessionResponse struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	Expires     time.Time `json:"expires"`
+}
+
+// sessionHandle returns an identifier for the session that can be shown to administrators without exposing the session ID
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *Server) listSessions(r *http.Request) (int, interface{}) {
+	sessions, err := s.sessionStore.List()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list sessions: %v", err)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].Session.Username == sessions[j].Session.Username {
+			return sessions[i].Expires.Before(sessions[j].Expires)
+		}
+		return sessions[i].Session.Username < sessions[j].Session.Username
+	})
+
+	resp := make([]*sessionResponse, 0, len(sessions))
+	for _, sess := range sessions {
+		resp = append(resp, &sessionResponse{
+			ID:          sessionHandle(sess.ID),
+			Username:    sess.Session.Username,
+			DisplayName: sess.Session.DisplayName,
+			Expires:     sess.Expires,
+		})
+	}
+
+	return http.StatusOK, resp
+}
+
+func (s *Server) deleteSession(r *http.Request) (int, interface{}) {
+	handle := mux.Vars(r)["id"]
+
+	sessions, err := s.sessionStore.List()
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list sessions: %v", err)
+	}
+
+	var sess *session.Active
+	for _, a := range sessions {
+		if sessionHandle(a.ID) == handle {
+			sess = a
+			break
+		}
+	}
+
+	if sess == nil {
+		return http.StatusNotFound, nil
+	}
+
+	(r.Context().Value(contextKeyLogData)).(*logData).ActionID = sess.Session.Username
+
+	if err = s.sessionStore.Delete(sess.ID); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to delete session: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
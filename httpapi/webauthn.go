@@ -0,0 +1,190 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/auth/webauthn"
+	"github.com/korylprince/userbrowser-server/v3/session"
+)
+
+type webauthnConfig struct {
+	webauthn *webauthn.WebAuthn
+	lookup   auth.Lookup
+}
+
+type credentialResponse struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name"`
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+func newCredentialResponse(c *webauthn.Credential) *credentialResponse {
+	return &credentialResponse{
+		ID:       base64.RawURLEncoding.EncodeToString(c.ID),
+		Name:     c.Name,
+		Created:  c.Created,
+		LastUsed: c.LastUsed,
+	}
+}
+
+func (s *Server) webauthnRegisterBegin(r *http.Request) (int, interface{}) {
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	options, err := s.webauthn.webauthn.BeginRegistration(user.Username, user.DisplayName)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to begin registration: %v", err)
+	}
+
+	return http.StatusOK, map[string]interface{}{"publicKey": options}
+}
+
+func (s *Server) webauthnRegisterFinish(r *http.Request) (int, interface{}) {
+	type request struct {
+		Name       string                        `json:"name"`
+		Credential *webauthn.AttestationResponse `json:"credential"`
+	}
+
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if req.Credential == nil {
+		return http.StatusBadRequest, errors.New("Missing credential")
+	}
+
+	cred, err := s.webauthn.webauthn.FinishRegistration(user.Username, req.Name, req.Credential)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("Unable to register credential: %v", err)
+	}
+
+	return http.StatusOK, newCredentialResponse(cred)
+}
+
+func (s *Server) webauthnListCredentials(r *http.Request) (int, interface{}) {
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	creds, err := s.webauthn.webauthn.Store().List(user.Username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list credentials: %v", err)
+	}
+
+	resp := make([]*credentialResponse, 0, len(creds))
+	for _, c := range creds {
+		resp = append(resp, newCredentialResponse(c))
+	}
+
+	return http.StatusOK, resp
+}
+
+func (s *Server) webauthnDeleteCredential(r *http.Request) (int, interface{}) {
+	user := (*auth.User)((r.Context().Value(contextKeyUser)).(*session.Session))
+
+	id, err := base64.RawURLEncoding.DecodeString(mux.Vars(r)["id"])
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("Unable to decode credential id: %v", err)
+	}
+
+	cred, err := s.webauthn.webauthn.Store().Get(id)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to get credential: %v", err)
+	}
+
+	if cred == nil || !strings.EqualFold(cred.Username, user.Username) {
+		return http.StatusNotFound, nil
+	}
+
+	if err = s.webauthn.webauthn.Store().Delete(id); err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to delete credential: %v", err)
+	}
+
+	return http.StatusOK, nil
+}
+
+func (s *Server) webauthnLoginBegin(r *http.Request) (int, interface{}) {
+	type request struct {
+		Username string `json:"username"`
+	}
+
+	req := new(request)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	options, err := s.webauthn.webauthn.BeginLogin(req.Username)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to begin login: %v", err)
+	}
+
+	return http.StatusOK, map[string]interface{}{"publicKey": options}
+}
+
+func (s *Server) webauthnLoginFinish(r *http.Request) (int, interface{}) {
+	type response struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+		SessionID   string `json:"session_id"`
+		Admin       bool   `json:"admin"`
+	}
+
+	req := new(webauthn.AssertionResponse)
+
+	if err := jsonRequest(r, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	cred, err := s.webauthn.webauthn.FinishLogin(req)
+	if err != nil {
+		s.observeAuthentication("webauthn", authFailure)
+		return http.StatusUnauthorized, fmt.Errorf("Unable to authenticate: %v", err)
+	}
+
+	(r.Context().Value(contextKeyLogData)).(*logData).User = cred.Username
+
+	ctx, cancel := withTimeout(r, s.timeouts.Authenticate)
+	defer cancel()
+
+	user, err := s.webauthn.lookup.Lookup(ctx, cred.Username)
+	if err != nil {
+		s.observeAuthentication("webauthn", authError)
+		return directoryError(err, fmt.Sprintf("Unable to look up user %s", cred.Username))
+	}
+
+	if user == nil {
+		s.observeAuthentication("webauthn", authFailure)
+		return http.StatusUnauthorized, errors.New("User is disabled or has no permissions")
+	}
+
+	s.observeAuthentication("webauthn", authSuccess)
+
+	// without user verification a credential is only something the user has, so the MFA policy still applies
+	if !s.webauthn.webauthn.UserVerified() {
+		if status, body := s.mfaChallenge(user); body != nil {
+			return status, body
+		}
+	}
+
+	id, err := s.sessionStore.Create((*session.Session)(user))
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to create session: %v", err)
+	}
+
+	return http.StatusOK, &response{
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		SessionID:   id,
+		Admin:       user.Admin,
+	}
+}
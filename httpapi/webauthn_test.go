@@ -0,0 +1,122 @@
+package httpapi
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/auth/webauthn"
+	webauthnfile "github.com/korylprince/userbrowser-server/v3/auth/webauthn/file"
+	mfafile "github.com/korylprince/userbrowser-server/v3/mfa/file"
+)
+
+// testAuthenticator signs WebAuthn assertions for example.com with an Ed25519 credential
+type testAuthenticator struct {
+	id        []byte
+	key       ed25519.PrivateKey
+	flags     byte
+	signCount uint32
+}
+
+func newTestAuthenticator(t *testing.T, flags byte) *testAuthenticator {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testAuthenticator{id: []byte("test-credential"), key: key, flags: flags}
+}
+
+// credential returns the stored credential for username, with the public key COSE encoded
+func (a *testAuthenticator) credential(username string) *webauthn.Credential {
+	cose := []byte{0xa4, 0x01, 0x01, 0x03, 0x27, 0x20, 0x06, 0x21, 0x58, 0x20}
+	cose = append(cose, a.key.Public().(ed25519.PublicKey)...)
+	return &webauthn.Credential{ID: a.id, Username: username, PublicKey: cose, Created: time.Now()}
+}
+
+// assert returns a signed response to challenge
+func (a *testAuthenticator) assert(challenge string) *webauthn.AssertionResponse {
+	a.signCount++
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+	authData := append(rpIDHash[:], a.flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(authData[len(authData)-4:], a.signCount)
+
+	clientData := []byte(`{"type":"webauthn.get","challenge":"` + challenge + `","origin":"https://example.com"}`)
+	clientDataHash := sha256.Sum256(clientData)
+
+	enc := base64.RawURLEncoding.EncodeToString
+	resp := &webauthn.AssertionResponse{ID: enc(a.id), Type: "public-key"}
+	resp.Response.ClientDataJSON = enc(clientData)
+	resp.Response.AuthenticatorData = enc(authData)
+	resp.Response.Signature = enc(ed25519.Sign(a.key, append(authData, clientDataHash[:]...)))
+	return resp
+}
+
+func TestWebAuthnLoginMFA(t *testing.T) {
+	user := &auth.User{Username: "staff", Groups: []string{"office"}, Permissions: []auth.GradeRange{auth.AllGrades}}
+
+	for _, test := range []struct {
+		name      string
+		groups    []string
+		verify    bool
+		flags     byte
+		challenge bool
+	}{
+		{"MFA not required", nil, false, 0x01, false},
+		{"MFA required", []string{"office"}, false, 0x01, true},
+		{"MFA required without user verification required", []string{"office"}, false, 0x05, true},
+		{"MFA required with user verification", []string{"office"}, true, 0x05, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a := newTestAuthenticator(t, test.flags)
+			store := webauthnfile.New(filepath.Join(dir, "webauthn.json"))
+			if err := store.Add(a.credential(user.Username)); err != nil {
+				t.Fatal(err)
+			}
+			w := webauthn.New(&webauthn.Config{
+				RPID:                    "example.com",
+				Origins:                 []string{"https://example.com"},
+				RequireUserVerification: test.verify,
+			}, store)
+			lookup := newTestAuth(user)
+			s := newTestServer(t, newTestDB(), lookup, WithWebAuthn(w, lookup),
+				WithMFA(mfafile.New(filepath.Join(dir, "mfa.json"), testTokenKey), "User Browser", test.groups))
+			h := s.Router()
+
+			var begin struct {
+				PublicKey struct {
+					Challenge string `json:"challenge"`
+				} `json:"publicKey"`
+			}
+			rec := do(t, h, "POST", "/auth/webauthn/begin", "", map[string]string{"username": user.Username})
+			if err := json.NewDecoder(rec.Body).Decode(&begin); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("unable to begin login: %d, %v", rec.Code, err)
+			}
+
+			var resp struct {
+				SessionID   string `json:"session_id"`
+				MFARequired bool   `json:"mfa_required"`
+				MFAToken    string `json:"mfa_token"`
+			}
+			rec = do(t, h, "POST", "/auth/webauthn/finish", "", a.assert(begin.PublicKey.Challenge))
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+				t.Fatalf("unable to finish login: %d, %v", rec.Code, err)
+			}
+
+			if test.challenge && (resp.SessionID != "" || !resp.MFARequired || resp.MFAToken == "") {
+				t.Errorf("expected MFA challenge, got %+v", resp)
+			} else if !test.challenge && (resp.SessionID == "" || resp.MFARequired) {
+				t.Errorf("expected session, got %+v", resp)
+			}
+		})
+	}
+}
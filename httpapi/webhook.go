@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+// webhookEvents maps actions to the events they fire when they succeed.
+// If self is true the event targets the acting user, otherwise the action's target
+var webhookEvents = map[string]struct {
+	typ  string
+	self bool
+}{
+	"ResetPassword":            {webhook.EventPasswordReset, false},
+	"ApproveReset":             {webhook.EventPasswordReset, false},
+	"CreateAPIKey":             {webhook.EventAPIKeyCreated, false},
+	"RevokeAPIKey":             {webhook.EventAPIKeyRevoked, false},
+	"MFAConfirm":               {webhook.EventMFAEnrolled, true},
+	"MFADisable":               {webhook.EventMFADisabled, true},
+	"MFARecoveryCodes":         {webhook.EventMFARecoveryCodes, true},
+	"WebAuthnRegisterFinish":   {webhook.EventWebAuthnRegistered, true},
+	"WebAuthnDeleteCredential": {webhook.EventWebAuthnDeleted, true},
+	"DeleteSession":            {webhook.EventSessionDeleted, false},
+}
+
+// notify queues a webhook event for the logged action if it's a successful account change
+func (s *Server) notify(l *logData) {
+	e, ok := webhookEvents[l.Action]
+	if !ok || l.Result != http.StatusOK {
+		return
+	}
+
+	target := l.ActionID
+	if e.self {
+		target = l.User
+	}
+
+	err := s.webhooks.Notify(&webhook.Event{
+		Type:   e.typ,
+		Time:   l.Time,
+		Actor:  l.User,
+		APIKey: l.APIKey,
+		Target: target,
+	})
+	if err != nil {
+		log.Println("Unable to queue webhook:", err)
+	}
+}
+
+func (s *Server) listWebhookDeliveries(r *http.Request) (int, interface{}) {
+	status := webhook.Status(r.FormValue("status"))
+	switch status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed:
+	default:
+		return http.StatusBadRequest, fmt.Errorf("Invalid status: %s", status)
+	}
+
+	limit := 100
+	if str := r.FormValue("limit"); str != "" {
+		var err error
+		if limit, err = strconv.Atoi(str); err != nil || limit < 1 {
+			return http.StatusBadRequest, fmt.Errorf("Invalid limit: %s", str)
+		}
+	}
+
+	deliveries, err := s.webhooks.Store().List(status, limit)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("Unable to list webhook deliveries: %v", err)
+	}
+
+	if deliveries == nil {
+		deliveries = []*webhook.Delivery{}
+	}
+
+	return http.StatusOK, deliveries
+}
@@ -0,0 +1,47 @@
+package logsink
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Buffered is a Sink that queues entries and writes them to another Sink in the background,
+// so a slow destination never blocks the caller. Entries are dropped and counted if the queue is full
+type Buffered struct {
+	sink    Sink
+	queue   chan *Entry
+	dropped uint64
+}
+
+// NewBuffered returns a new *Buffered that queues up to size entries for sink
+func NewBuffered(sink Sink, size int) *Buffered {
+	b := &Buffered{sink: sink, queue: make(chan *Entry, size)}
+	go b.run()
+	return b
+}
+
+func (b *Buffered) run() {
+	for e := range b.queue {
+		if err := b.sink.Write(e); err != nil {
+			log.Println("Unable to write log entry:", err)
+		}
+	}
+}
+
+// Write queues the entry, or drops it if the queue is full. Write never blocks and always returns nil
+func (b *Buffered) Write(e *Entry) error {
+	select {
+	case b.queue <- e:
+		return nil
+	default:
+		if n := atomic.AddUint64(&b.dropped, 1); n == 1 || n%1000 == 0 {
+			log.Printf("Log buffer full; %d entries dropped\n", n)
+		}
+		return nil
+	}
+}
+
+// Dropped returns the number of entries dropped because the queue was full
+func (b *Buffered) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
@@ -0,0 +1,74 @@
+package logsink
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// stalledSink signals each Write on started, then blocks until unblock is closed
+type stalledSink struct {
+	started chan *Entry
+	unblock chan struct{}
+}
+
+func (s *stalledSink) Write(e *Entry) error {
+	s.started <- e
+	<-s.unblock
+	return nil
+}
+
+func TestBufferedDropped(t *testing.T) {
+	sink := &stalledSink{started: make(chan *Entry, 10), unblock: make(chan struct{})}
+	b := NewBuffered(sink, 2)
+
+	entries := make([]*Entry, 5)
+	for i := range entries {
+		entries[i] = &Entry{ActionID: strconv.Itoa(i)}
+	}
+
+	// the first entry is taken from the queue and stalls the sink
+	b.Write(entries[0])
+	select {
+	case <-sink.started:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for write")
+	}
+
+	// the next two fill the queue, and the rest are dropped without blocking
+	done := make(chan struct{})
+	go func() {
+		for _, e := range entries[1:] {
+			if err := b.Write(e); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write blocked on a stalled sink")
+	}
+	if n := b.Dropped(); n != 2 {
+		t.Errorf("expected 2 dropped entries, got %d", n)
+	}
+
+	// queued entries are written in order once the sink recovers
+	close(sink.unblock)
+	for _, expected := range entries[1:3] {
+		select {
+		case e := <-sink.started:
+			if e != expected {
+				t.Errorf("expected entry %s, got %s", expected.ActionID, e.ActionID)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for write")
+		}
+	}
+	select {
+	case e := <-sink.started:
+		t.Errorf("dropped entry %s was written", e.ActionID)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -0,0 +1,96 @@
+package logsink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSONFormatter formats entries as JSON objects
+type JSONFormatter struct{}
+
+// Format formats the entry as a JSON object
+func (JSONFormatter) Format(e *Entry) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// CEFFormatter formats entries in ArcSight Common Event Format
+type CEFFormatter struct {
+	Vendor  string
+	Product string
+	Version string
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// severity maps an HTTP status to a CEF severity (0-10)
+func severity(status int) int {
+	switch {
+	case status >= 500:
+		return 7
+	case status == 401 || status == 403:
+		return 5
+	case status >= 400:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// Format formats the entry as a CEF message
+func (f CEFFormatter) Format(e *Entry) ([]byte, error) {
+	outcome := "success"
+	if e.Result >= 400 {
+		outcome = "failure"
+	}
+
+	ext := []string{
+		"rt=" + fmt.Sprintf("%d", e.Time.UnixNano()/1e6),
+		"outcome=" + outcome,
+		"cn1Label=httpStatus",
+		"cn1=" + fmt.Sprintf("%d", e.Result),
+		"cn2Label=durationMs",
+		"cn2=" + fmt.Sprintf("%d", e.Duration.Milliseconds()),
+	}
+
+	add := func(k, v string) {
+		if v != "" {
+			ext = append(ext, k+"="+cefExtensionEscaper.Replace(v))
+		}
+	}
+
+	add("suser", e.User)
+	add("duser", e.ActionID)
+	add("src", e.ClientIP)
+	add("requestClientApplication", e.UserAgent)
+	if e.APIKey != "" {
+		add("cs1Label", "apiKey")
+		add("cs1", e.APIKey)
+	}
+	if e.ApprovalID != "" {
+		add("cs2Label", "approvalId")
+		add("cs2", e.ApprovalID)
+	}
+	if e.RequestedBy != "" {
+		add("cs3Label", "requestedBy")
+		add("cs3", e.RequestedBy)
+	}
+	if e.Tenant != "" {
+		add("cs4Label", "tenant")
+		add("cs4", e.Tenant)
+	}
+	add("msg", e.Error)
+
+	return []byte(fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(f.Vendor),
+		cefHeaderEscaper.Replace(f.Product),
+		cefHeaderEscaper.Replace(f.Version),
+		cefHeaderEscaper.Replace(e.Action),
+		cefHeaderEscaper.Replace(e.Action),
+		severity(e.Result),
+		strings.Join(ext, " "),
+	)), nil
+}
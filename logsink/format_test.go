@@ -0,0 +1,70 @@
+package logsink
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCEFFormatter(t *testing.T) {
+	f := CEFFormatter{Vendor: `Example|Co`, Product: `User\Browser`, Version: "3.0"}
+	e := &Entry{
+		Action:    "reset|password\nforged",
+		ActionID:  "jdoe12",
+		User:      "staff",
+		ClientIP:  "192.0.2.1",
+		UserAgent: `agent=1 \ x`,
+		Result:    403,
+		Error:     "line 1\r\nrt=0 forged=extension",
+		Time:      time.Unix(1614855967, 891000000),
+		Duration:  1500 * time.Millisecond,
+	}
+
+	buf, err := f.Format(e)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg := string(buf)
+
+	// header fields escape pipes and backslashes, and newlines are replaced
+	header := `CEF:0|Example\|Co|User\\Browser|3.0|reset\|password forged|reset\|password forged|5|`
+	if !strings.HasPrefix(msg, header) {
+		t.Fatalf("expected header %q, got %q", header, msg)
+	}
+
+	// extension values escape equals signs, backslashes, and newlines
+	ext := strings.TrimPrefix(msg, header)
+	for _, field := range []string{
+		"rt=1614855967891",
+		"outcome=failure",
+		"cn1=403",
+		"cn2=1500",
+		"suser=staff",
+		"duser=jdoe12",
+		"src=192.0.2.1",
+		`requestClientApplication=agent\=1 \\ x`,
+		`msg=line 1\r\nrt\=0 forged\=extension`,
+	} {
+		if !strings.Contains(ext, field) {
+			t.Errorf("expected extension %q in %q", field, ext)
+		}
+	}
+	if strings.ContainsAny(msg, "\r\n") {
+		t.Errorf("expected a single line, got %q", msg)
+	}
+	if strings.Contains(ext, "cs1") {
+		t.Errorf("expected empty fields to be omitted, got %q", ext)
+	}
+}
+
+func TestCEFSeverity(t *testing.T) {
+	for status, expected := range map[int]string{200: "|1|", 404: "|3|", 401: "|5|", 403: "|5|", 502: "|7|"} {
+		buf, err := CEFFormatter{}.Format(&Entry{Action: "list", Result: status})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(buf), "|list"+expected) {
+			t.Errorf("%d: expected severity %s, got %q", status, expected, buf)
+		}
+	}
+}
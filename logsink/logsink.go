@@ -0,0 +1,61 @@
+package logsink
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Entry represents a single logged action
+type Entry struct {
+	Action    string        `json:"action"`
+	ActionID  string        `json:"action_id,omitempty"`
+	User      string        `json:"user,omitempty"`
+	APIKey    string        `json:"api_key,omitempty"`
+	ClientIP  string        `json:"client_ip"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	Result    int           `json:"result"`
+	Error     string        `json:"error,omitempty"`
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"duration"`
+
+	// ApprovalID and RequestedBy are set for resets that required approval
+	ApprovalID  string `json:"approval_id,omitempty"`
+	RequestedBy string `json:"requested_by,omitempty"`
+
+	// Tenant is set when the server hosts several tenants
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Sink is a destination for log entries
+type Sink interface {
+	// Write outputs the entry or returns an error if one occurred
+	Write(e *Entry) error
+}
+
+// Formatter formats an entry as a single message without a trailing newline
+type Formatter interface {
+	Format(e *Entry) ([]byte, error)
+}
+
+type multi []Sink
+
+// Multi returns a Sink that writes every entry to all of the given sinks
+func Multi(sinks ...Sink) Sink {
+	return multi(sinks)
+}
+
+func (m multi) Write(e *Entry) error {
+	var errs []string
+	for _, s := range m {
+		if err := s.Write(e); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
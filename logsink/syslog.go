@@ -0,0 +1,135 @@
+package logsink
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// syslog facilities and severities (RFC 5424)
+const (
+	FacilityAuthPriv = 10
+	FacilityLocal0   = 16
+
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// Syslog is a Sink that sends formatted entries as RFC 5424 syslog messages over UDP, TCP or a unix socket
+type Syslog struct {
+	network   string
+	addr      string
+	appName   string
+	facility  int
+	formatter Formatter
+	hostname  string
+
+	mu   *sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog returns a new *Syslog that sends messages to addr on network ("udp", "tcp", "unix", or "unixgram"),
+// with the given app name, facility, and Formatter for the message body. The connection is opened on first use
+func NewSyslog(network, addr, appName string, facility int, formatter Formatter) *Syslog {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &Syslog{
+		network:   network,
+		addr:      addr,
+		appName:   appName,
+		facility:  facility,
+		formatter: formatter,
+		hostname:  hostname,
+		mu:        new(sync.Mutex),
+	}
+}
+
+func (s *Syslog) dial() error {
+	conn, err := net.DialTimeout(s.network, s.addr, 5*time.Second)
+	if err != nil && s.network == "unix" {
+		// /dev/log is usually a datagram socket
+		conn, err = net.DialTimeout("unixgram", s.addr, 5*time.Second)
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to connect to syslog %s://%s: %v", s.network, s.addr, err)
+	}
+
+	s.conn = conn
+	return nil
+}
+
+func syslogSeverity(status int) int {
+	switch {
+	case status >= 500:
+		return severityError
+	case status >= 400:
+		return severityWarning
+	default:
+		return severityInfo
+	}
+}
+
+// message returns the RFC 5424 message for the entry, framed for the transport
+func (s *Syslog) message(e *Entry) ([]byte, error) {
+	body, err := s.formatter.Format(e)
+	if err != nil {
+		return nil, err
+	}
+
+	msgID := e.Action
+	if msgID == "" {
+		msgID = "-"
+	}
+
+	msg := []byte(fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		s.facility*8+syslogSeverity(e.Result),
+		e.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+		s.appName,
+		os.Getpid(),
+		msgID,
+	))
+	msg = append(msg, body...)
+
+	if s.network == "tcp" {
+		// octet counting framing (RFC 6587)
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	return msg, nil
+}
+
+// Write sends the entry or returns an error if one occurred. The connection is reopened once if sending fails
+func (s *Syslog) Write(e *Entry) error {
+	msg, err := s.message(e)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.dial(); err != nil {
+				return err
+			}
+		}
+
+		s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	return fmt.Errorf("Unable to write to syslog %s://%s: %v", s.network, s.addr, err)
+}
@@ -0,0 +1,119 @@
+package logsink
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readFrame reads an octet-counted message (RFC 6587) from r
+func readFrame(r *bufio.Reader) (string, error) {
+	prefix, err := r.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+	if err != nil {
+		return "", fmt.Errorf("invalid frame length %q: %v", prefix, err)
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func TestSyslogTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	frames := make(chan string, 2)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			frame, err := readFrame(r)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- frame
+		}
+	}()
+
+	s := NewSyslog("tcp", l.Addr().String(), "userbrowser", FacilityAuthPriv, JSONFormatter{})
+	hostname, _ := os.Hostname()
+	now := time.Date(2021, 3, 4, 5, 6, 7, 891234000, time.FixedZone("CST", -6*60*60))
+
+	for _, test := range []struct {
+		entry  *Entry
+		header string
+	}{
+		{
+			&Entry{Action: "reset", Result: 200, Time: now},
+			fmt.Sprintf("<86>1 2021-03-04T11:06:07.891234Z %s userbrowser %d reset - ", hostname, os.Getpid()),
+		},
+		{
+			// messages containing newlines are framed by length, so a multi-line message stays one frame
+			&Entry{Result: 500, Error: "line 1\nline 2", Time: now},
+			fmt.Sprintf("<83>1 2021-03-04T11:06:07.891234Z %s userbrowser %d - - ", hostname, os.Getpid()),
+		},
+	} {
+		if err = s.Write(test.entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var frame string
+		select {
+		case frame = <-frames:
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for message")
+		}
+
+		if !strings.HasPrefix(frame, test.header) {
+			t.Errorf("expected header %q, got %q", test.header, frame)
+			continue
+		}
+		body, _ := JSONFormatter{}.Format(test.entry)
+		if strings.TrimPrefix(frame, test.header) != string(body) {
+			t.Errorf("expected body %s, got %q", body, strings.TrimPrefix(frame, test.header))
+		}
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := NewSyslog("udp", conn.LocalAddr().String(), "userbrowser", FacilityLocal0, JSONFormatter{})
+	if err = s.Write(&Entry{Action: "login", Result: 401, Time: time.Now()}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// datagrams aren't framed
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4096)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unable to read message: %v", err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<132>1 ") || !strings.Contains(msg, " login - {") {
+		t.Errorf("unexpected message: %q", msg)
+	}
+}
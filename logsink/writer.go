@@ -0,0 +1,32 @@
+package logsink
+
+import (
+	"io"
+	"sync"
+)
+
+// Writer is a Sink that writes formatted entries, one per line, to an io.Writer
+type Writer struct {
+	w         io.Writer
+	formatter Formatter
+	mu        *sync.Mutex
+}
+
+// NewWriter returns a new *Writer that writes to w with the given Formatter
+func NewWriter(w io.Writer, formatter Formatter) *Writer {
+	return &Writer{w: w, formatter: formatter, mu: new(sync.Mutex)}
+}
+
+// Write outputs the entry or returns an error if one occurred
+func (w *Writer) Write(e *Entry) error {
+	buf, err := w.formatter.Format(e)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, err = w.w.Write(append(buf, '\n'))
+	return err
+}
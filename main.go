@@ -3,32 +3,69 @@ package main
 import (
 	"log"
 	"net/http"
-	"os"
-	"time"
 
-	adauth "github.com/korylprince/go-ad-auth/v3"
-	"github.com/korylprince/userbrowser-server/v3/auth/ad"
-	"github.com/korylprince/userbrowser-server/v3/db/ldap"
+	"github.com/korylprince/userbrowser-server/v3/config"
 	"github.com/korylprince/userbrowser-server/v3/httpapi"
-	"github.com/korylprince/userbrowser-server/v3/session/memory"
+	"github.com/korylprince/userbrowser-server/v3/metrics"
+	"github.com/korylprince/userbrowser-server/v3/tenant"
 )
 
 func main() {
-	authConfig := &adauth.Config{
-		Server:   config.LDAPServer,
-		Port:     config.LDAPPort,
-		BaseDN:   config.LDAPBaseDN,
-		Security: config.ldapSecurity,
+	conf, err := config.Load()
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	db := ldap.New(authConfig, config.LDAPBindUPN, config.LDAPBindPassword, config.SecureTokenKey, config.Debug)
-	auth := ad.New(authConfig, config.permissions)
-	sessionStore := memory.New(time.Minute * time.Duration(config.SessionExpiration))
+	logSink, err := conf.LogSink()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	httpapi.Debug = conf.Debug
+	httpapi.TrustProxy = conf.TrustProxy
+
+	var router http.Handler
+	if tenants := conf.TenantConfigs(); len(tenants) > 0 {
+		var ts []*tenant.Tenant
+		for _, t := range tenants {
+			ts = append(ts, &tenant.Tenant{Name: t.Name(), Hosts: t.Hosts, Prefix: t.PathPrefix, Handler: newServer(t, logSink).Router()})
+		}
+		if router, err = tenant.New(ts...); err != nil {
+			log.Fatalln(err)
+		}
+	} else {
+		router = newServer(conf, logSink).Router()
+	}
+
+	if conf.Metrics {
+		go func() {
+			log.Println("Serving metrics on:", conf.MetricsAddr)
+			log.Fatalln(http.ListenAndServe(conf.MetricsAddr, metricsHandler()))
+		}()
+	}
 
-	httpapi.Debug = config.Debug
-	s := httpapi.NewServer(db, auth, sessionStore, os.Stdout)
+	log.Println("Listening on:", conf.ListenAddr)
 
-	log.Println("Listening on:", config.ListenAddr)
+	log.Println(http.ListenAndServe(conf.ListenAddr, apiHandler(conf, router)))
+}
 
-	log.Println(http.ListenAndServe(config.ListenAddr, http.StripPrefix(config.Prefix, s.Router())))
+// metricsHandler returns the handler for MetricsAddr.
+// Metrics cover every tenant, so they aren't served on the tenants' listener
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	return mux
+}
+
+// apiHandler returns the handler for ListenAddr, serving router at conf.Prefix
+func apiHandler(conf *config.Config, router http.Handler) http.Handler {
+	handler := http.StripPrefix(conf.Prefix, router)
+	if conf.WebUI && conf.Prefix != "" {
+		// the web frontend uses relative URLs, so it must be served from Prefix/
+		mux := http.NewServeMux()
+		mux.Handle(conf.Prefix, http.RedirectHandler(conf.Prefix+"/", http.StatusMovedPermanently))
+		mux.Handle(conf.Prefix+"/", handler)
+		handler = mux
+	}
+	return handler
 }
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/config"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/httpapi"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
+	"github.com/korylprince/userbrowser-server/v3/session/memory"
+)
+
+type testDB struct{}
+
+func (testDB) Get(ctx context.Context, username string) (*db.User, error)         { return nil, nil }
+func (testDB) List(ctx context.Context) ([]*db.User, error)                       { return nil, nil }
+func (testDB) ResetPassword(ctx context.Context, username string) (string, error) { return "", nil }
+
+// testAuth rejects every login
+type testAuth struct{}
+
+func (testAuth) Authenticate(ctx context.Context, username, password string) (*auth.User, error) {
+	return nil, &auth.InvalidCredentialsError{Username: username}
+}
+
+type testSink struct{}
+
+func (testSink) Write(e *logsink.Entry) error { return nil }
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestListeners(t *testing.T) {
+	conf := &config.Config{Prefix: "/userbrowser", WebUI: true, Metrics: true}
+	s := httpapi.NewServer(testDB{}, testAuth{}, memory.New(time.Hour), testSink{},
+		httpapi.WithMetrics(), httpapi.WithWebUI(), httpapi.WithTenant("listeners"))
+	api := httptest.NewServer(apiHandler(conf, s.Router()))
+	defer api.Close()
+	metricsServer := httptest.NewServer(metricsHandler())
+	defer metricsServer.Close()
+
+	resp, err := http.Post(api.URL+"/userbrowser/api/"+httpapi.API+"/auth", "application/json",
+		strings.NewReader(`{"username": "staff", "password": "wrong"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	// metrics are served on MetricsAddr, including requests to the API listener
+	code, body := get(t, metricsServer.URL+"/metrics")
+	counter := `userbrowser_http_requests_total{tenant="listeners",action="Authenticate",code="401"} 1`
+	if code != http.StatusOK || !strings.Contains(body, counter) {
+		t.Errorf("expected %s from the metrics listener, got %d:\n%s", counter, code, body)
+	}
+
+	// the API listener never serves metrics
+	for _, path := range []string{"/metrics", "/userbrowser/metrics", "/userbrowser/api/" + httpapi.API + "/metrics"} {
+		code, body = get(t, api.URL+path)
+		if code == http.StatusOK || strings.Contains(body, "userbrowser_http_requests_total") {
+			t.Errorf("%s: expected metrics not to be served on the API listener, got %d", path, code)
+		}
+	}
+
+	// and the metrics listener serves nothing else
+	if code, _ = get(t, metricsServer.URL+"/userbrowser/api/"+httpapi.API+"/users"); code != http.StatusNotFound {
+		t.Errorf("expected %d from the metrics listener, got %d", http.StatusNotFound, code)
+	}
+}
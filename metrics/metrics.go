@@ -0,0 +1,338 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the default histogram buckets, in seconds
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// Registry is a set of metrics that can be exposed in the Prometheus text format
+type Registry struct {
+	mu         *sync.Mutex
+	collectors []collector
+}
+
+// NewRegistry returns a new, empty *Registry
+func NewRegistry() *Registry {
+	return &Registry{mu: new(sync.Mutex)}
+}
+
+// Default is the registry used by the package level constructors
+var Default = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, c)
+	r.mu.Unlock()
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	buf := bufio.NewWriter(w)
+
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	for _, c := range collectors {
+		c.write(buf)
+	}
+
+	buf.Flush()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	var pairs []string
+	for i, n := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, n, labelEscaper.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], labelEscaper.Replace(extra[i+1])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeHeader(w *bufio.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// vec holds the children of a metric vector keyed by their label values
+type vec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu       *sync.Mutex
+	children map[string]interface{}
+	values   map[string][]string
+}
+
+func newVec(name, help string, labels []string) *vec {
+	return &vec{
+		name:     name,
+		help:     help,
+		labels:   labels,
+		mu:       new(sync.Mutex),
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+	}
+}
+
+// child returns the child for the given label values, creating it with create if necessary
+func (v *vec) child(values []string, create func() interface{}) interface{} {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c, ok := v.children[key]
+	if !ok {
+		c = create()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+
+	return c
+}
+
+// sorted returns the child keys in a stable order
+func (v *vec) sorted() []string {
+	keys := make([]string, 0, len(v.children))
+	for k := range v.children {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	mu    *sync.Mutex
+	value float64
+}
+
+// Inc increments the counter by 1
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by v, which must not be negative
+func (c *Counter) Add(v float64) {
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// CounterVec is a set of Counters partitioned by label values
+type CounterVec struct {
+	*vec
+}
+
+// NewCounterVec creates and registers a new *CounterVec with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(name, help, labels)}
+	r.register(c)
+	return c
+}
+
+// NewCounterVec creates a new *CounterVec in the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// With returns the Counter for the given label values, in the order the labels were given
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.child(values, func() interface{} { return &Counter{mu: new(sync.Mutex)} }).(*Counter)
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, k := range c.sorted() {
+		counter := c.children[k].(*Counter)
+		counter.mu.Lock()
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.values[k]), formatFloat(counter.value))
+		counter.mu.Unlock()
+	}
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	mu      *sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// Observe records a single observation
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// HistogramVec is a set of Histograms partitioned by label values
+type HistogramVec struct {
+	*vec
+	buckets []float64
+}
+
+// NewHistogramVec creates and registers a new *HistogramVec with the given buckets and label names.
+// If buckets is nil, DefaultBuckets is used
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{vec: newVec(name, help, labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+// NewHistogramVec creates a new *HistogramVec in the Default registry
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+// With returns the Histogram for the given label values, in the order the labels were given
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.child(values, func() interface{} {
+		return &Histogram{mu: new(sync.Mutex), buckets: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, k := range h.sorted() {
+		hist := h.children[k].(*Histogram)
+		values := h.values[k]
+
+		hist.mu.Lock()
+		for i, b := range hist.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(b)), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), hist.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), hist.count)
+		hist.mu.Unlock()
+	}
+}
+
+// GaugeFunc is a gauge whose value is computed when metrics are collected
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates and registers a new *GaugeFunc
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	r.register(g)
+	return g
+}
+
+// NewGaugeFunc creates a new *GaugeFunc in the Default registry
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	return Default.NewGaugeFunc(name, help, fn)
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// GaugeFuncVec is a set of gauges partitioned by label values, whose values are computed when metrics are collected
+type GaugeFuncVec struct {
+	*vec
+}
+
+// NewGaugeFuncVec creates and registers a new *GaugeFuncVec with the given label names
+func (r *Registry) NewGaugeFuncVec(name, help string, labels ...string) *GaugeFuncVec {
+	g := &GaugeFuncVec{newVec(name, help, labels)}
+	r.register(g)
+	return g
+}
+
+// NewGaugeFuncVec creates a new *GaugeFuncVec in the Default registry
+func NewGaugeFuncVec(name, help string, labels ...string) *GaugeFuncVec {
+	return Default.NewGaugeFuncVec(name, help, labels...)
+}
+
+type gaugeFunc struct {
+	mu *sync.Mutex
+	fn func() float64
+}
+
+// Func sets the function computing the gauge for the given label values, in the order the labels were given,
+// replacing any function set before
+func (g *GaugeFuncVec) Func(fn func() float64, values ...string) {
+	c := g.child(values, func() interface{} { return &gaugeFunc{mu: new(sync.Mutex)} }).(*gaugeFunc)
+	c.mu.Lock()
+	c.fn = fn
+	c.mu.Unlock()
+}
+
+func (g *GaugeFuncVec) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, k := range g.sorted() {
+		c := g.children[k].(*gaugeFunc)
+		c.mu.Lock()
+		fn := c.fn
+		c.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, g.values[k]), formatFloat(fn()))
+	}
+}
@@ -0,0 +1,129 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/korylprince/securetoken"
+	"github.com/korylprince/userbrowser-server/v3/mfa"
+)
+
+// Store represents a Store that persists enrollments to a JSON file.
+// Secrets are encrypted at rest with a SecureToken key
+type Store struct {
+	path string
+	key  []byte
+	mu   *sync.Mutex
+}
+
+// New returns a new *Store using the file at path and the given SecureToken key
+func New(path, key string) *Store {
+	return &Store{path: path, key: []byte(key), mu: new(sync.Mutex)}
+}
+
+func (s *Store) read() (map[string]*mfa.Enrollment, error) {
+	enrollments := make(map[string]*mfa.Enrollment)
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return enrollments, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&enrollments); err != nil {
+		return nil, fmt.Errorf("Unable to decode %s: %v", s.path, err)
+	}
+
+	return enrollments, nil
+}
+
+func (s *Store) write(enrollments map[string]*mfa.Enrollment) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(enrollments); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to encode enrollments: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// Get returns the enrollment for the given username or nil if it doesn't exist,
+// or an error if one occurred
+func (s *Store) Get(username string) (*mfa.Enrollment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollments, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	e, ok := enrollments[strings.ToLower(username)]
+	if !ok {
+		return nil, nil
+	}
+
+	secret, err := securetoken.DecryptToken([]byte(e.Secret), s.key, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to decrypt secret for %s: %v", username, err)
+	}
+	e.Secret = string(secret)
+
+	return e, nil
+}
+
+// Put creates or replaces the enrollment for e.Username or returns an error if one occurred
+func (s *Store) Put(e *mfa.Enrollment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollments, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	token, err := securetoken.NewToken([]byte(e.Secret), s.key)
+	if err != nil {
+		return fmt.Errorf("Unable to encrypt secret for %s: %v", e.Username, err)
+	}
+
+	encrypted := *e
+	encrypted.Secret = string(token)
+	enrollments[strings.ToLower(e.Username)] = &encrypted
+
+	return s.write(enrollments)
+}
+
+// Delete removes the enrollment for the given username or returns an error if one occurred
+func (s *Store) Delete(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enrollments, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	delete(enrollments, strings.ToLower(username))
+
+	return s.write(enrollments)
+}
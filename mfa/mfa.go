@@ -0,0 +1,94 @@
+package mfa
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const (
+	// maxFailures is the number of invalid codes in a row that locks an enrollment
+	maxFailures = 5
+	// lockout is how long an enrollment is locked after too many invalid codes
+	lockout = 15 * time.Minute
+)
+
+// Enrollment represents a user's TOTP enrollment
+type Enrollment struct {
+	Username string
+	Secret   string
+	// Confirmed is true once the user has proven they can generate codes for Secret
+	Confirmed bool
+	// LastCounter is the last accepted time step, used to prevent code reuse
+	LastCounter int64
+	// RecoveryCodes are the SHA-256 hashes of unused recovery codes
+	RecoveryCodes []string
+	Created       time.Time
+	// Failures is the number of invalid codes since the last valid one
+	Failures int
+	// LockedUntil is the time codes are accepted again after too many invalid codes
+	LockedUntil time.Time
+}
+
+// Locked returns true if codes aren't accepted at time t because of too many invalid codes
+func (e *Enrollment) Locked(t time.Time) bool {
+	return t.Before(e.LockedUntil)
+}
+
+// Verify returns true if code is a valid TOTP code at time t or an unused recovery code. Codes are rejected while the
+// Enrollment is locked, and it's locked after too many invalid codes in a row. The Enrollment is modified to prevent
+// the code from being used again and to record failures, so it should be saved after every Verify
+func (e *Enrollment) Verify(code string, t time.Time) bool {
+	if e.Locked(t) {
+		return false
+	}
+
+	if e.verify(strings.TrimSpace(code), t) {
+		e.Failures = 0
+		return true
+	}
+
+	e.Failures++
+	if e.Failures >= maxFailures {
+		e.Failures = 0
+		e.LockedUntil = t.Add(lockout)
+	}
+
+	return false
+}
+
+func (e *Enrollment) verify(code string, t time.Time) bool {
+	if counter, ok := Validate(e.Secret, code, t, e.LastCounter); ok {
+		e.LastCounter = counter
+		return true
+	}
+
+	hash := hashRecoveryCode(code)
+	for i, c := range e.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(c), []byte(hash)) == 1 {
+			e.RecoveryCodes = append(e.RecoveryCodes[:i:i], e.RecoveryCodes[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// Store is an enrollment storage mechanism
+type Store interface {
+	// Get returns the enrollment for the given username or nil if it doesn't exist,
+	// or an error if one occurred
+	Get(username string) (*Enrollment, error)
+	// Put creates or replaces the enrollment for e.Username or returns an error if one occurred
+	Put(e *Enrollment) error
+	// Delete removes the enrollment for the given username or returns an error if one occurred
+	Delete(username string) error
+}
@@ -0,0 +1,115 @@
+package mfa
+
+import (
+	"testing"
+	"time"
+)
+
+func newEnrollment(t *testing.T) (*Enrollment, []string) {
+	t.Helper()
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Enrollment{Username: "user", Secret: secret, Confirmed: true, RecoveryCodes: hashes}, codes
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	e, _ := newEnrollment(t)
+	now := time.Now()
+
+	code, err := Code(e.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !e.Verify(code, now) {
+		t.Fatal("valid code rejected")
+	}
+	if e.Verify(code, now) {
+		t.Error("reused code accepted")
+	}
+
+	// a code from an earlier time step in the skew window is also rejected once a later one was used
+	earlier, err := Code(e.Secret, now.Add(-period*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Verify(earlier, now) {
+		t.Error("code older than the last used time step accepted")
+	}
+}
+
+func TestVerifyRecoveryCode(t *testing.T) {
+	e, codes := newEnrollment(t)
+	now := time.Now()
+
+	if !e.Verify(codes[3], now) {
+		t.Fatal("valid recovery code rejected")
+	}
+	if len(e.RecoveryCodes) != recoveryCodeCount-1 {
+		t.Errorf("expected %d recovery codes, got %d", recoveryCodeCount-1, len(e.RecoveryCodes))
+	}
+	if e.Verify(codes[3], now) {
+		t.Error("used recovery code accepted")
+	}
+	if !e.Verify(codes[4], now) {
+		t.Error("unused recovery code rejected")
+	}
+}
+
+func TestVerifyLockout(t *testing.T) {
+	e, codes := newEnrollment(t)
+	now := time.Now()
+
+	for i := 0; i < maxFailures; i++ {
+		if e.Locked(now) {
+			t.Fatalf("locked after %d failures", i)
+		}
+		e.Verify("invalid", now)
+	}
+
+	if !e.Locked(now) {
+		t.Fatal("not locked after too many failures")
+	}
+
+	code, err := Code(e.Secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Verify(code, now) || e.Verify(codes[0], now) {
+		t.Error("code accepted while locked")
+	}
+	if len(e.RecoveryCodes) != recoveryCodeCount {
+		t.Error("recovery code consumed while locked")
+	}
+
+	later := now.Add(lockout)
+	code, err = Code(e.Secret, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !e.Verify(code, later) {
+		t.Error("valid code rejected after lockout expired")
+	}
+}
+
+func TestVerifyResetsFailures(t *testing.T) {
+	e, codes := newEnrollment(t)
+	now := time.Now()
+
+	for i := 0; i < maxFailures-1; i++ {
+		e.Verify("invalid", now)
+	}
+	if !e.Verify(codes[0], now) {
+		t.Fatal("valid recovery code rejected")
+	}
+	e.Verify("invalid", now)
+	if e.Locked(now) {
+		t.Error("failures before a valid code counted towards lockout")
+	}
+}
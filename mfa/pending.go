@@ -0,0 +1,91 @@
+package mfa
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
+
+// maxAttempts is the number of invalid codes allowed before a pending login is discarded
+const maxAttempts = 5
+
+type pendingLogin struct {
+	user     *auth.User
+	attempts int
+	expires  time.Time
+}
+
+// Pending stores users that have passed the first authentication factor and still need to provide a code
+type Pending struct {
+	store    map[string]*pendingLogin
+	duration time.Duration
+	mu       *sync.Mutex
+}
+
+// NewPending returns a new *Pending with the given expiration duration
+func NewPending(duration time.Duration) *Pending {
+	return &Pending{
+		store:    make(map[string]*pendingLogin),
+		duration: duration,
+		mu:       new(sync.Mutex),
+	}
+}
+
+// Create returns a new token for the given user
+func (p *Pending) Create(user *auth.User) (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for token, l := range p.store {
+		if l.expires.Before(now) {
+			delete(p.store, token)
+		}
+	}
+
+	p.store[id.String()] = &pendingLogin{user: user, expires: now.Add(p.duration)}
+
+	return id.String(), nil
+}
+
+// Get returns the user for the given token or nil if it doesn't exist or has expired
+func (p *Pending) Get(token string) *auth.User {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.store[token]; ok {
+		if l.expires.After(time.Now()) {
+			return l.user
+		}
+		delete(p.store, token)
+	}
+
+	return nil
+}
+
+// Fail records an invalid code for the given token, discarding it after too many attempts
+func (p *Pending) Fail(token string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.store[token]; ok {
+		l.attempts++
+		if l.attempts >= maxAttempts {
+			delete(p.store, token)
+		}
+	}
+}
+
+// Delete removes the given token
+func (p *Pending) Delete(token string) {
+	p.mu.Lock()
+	delete(p.store, token)
+	p.mu.Unlock()
+}
@@ -0,0 +1,116 @@
+package mfa
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"image/png"
+	"net/url"
+	"strings"
+	"time"
+
+	"rsc.io/qr"
+)
+
+const (
+	period = 30
+	digits = 6
+	// skew is the number of time steps before and after the current one that are accepted
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random base32 encoded TOTP secret
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// code returns the HOTP (RFC 4226) code for the given secret and counter
+func code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("Unable to decode secret: %v", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", digits, value%1000000), nil
+}
+
+// Code returns the TOTP (RFC 6238) code for the given secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	return code(secret, t.Unix()/period)
+}
+
+// Validate checks the code against the secret at time t, allowing for clock skew.
+// Codes at or before lastCounter are rejected to prevent reuse.
+// If the code is valid, its time step counter is returned
+func Validate(secret, c string, t time.Time, lastCounter int64) (counter int64, ok bool) {
+	if len(c) != digits {
+		return 0, false
+	}
+
+	now := t.Unix() / period
+	for i := now - skew; i <= now+skew; i++ {
+		if i <= lastCounter {
+			continue
+		}
+		expected, err := code(secret, i)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(c)) == 1 {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI returns the otpauth:// URI used by authenticator apps for the given secret
+func URI(issuer, username, secret string) string {
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprintf("%d", digits)},
+		"period":    {fmt.Sprintf("%d", period)},
+	}
+
+	label := url.PathEscape(issuer + ":" + username)
+
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, q.Encode())
+}
+
+// QRCode returns a PNG image of a QR code encoding the given URI
+func QRCode(uri string) ([]byte, error) {
+	c, err := qr.Encode(uri, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to encode QR code: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err = png.Encode(buf, c.Image()); err != nil {
+		return nil, fmt.Errorf("Unable to encode PNG: %v", err)
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+// Package command syncs passwords by running an external command
+package command
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Target runs a command for each sync. The username is appended to the command's arguments
+// and set in $USERBROWSER_SYNC_USERNAME; the password is written to the command's stdin followed by a newline.
+// A non-zero exit status is a failed sync
+type Target struct {
+	name    string
+	path    string
+	args    []string
+	timeout time.Duration
+}
+
+// New returns a new *Target with the given name that runs path with args, killing it after timeout
+func New(name, path string, args []string, timeout time.Duration) *Target {
+	return &Target{name: name, path: path, args: args, timeout: timeout}
+}
+
+// Name returns the target's name
+func (t *Target) Name() string {
+	return t.name
+}
+
+// Sync runs the command for username and password
+func (t *Target) Sync(username, password string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	args := append(append([]string{}, t.args...), username)
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	cmd.Env = append(os.Environ(), "USERBROWSER_SYNC_USERNAME="+username)
+	cmd.Stdin = strings.NewReader(password + "\n")
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("Command timed out after %v", t.timeout)
+		}
+		out := strings.TrimSpace(output.String())
+		if len(out) > 200 {
+			out = out[:200]
+		}
+		if out != "" {
+			return fmt.Errorf("Command failed: %v: %s", err, out)
+		}
+		return fmt.Errorf("Command failed: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// script writes a shell script to a temporary directory and returns its path
+func script(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sync.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSync(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	path := script(t, `read -r password
+printf '%s|%s|%s|%s' "$1" "$2" "$USERBROWSER_SYNC_USERNAME" "$password" > "`+out+`"
+`)
+
+	// the username is appended to the arguments and set in the environment, and the password is sent on stdin
+	target := New("test", path, []string{"--flag"}, time.Second)
+	if err := target.Sync("jdoe12", "pass word|1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "--flag|jdoe12|jdoe12|pass word|1"; string(buf) != expected {
+		t.Errorf("expected %q, got %q", expected, buf)
+	}
+}
+
+func TestSyncFailed(t *testing.T) {
+	path := script(t, "echo 'user not found' >&2\nexit 3\n")
+	err := New("test", path, nil, time.Second).Sync("jdoe12", "password")
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "user not found") {
+		t.Errorf("expected error with exit status and output, got %v", err)
+	}
+
+	// long output is truncated
+	path = script(t, "printf '%0500d' 0\nexit 1\n")
+	err = New("test", path, nil, time.Second).Sync("jdoe12", "password")
+	if err == nil || strings.Count(err.Error(), "0") > 200 {
+		t.Errorf("expected truncated output, got %v", err)
+	}
+}
+
+func TestSyncTimeout(t *testing.T) {
+	path := script(t, "exec sleep 10\n")
+
+	start := time.Now()
+	err := New("test", path, nil, 50*time.Millisecond).Sync("jdoe12", "password")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("command wasn't killed, took %v", d)
+	}
+}
@@ -0,0 +1,70 @@
+// Package endpoint syncs passwords by sending them to an HTTP endpoint
+package endpoint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+// Target POSTs {"username": "...", "password": "..."} to a URL for each sync. A 2xx response is a successful sync.
+// If a secret is set, the request is signed the same way as webhooks (see webhook.Sign)
+type Target struct {
+	name   string
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// New returns a new *Target with the given name that sends passwords to url, signed with secret if it's not empty.
+// Credentials in url are sent with HTTP Basic authentication
+func New(name, url string, secret []byte, timeout time.Duration) *Target {
+	return &Target{name: name, url: url, secret: secret, client: &http.Client{Timeout: timeout}}
+}
+
+// Name returns the target's name
+func (t *Target) Name() string {
+	return t.name
+}
+
+// Sync sends username and password to the endpoint
+func (t *Target) Sync(username, password string) error {
+	type request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	body, err := json.Marshal(&request{Username: username, Password: password})
+	if err != nil {
+		return fmt.Errorf("Unable to encode request: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Unable to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "userbrowser-pwsync")
+	if len(t.secret) > 0 {
+		req.Header.Set(webhook.HeaderSignature, webhook.Sign(t.secret, time.Now(), body))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Unable to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response: %s", resp.Status)
+	}
+
+	return nil
+}
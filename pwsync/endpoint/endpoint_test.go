@@ -0,0 +1,87 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+func TestSync(t *testing.T) {
+	secret := []byte("secret")
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, _ := io.ReadAll(r.Body)
+
+		user, pass, _ := r.BasicAuth()
+		if user != "sync" || pass != "key" {
+			t.Errorf("unexpected basic auth: %q, %q", user, pass)
+		}
+		if err := webhook.Verify(secret, r.Header.Get(webhook.HeaderSignature), body, time.Minute); err != nil {
+			t.Errorf("invalid signature: %v", err)
+		}
+
+		var req struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err := json.Unmarshal(body, &req); err != nil || req.Username != "jdoe12" || req.Password != "password" {
+			t.Errorf("unexpected body: %s", body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	url := strings.Replace(srv.URL, "http://", "http://sync:key@", 1)
+	if err := New("test", url, secret, time.Second).Sync("jdoe12", "password"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request, got %d", requests)
+	}
+}
+
+func TestSyncUnsigned(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sig := r.Header.Get(webhook.HeaderSignature); sig != "" {
+			t.Errorf("expected no signature, got %q", sig)
+		}
+	}))
+	defer srv.Close()
+
+	if err := New("test", srv.URL, nil, time.Second).Sync("jdoe12", "password"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSyncFailed(t *testing.T) {
+	for _, code := range []int{http.StatusMultipleChoices, http.StatusNotFound, http.StatusInternalServerError} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+
+		err := New("test", srv.URL, nil, time.Second).Sync("jdoe12", "password")
+		if err == nil || !strings.Contains(err.Error(), http.StatusText(code)) {
+			t.Errorf("%d: expected error with status, got %v", code, err)
+		}
+		srv.Close()
+	}
+
+	// a slow endpoint times out
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	if err := New("test", srv.URL, nil, 50*time.Millisecond).Sync("jdoe12", "password"); err == nil {
+		t.Error("expected timeout")
+	}
+}
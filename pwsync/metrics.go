@@ -0,0 +1,11 @@
+package pwsync
+
+import "github.com/korylprince/userbrowser-server/v3/metrics"
+
+const (
+	resultSuccess = "success"
+	resultFailure = "failure"
+)
+
+var syncs = metrics.NewCounterVec("userbrowser_password_sync_total",
+	"Number of password sync attempts by target and result.", "target", "result")
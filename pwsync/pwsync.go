@@ -0,0 +1,171 @@
+// Package pwsync copies reset passwords to systems other than Active Directory
+package pwsync
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Target is a system passwords are synced to after a successful reset
+type Target interface {
+	// Name returns a short name for the target, shown in reset responses
+	Name() string
+	// Sync sets the password for username or returns an error if one occurred
+	Sync(username, password string) error
+}
+
+// Result is the outcome of syncing a password to a target
+type Result struct {
+	Target  string `json:"target"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	// Retrying is true if the sync failed and will be retried in the background
+	Retrying bool `json:"retrying,omitempty"`
+}
+
+type retry struct {
+	target   Target
+	username string
+	password string
+	attempts int
+	next     time.Time
+}
+
+// Syncer syncs passwords to targets, retrying failed syncs in the background.
+// Passwords waiting to be retried are only kept in memory and are lost if the process exits
+type Syncer struct {
+	targets     []Target
+	maxAttempts int
+
+	mu      *sync.Mutex
+	retries map[string]*retry
+}
+
+// New returns a new *Syncer. Failed syncs are attempted up to maxAttempts times in total;
+// Run must be called for retries to happen
+func New(targets []Target, maxAttempts int) *Syncer {
+	return &Syncer{targets: targets, maxAttempts: maxAttempts, mu: new(sync.Mutex), retries: make(map[string]*retry)}
+}
+
+// backoff returns how long to wait after the given number of failed attempts: 10 seconds doubling each attempt, up to 30 minutes
+func backoff(attempts int) time.Duration {
+	d := 10 * time.Second
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= 30*time.Minute {
+			return 30 * time.Minute
+		}
+	}
+	return d
+}
+
+func retryKey(t Target, username string) string {
+	return t.Name() + "\x00" + username
+}
+
+func (s *Syncer) sync(t Target, username, password string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Sync panicked: %v", r)
+		}
+		result := resultSuccess
+		if err != nil {
+			result = resultFailure
+		}
+		syncs.With(t.Name(), result).Inc()
+	}()
+
+	return t.Sync(username, password)
+}
+
+// Sync syncs password to every target concurrently and returns the result for each target, in order.
+// A newer password for the same user replaces any pending retry
+func (s *Syncer) Sync(username, password string) []*Result {
+	results := make([]*Result, len(s.targets))
+
+	var wg sync.WaitGroup
+	for i, t := range s.targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+
+			key := retryKey(t, username)
+			r := &Result{Target: t.Name(), Success: true}
+
+			err := s.sync(t, username, password)
+
+			s.mu.Lock()
+			delete(s.retries, key)
+			if err != nil {
+				r.Success = false
+				r.Error = err.Error()
+				if s.maxAttempts > 1 {
+					r.Retrying = true
+					s.retries[key] = &retry{target: t, username: username, password: password, attempts: 1, next: time.Now().Add(backoff(1))}
+				}
+			}
+			s.mu.Unlock()
+
+			results[i] = r
+		}(i, t)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// retry attempts every due retry
+func (s *Syncer) retry() {
+	now := time.Now()
+
+	s.mu.Lock()
+	var due []*retry
+	for _, r := range s.retries {
+		if !r.next.After(now) {
+			due = append(due, r)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, r := range due {
+		err := s.sync(r.target, r.username, r.password)
+
+		s.mu.Lock()
+		key := retryKey(r.target, r.username)
+		// skip if a newer reset replaced this retry
+		if s.retries[key] != r {
+			s.mu.Unlock()
+			continue
+		}
+
+		r.attempts++
+		switch {
+		case err == nil:
+			delete(s.retries, key)
+			log.Printf("Synced password for %s to %s after %d attempts\n", r.username, r.target.Name(), r.attempts)
+		case r.attempts >= s.maxAttempts:
+			delete(s.retries, key)
+			log.Printf("Unable to sync password for %s to %s after %d attempts: %v\n", r.username, r.target.Name(), r.attempts, err)
+		default:
+			r.next = time.Now().Add(backoff(r.attempts))
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Run retries failed syncs until the process exits, checking for due retries every interval
+func (s *Syncer) Run(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		s.retry()
+	}
+}
+
+// Pending returns the number of syncs waiting to be retried
+func (s *Syncer) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.retries)
+}
@@ -0,0 +1,170 @@
+package pwsync
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTarget fails with the next error in errs, or succeeds once they're used up, and records each sync
+type testTarget struct {
+	name string
+
+	mu    *sync.Mutex
+	errs  []error
+	syncs []string
+}
+
+func newTestTarget(name string, errs ...error) *testTarget {
+	return &testTarget{name: name, mu: new(sync.Mutex), errs: errs}
+}
+
+func (t *testTarget) Name() string {
+	return t.name
+}
+
+func (t *testTarget) Sync(username, password string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.syncs = append(t.syncs, username+":"+password)
+	if len(t.errs) == 0 {
+		return nil
+	}
+	err := t.errs[0]
+	t.errs = t.errs[1:]
+	return err
+}
+
+func (t *testTarget) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.syncs)
+}
+
+type panicTarget struct{}
+
+func (panicTarget) Name() string                         { return "panic" }
+func (panicTarget) Sync(username, password string) error { panic("nil map") }
+
+// due makes every pending retry due and returns their attempts
+func due(s *Syncer) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var attempts []int
+	for _, r := range s.retries {
+		r.next = time.Now()
+		attempts = append(attempts, r.attempts)
+	}
+	return attempts
+}
+
+func TestSync(t *testing.T) {
+	ok := newTestTarget("ok")
+	failing := newTestTarget("failing", errors.New("unavailable"))
+	s := New([]Target{ok, failing, panicTarget{}}, 3)
+
+	// results are returned in the order of the targets, and failures are retried
+	results := s.Sync("jdoe12", "password")
+	expected := []Result{
+		{Target: "ok", Success: true},
+		{Target: "failing", Error: "unavailable", Retrying: true},
+		{Target: "panic", Error: "Sync panicked: nil map", Retrying: true},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, r := range results {
+		if *r != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], *r)
+		}
+	}
+	if n := s.Pending(); n != 2 {
+		t.Errorf("expected 2 pending retries, got %d", n)
+	}
+
+	// a newer password replaces the pending retry
+	s = New([]Target{newTestTarget("failing", errors.New("unavailable"))}, 3)
+	s.Sync("jdoe12", "old")
+	if results = s.Sync("jdoe12", "new"); !results[0].Success || results[0].Retrying {
+		t.Errorf("unexpected result: %+v", results[0])
+	}
+	if n := s.Pending(); n != 0 {
+		t.Errorf("expected no pending retries, got %d", n)
+	}
+
+	// without retries, a failure isn't retrying
+	s = New([]Target{newTestTarget("failing", errors.New("unavailable"))}, 1)
+	if results = s.Sync("jdoe12", "password"); results[0].Success || results[0].Retrying {
+		t.Errorf("unexpected result: %+v", results[0])
+	}
+	if n := s.Pending(); n != 0 {
+		t.Errorf("expected no pending retries, got %d", n)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, expected := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		8:  1280 * time.Second,
+		9:  30 * time.Minute,
+		50: 30 * time.Minute,
+	} {
+		if d := backoff(attempts); d != expected {
+			t.Errorf("%d attempts: expected %v, got %v", attempts, expected, d)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	failing := newTestTarget("failing", errors.New("unavailable"), errors.New("unavailable"), errors.New("unavailable"))
+	s := New([]Target{failing}, 3)
+	s.Sync("jdoe12", "password")
+
+	// retries wait for their backoff
+	s.retry()
+	if n := failing.count(); n != 1 {
+		t.Fatalf("expected retry to wait, got %d syncs", n)
+	}
+
+	if attempts := due(s); len(attempts) != 1 || attempts[0] != 1 {
+		t.Fatalf("unexpected retries: %v", attempts)
+	}
+	start := time.Now()
+	s.retry()
+	if n := failing.count(); n != 2 {
+		t.Fatalf("expected 2 syncs, got %d", n)
+	}
+	s.mu.Lock()
+	for _, r := range s.retries {
+		if r.attempts != 2 || r.next.Before(start.Add(backoff(2))) {
+			t.Errorf("unexpected retry: %d attempts, next in %v", r.attempts, r.next.Sub(start))
+		}
+	}
+	s.mu.Unlock()
+
+	// the sync is given up after maxAttempts
+	due(s)
+	s.retry()
+	if n := failing.count(); n != 3 {
+		t.Errorf("expected 3 syncs, got %d", n)
+	}
+	if n := s.Pending(); n != 0 {
+		t.Errorf("expected retry to be given up, got %d pending", n)
+	}
+
+	// a successful retry is removed
+	recovering := newTestTarget("recovering", errors.New("unavailable"))
+	s = New([]Target{recovering}, 3)
+	s.Sync("jdoe12", "password")
+	due(s)
+	s.retry()
+	if n := s.Pending(); n != 0 {
+		t.Errorf("expected no pending retries, got %d", n)
+	}
+	if recovering.syncs[1] != "jdoe12:password" {
+		t.Errorf("expected the same password to be retried, got %q", recovering.syncs[1])
+	}
+}
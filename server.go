@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	apikeyfile "github.com/korylprince/userbrowser-server/v3/apikey/file"
+	approvalfile "github.com/korylprince/userbrowser-server/v3/approval/file"
+	"github.com/korylprince/userbrowser-server/v3/audit"
+	auditfile "github.com/korylprince/userbrowser-server/v3/audit/file"
+	"github.com/korylprince/userbrowser-server/v3/auth/ad"
+	"github.com/korylprince/userbrowser-server/v3/auth/oidc"
+	"github.com/korylprince/userbrowser-server/v3/auth/webauthn"
+	webauthnfile "github.com/korylprince/userbrowser-server/v3/auth/webauthn/file"
+	"github.com/korylprince/userbrowser-server/v3/config"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/db/cache"
+	"github.com/korylprince/userbrowser-server/v3/db/ldap"
+	"github.com/korylprince/userbrowser-server/v3/httpapi"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
+	mfafile "github.com/korylprince/userbrowser-server/v3/mfa/file"
+	"github.com/korylprince/userbrowser-server/v3/pwsync"
+	"github.com/korylprince/userbrowser-server/v3/session/memory"
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+	webhookfile "github.com/korylprince/userbrowser-server/v3/webhook/file"
+)
+
+// newServer returns a new *httpapi.Server with its own directory, sessions and stores configured by conf
+func newServer(conf *config.Config, logSink logsink.Sink) *httpapi.Server {
+	pool := conf.DCPool()
+
+	ldapDB := ldap.New(pool, conf.LDAPBindUPN, conf.LDAPBindPassword, conf.SecureTokenKey, conf.Grades(), conf.Debug)
+	var userDB db.DB = ldapDB
+	if conf.LDAPIndex {
+		index := ldap.NewIndex(ldapDB, time.Duration(conf.LDAPIndexSeconds)*time.Second,
+			time.Duration(conf.LDAPIndexFullSeconds)*time.Second)
+		go index.Run()
+		userDB = index
+	} else if conf.CacheSeconds > 0 {
+		userDB = cache.New(ldapDB, time.Duration(conf.CacheSeconds)*time.Second, pool.Tenant())
+	}
+	auth := ad.New(pool, conf.LDAPBindUPN, conf.LDAPBindPassword, conf.PermissionsMap(), conf.AdminGroups)
+	sessionStore := memory.New(time.Minute * time.Duration(conf.SessionExpiration))
+
+	var opts []httpapi.Option
+
+	if conf.OIDCIssuer != "" {
+		opts = append(opts, httpapi.WithOIDC(oidc.New(&oidc.Config{
+			Issuer:        conf.OIDCIssuer,
+			ClientID:      conf.OIDCClientID,
+			ClientSecret:  conf.OIDCClientSecret,
+			RedirectURL:   conf.OIDCRedirectURL,
+			Scopes:        conf.OIDCScopes,
+			UsernameClaim: conf.OIDCUsernameClaim,
+			GroupsClaim:   conf.OIDCGroupsClaim,
+			AdminGroups:   conf.OIDCAdminGroups,
+		}, conf.OIDCPermissionsMap())))
+	}
+
+	if conf.MFAStorePath != "" {
+		opts = append(opts, httpapi.WithMFA(mfafile.New(conf.MFAStorePath, conf.SecureTokenKey), conf.MFAIssuer, conf.MFARequiredGroups))
+	}
+
+	if conf.WebAuthnStorePath != "" {
+		w := webauthn.New(&webauthn.Config{
+			RPID:                    conf.WebAuthnRPID,
+			RPName:                  conf.WebAuthnRPName,
+			Origins:                 conf.WebAuthnOrigins,
+			RequireUserVerification: conf.WebAuthnRequireUV,
+		}, webauthnfile.New(conf.WebAuthnStorePath))
+		opts = append(opts, httpapi.WithWebAuthn(w, auth))
+	}
+
+	if conf.APIKeyStorePath != "" {
+		keys := apikeyfile.New(conf.APIKeyStorePath)
+		go keys.Run(time.Minute)
+		opts = append(opts, httpapi.WithAPIKeys(keys))
+	}
+
+	if conf.AuditStorePath != "" {
+		auditStore := auditfile.New(conf.AuditStorePath, []byte(conf.AuditHMACKey))
+		go auditStore.Run(time.Second)
+		if conf.AuditRetentionDays > 0 {
+			go audit.Retain(auditStore, 24*time.Hour*time.Duration(conf.AuditRetentionDays), time.Hour)
+		}
+		opts = append(opts, httpapi.WithAudit(auditStore))
+	}
+
+	if conf.ApprovalStorePath != "" {
+		opts = append(opts, httpapi.WithApprovals(approvalfile.New(conf.ApprovalStorePath), conf.ApprovalPolicy(),
+			time.Hour*time.Duration(conf.ApprovalExpirationHours)))
+	}
+
+	if len(conf.PasswordSyncTargets) > 0 {
+		targets, err := conf.SyncTargets()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		syncer := pwsync.New(targets, conf.PasswordSyncMaxAttempts)
+		go syncer.Run(10 * time.Second)
+		opts = append(opts, httpapi.WithPasswordSync(syncer))
+	}
+
+	if len(conf.WebhookURLs) > 0 {
+		var endpoints []*webhook.Endpoint
+		for _, u := range conf.WebhookURLs {
+			endpoints = append(endpoints, &webhook.Endpoint{URL: strings.TrimSpace(u), Events: conf.WebhookEvents})
+		}
+		d := webhook.New(&webhook.Config{
+			Endpoints:   endpoints,
+			Secret:      []byte(conf.WebhookSecret),
+			MaxAttempts: conf.WebhookMaxAttempts,
+			Retention:   24 * time.Hour * time.Duration(conf.WebhookRetentionDays),
+		}, webhookfile.New(conf.WebhookStorePath))
+		go d.Run(time.Minute)
+		opts = append(opts, httpapi.WithWebhooks(d))
+	}
+
+	if conf.WebUI {
+		opts = append(opts, httpapi.WithWebUI())
+	}
+
+	if conf.Metrics {
+		opts = append(opts, httpapi.WithMetrics())
+	}
+
+	if conf.Name() != "" {
+		opts = append(opts, httpapi.WithTenant(conf.Name()))
+	}
+
+	opts = append(opts, httpapi.WithTimeouts(httpapi.Timeouts{
+		Authenticate:  time.Duration(conf.AuthTimeout) * time.Second,
+		Get:           time.Duration(conf.GetTimeout) * time.Second,
+		List:          time.Duration(conf.ListTimeout) * time.Second,
+		ResetPassword: time.Duration(conf.ResetTimeout) * time.Second,
+	}))
+
+	opts = append(opts, httpapi.WithHealthChecks(time.Duration(conf.HealthCacheSeconds)*time.Second,
+		&httpapi.HealthCheck{Name: "directory", Check: ldapDB.Check},
+		&httpapi.HealthCheck{Name: "session_store", Check: func(context.Context) error {
+			_, err := sessionStore.Count()
+			return err
+		}},
+		&httpapi.HealthCheck{Name: "secure_token", Check: func(context.Context) error {
+			return ldapDB.CheckKey(conf.SecureTokenSample)
+		}},
+	))
+
+	return httpapi.NewServer(userDB, auth, sessionStore, logSink, opts...)
+}
@@ -76,3 +76,42 @@ func (s *Store) Check(id string) (*session.Session, error) {
 	}
 	return nil, nil
 }
+
+// Count returns the number of unexpired sessions.
+// The returned error will always be nil.
+func (s *Store) Count() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	count := 0
+	for _, sess := range s.store {
+		if sess.expires.After(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// List returns all unexpired sessions.
+// The returned error will always be nil.
+func (s *Store) List() ([]*session.Active, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var sessions []*session.Active
+	for id, sess := range s.store {
+		if sess.expires.After(now) {
+			sessions = append(sessions, &session.Active{ID: id, Session: sess.session, Expires: sess.expires})
+		}
+	}
+	return sessions, nil
+}
+
+// Delete removes the session with the given id if it exists.
+// The returned error will always be nil.
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	delete(s.store, id)
+	s.mu.Unlock()
+	return nil
+}
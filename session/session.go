@@ -1,16 +1,36 @@
 package session
 
-import "github.com/korylprince/userbrowser-server/v3/auth"
+import (
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+)
 
 // Session represents an authenticated session
 type Session auth.User
 
+// Active is an unexpired session
+type Active struct {
+	ID      string
+	Session *Session
+	Expires time.Time
+}
+
 // Store is a session storage mechanism
 type Store interface {
 	// Create creates and returns a session id for the given session
 	// or an error if one occurred
 	Create(s *Session) (id string, err error)
-	// Create returns the session for the given id or nil if it doesn't exist
+	// Check returns the session for the given id or nil if it doesn't exist
 	// or an error if one occurred
 	Check(id string) (*Session, error)
+	// Count returns the number of active sessions
+	// or an error if one occurred
+	Count() (int, error)
+	// List returns all unexpired sessions
+	// or an error if one occurred
+	List() ([]*Active, error)
+	// Delete removes the session with the given id if it exists
+	// or returns an error if one occurred
+	Delete(id string) error
 }
@@ -0,0 +1,96 @@
+// Package tenant routes requests to the handlers of isolated tenants
+package tenant
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Tenant is a handler for an isolated set of resources, e.g. a single district
+type Tenant struct {
+	Name string
+	// Hosts are hostnames whose requests are routed to the tenant
+	Hosts []string
+	// Prefix is a path without a trailing slash, e.g. /north. Requests with the prefix are routed to the tenant
+	// with the prefix removed
+	Prefix  string
+	Handler http.Handler
+}
+
+// Router routes requests to tenants by hostname, then by path prefix
+type Router struct {
+	hosts    map[string]*Tenant
+	prefixes []*Tenant
+}
+
+// New returns a new *Router for the given tenants or an error if a tenant has no hosts or prefix,
+// or its hosts or prefix overlap another tenant's. Prefixes overlap if one is the other or one of its parent paths,
+// e.g. /north and /north/east, so every path matches at most one prefix
+func New(tenants ...*Tenant) (*Router, error) {
+	r := &Router{hosts: make(map[string]*Tenant)}
+
+	for _, t := range tenants {
+		if len(t.Hosts) == 0 && t.Prefix == "" {
+			return nil, fmt.Errorf("Tenant %s has no hosts or prefix", t.Name)
+		}
+
+		for _, host := range t.Hosts {
+			host = strings.ToLower(strings.TrimSpace(host))
+			if other, ok := r.hosts[host]; ok {
+				return nil, fmt.Errorf("Host %s is used by tenants %s and %s", host, other.Name, t.Name)
+			}
+			r.hosts[host] = t
+		}
+
+		if t.Prefix != "" {
+			if !strings.HasPrefix(t.Prefix, "/") || strings.HasSuffix(t.Prefix, "/") {
+				return nil, fmt.Errorf("Prefix for tenant %s must start and not end with /: %s", t.Name, t.Prefix)
+			}
+			for _, other := range r.prefixes {
+				if t.Prefix == other.Prefix || strings.HasPrefix(t.Prefix, other.Prefix+"/") ||
+					strings.HasPrefix(other.Prefix, t.Prefix+"/") {
+					return nil, fmt.Errorf("Prefix %s of tenant %s overlaps prefix %s of tenant %s",
+						t.Prefix, t.Name, other.Prefix, other.Name)
+				}
+			}
+			r.prefixes = append(r.prefixes, t)
+		}
+	}
+
+	return r, nil
+}
+
+// host returns the request's hostname without a port
+func host(r *http.Request) string {
+	h, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		h = r.Host
+	}
+	return strings.ToLower(h)
+}
+
+// ServeHTTP routes the request to the matching tenant or responds with 404 Not Found
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if t, ok := rt.hosts[host(r)]; ok {
+		t.Handler.ServeHTTP(w, r)
+		return
+	}
+
+	for _, t := range rt.prefixes {
+		if r.URL.Path == t.Prefix {
+			// relative, since an outer handler may have stripped its own prefix
+			w.Header().Set("Location", path.Base(t.Prefix)+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, t.Prefix+"/") {
+			http.StripPrefix(t.Prefix, t.Handler).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
@@ -0,0 +1,157 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/auth"
+	"github.com/korylprince/userbrowser-server/v3/db"
+	"github.com/korylprince/userbrowser-server/v3/httpapi"
+	"github.com/korylprince/userbrowser-server/v3/logsink"
+	"github.com/korylprince/userbrowser-server/v3/session/memory"
+)
+
+// named responds with its name and the request path
+type named string
+
+func (n named) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, string(n)+" "+r.URL.Path)
+}
+
+func serve(h http.Handler, host, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Host = host
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRouter(t *testing.T) {
+	router, err := New(
+		&Tenant{Name: "north", Hosts: []string{"North.example.com"}, Prefix: "/north", Handler: named("north")},
+		&Tenant{Name: "south", Prefix: "/south", Handler: named("south")},
+		&Tenant{Name: "northeast", Prefix: "/northeast", Handler: named("northeast")},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, test := range []struct {
+		host, path string
+		code       int
+		body       string
+	}{
+		// hosts are matched without the port, ignoring case, and the path is passed unchanged
+		{"north.example.com:8080", "/api/users", http.StatusOK, "north /api/users"},
+		{"NORTH.example.com", "/south/api/users", http.StatusOK, "north /south/api/users"},
+		// prefixes are matched at a path boundary and removed
+		{"example.com", "/north/api/users", http.StatusOK, "north /api/users"},
+		{"example.com", "/south/", http.StatusOK, "south /"},
+		{"example.com", "/northeast/api/users", http.StatusOK, "northeast /api/users"},
+		{"example.com", "/northwest/api/users", http.StatusNotFound, ""},
+		{"example.com", "/api/users", http.StatusNotFound, ""},
+		{"other.example.com", "/", http.StatusNotFound, ""},
+	} {
+		w := serve(router, test.host, test.path)
+		if w.Code != test.code || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%s%s: expected %d %q, got %d %q", test.host, test.path, test.code, test.body, w.Code, w.Body.String())
+		}
+	}
+
+	// a prefix without a trailing slash is redirected relative to the prefix
+	if w := serve(router, "example.com", "/south"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "south/" {
+		t.Errorf("expected redirect to south/, got %d %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestRouterInvalid(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		tenants []*Tenant
+	}{
+		{"no hosts or prefix", []*Tenant{{Name: "north"}}},
+		{"prefix without leading slash", []*Tenant{{Name: "north", Prefix: "north"}}},
+		{"prefix with trailing slash", []*Tenant{{Name: "north", Prefix: "/north/"}}},
+		{"same host", []*Tenant{
+			{Name: "north", Hosts: []string{"example.com"}},
+			{Name: "south", Hosts: []string{" EXAMPLE.com"}},
+		}},
+		{"same prefix", []*Tenant{{Name: "north", Prefix: "/north"}, {Name: "south", Prefix: "/north"}}},
+		{"nested prefix", []*Tenant{{Name: "north", Prefix: "/north"}, {Name: "northeast", Prefix: "/north/east"}}},
+		{"parent prefix", []*Tenant{{Name: "northeast", Prefix: "/north/east"}, {Name: "north", Prefix: "/north"}}},
+	} {
+		if _, err := New(test.tenants...); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+type testDB struct{}
+
+func (testDB) Get(ctx context.Context, username string) (*db.User, error) { return nil, nil }
+func (testDB) List(ctx context.Context) ([]*db.User, error)               { return nil, nil }
+func (testDB) ResetPassword(ctx context.Context, username string) (string, error) {
+	return "", &db.NotFoundError{Username: username}
+}
+
+// testAuth authenticates any username with the password "password"
+type testAuth struct{}
+
+func (testAuth) Authenticate(ctx context.Context, username, password string) (*auth.User, error) {
+	if password != "password" {
+		return nil, &auth.InvalidCredentialsError{Username: username}
+	}
+	return &auth.User{Username: username, Permissions: []auth.GradeRange{auth.AllGrades}}, nil
+}
+
+type testSink struct{}
+
+func (testSink) Write(e *logsink.Entry) error { return nil }
+
+func newTenant(name string) *Tenant {
+	s := httpapi.NewServer(testDB{}, testAuth{}, memory.New(time.Hour), testSink{}, httpapi.WithTenant(name))
+	return &Tenant{Name: name, Prefix: "/" + name, Handler: s.Router()}
+}
+
+func TestRouterSessions(t *testing.T) {
+	router, err := New(newTenant("north"), newTenant("south"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	do := func(method, path, sessionID string, body interface{}) *httptest.ResponseRecorder {
+		buf := new(bytes.Buffer)
+		if body != nil {
+			json.NewEncoder(buf).Encode(body)
+		}
+		r := httptest.NewRequest(method, path, buf)
+		r.Header.Set("Content-Type", "application/json")
+		if sessionID != "" {
+			r.Header.Set("Authorization", "Bearer "+sessionID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("POST", "/north/api/"+httpapi.API+"/auth", "", map[string]string{"username": "staff", "password": "password"})
+	var resp struct {
+		SessionID string `json:"session_id"`
+	}
+	if err = json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK || resp.SessionID == "" {
+		t.Fatalf("unable to log in: %d, %v", w.Code, err)
+	}
+
+	if w = do("GET", "/north/api/"+httpapi.API+"/users", resp.SessionID, nil); w.Code != http.StatusOK {
+		t.Errorf("expected %d from the tenant that created the session, got %d", http.StatusOK, w.Code)
+	}
+	if w = do("GET", "/south/api/"+httpapi.API+"/users", resp.SessionID, nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected %d from another tenant, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
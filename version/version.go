@@ -0,0 +1,5 @@
+// Package version holds the server's API version, so packages can refer to it without importing httpapi
+package version
+
+// API is the current API version
+const API = "3.0"
@@ -0,0 +1,365 @@
+"use strict";
+
+const API = "api/3.0/";
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+    session: JSON.parse(sessionStorage.getItem("session") || "null"),
+    mfaToken: null,
+    users: [],
+};
+
+class APIError extends Error {
+    constructor(status, message) {
+        super(message);
+        this.status = status;
+    }
+}
+
+// api sends a request to the API. Authenticated requests that fail with 401 end the session
+async function api(method, path, body, authenticated = true) {
+    const headers = { Accept: "application/json" };
+    if (body !== undefined) {
+        headers["Content-Type"] = "application/json";
+    }
+    if (authenticated && state.session) {
+        headers.Authorization = "Bearer " + state.session.session_id;
+    }
+
+    let resp;
+    try {
+        resp = await fetch(new URL(API + path, document.baseURI), {
+            method,
+            headers,
+            body: body === undefined ? undefined : JSON.stringify(body),
+        });
+    } catch (err) {
+        throw new APIError(0, "Unable to contact the server");
+    }
+
+    let data = null;
+    try {
+        data = await resp.json();
+    } catch (err) {
+        // non-JSON response
+    }
+
+    if (!resp.ok) {
+        if (authenticated && resp.status === 401) {
+            expire();
+        }
+        let message = resp.statusText;
+        if (data && data.error) {
+            message = data.error;
+        } else if (data && data.description) {
+            message = data.description + (data.debug ? ": " + data.debug : "");
+        }
+        throw new APIError(resp.status, message);
+    }
+
+    return data;
+}
+
+function showMessage(text, isError = true) {
+    const msg = $("message");
+    msg.textContent = text;
+    msg.className = isError ? "error" : "info";
+    msg.hidden = !text;
+}
+
+function show(id) {
+    for (const el of ["login", "mfa", "recovery", "students"]) {
+        $(el).hidden = el !== id;
+    }
+    $("user").hidden = !state.session;
+    if (state.session) {
+        $("display-name").textContent = (state.session.display_name || state.session.username) +
+            (state.session.admin ? " (administrator)" : "");
+    }
+}
+
+function setSession(resp) {
+    state.session = { username: resp.username, display_name: resp.display_name, session_id: resp.session_id, admin: resp.admin };
+    sessionStorage.setItem("session", JSON.stringify(state.session));
+}
+
+function clearSession() {
+    state.session = null;
+    state.users = [];
+    sessionStorage.removeItem("session");
+    $("grades").replaceChildren();
+}
+
+function expire() {
+    if (!state.session) {
+        return;
+    }
+    clearSession();
+    showLogin();
+    showMessage("Your session has expired. Please log in again.");
+}
+
+let config = null;
+
+async function showLogin() {
+    show("login");
+    $("login").reset();
+    if (config === null) {
+        try {
+            const resp = await fetch(new URL("config.json", document.baseURI));
+            config = await resp.json();
+        } catch (err) {
+            config = {};
+        }
+    }
+    $("sso").hidden = !config.oidc;
+}
+
+// completeLogin handles a login response, which may require a second factor
+async function completeLogin(resp) {
+    if (!resp.mfa_required) {
+        setSession(resp);
+        showMessage("");
+        await showStudents();
+        return;
+    }
+
+    state.mfaToken = resp.mfa_token;
+    $("mfa").reset();
+    $("mfa-qr").hidden = true;
+    $("mfa-help").textContent = "Enter the code from your authenticator app, or a recovery code.";
+
+    if (!resp.mfa_enrolled) {
+        const enrollment = await api("POST", "auth/mfa/enroll", { mfa_token: state.mfaToken }, false);
+        $("mfa-qr").src = enrollment.qr_code;
+        $("mfa-qr").hidden = false;
+        $("mfa-help").textContent = "Scan this code with your authenticator app (or enter the secret " +
+            enrollment.secret + "), then enter the code it shows.";
+    }
+
+    showMessage("");
+    show("mfa");
+}
+
+function gradeName(grade) {
+    switch (grade) {
+    case -1:
+        return "Pre-K";
+    case 0:
+        return "Kindergarten";
+    case 1:
+        return "1st Grade";
+    case 2:
+        return "2nd Grade";
+    case 3:
+        return "3rd Grade";
+    default:
+        return grade + "th Grade";
+    }
+}
+
+function renderStudents() {
+    const grades = new Map();
+    for (const user of state.users) {
+        if (!grades.has(user.grade)) {
+            grades.set(user.grade, []);
+        }
+        grades.get(user.grade).push(user);
+    }
+
+    const sections = [];
+    for (const [grade, users] of [...grades].sort((a, b) => a[0] - b[0])) {
+        const section = $("grade-template").content.firstElementChild.cloneNode(true);
+        section.querySelector("summary").textContent = gradeName(grade) + " (" + users.length + ")";
+        const tbody = section.querySelector("tbody");
+        for (const user of users) {
+            tbody.append(renderRow(user));
+        }
+        sections.push(section);
+    }
+
+    $("grades").replaceChildren(...sections);
+    if (sections.length === 0) {
+        const p = document.createElement("p");
+        p.textContent = "You don't have access to any students.";
+        $("grades").append(p);
+    }
+    filterStudents();
+}
+
+function renderRow(user) {
+    const row = $("row-template").content.firstElementChild.cloneNode(true);
+    row.dataset.search = [user.first_name, user.last_name, user.username].join(" ").toLowerCase();
+    row.querySelector(".last-name").textContent = user.last_name;
+    row.querySelector(".first-name").textContent = user.first_name;
+    row.querySelector(".username").textContent = user.username;
+
+    const masked = row.querySelector(".hidden-password");
+    const code = row.querySelector(".password code");
+    const reveal = row.querySelector(".reveal");
+    const copy = row.querySelector(".copy");
+
+    const setPassword = (password) => {
+        user.password = password;
+        code.textContent = password;
+        reveal.disabled = copy.disabled = !password;
+    };
+    const setRevealed = (revealed) => {
+        masked.hidden = revealed;
+        code.hidden = !revealed;
+        reveal.textContent = revealed ? "Hide" : "Reveal";
+    };
+    setPassword(user.password);
+
+    reveal.addEventListener("click", () => setRevealed(code.hidden));
+
+    copy.addEventListener("click", async () => {
+        try {
+            await navigator.clipboard.writeText(user.password);
+            copy.textContent = "Copied";
+            setTimeout(() => { copy.textContent = "Copy"; }, 1500);
+        } catch (err) {
+            showMessage("Unable to copy password: " + err.message);
+        }
+    });
+
+    row.querySelector(".reset").addEventListener("click", async (e) => {
+        if (!confirm("Reset the password for " + user.first_name + " " + user.last_name + " (" + user.username + ")?")) {
+            return;
+        }
+        e.target.disabled = true;
+        try {
+            const resp = await api("POST", "users/" + encodeURIComponent(user.username) + "/reset");
+            if (resp.approval && !resp.password) {
+                showMessage("Reset for " + user.username + " is waiting for approval by another user.", false);
+                return;
+            }
+            setPassword(resp.password);
+            setRevealed(true);
+            showMessage("Password reset for " + user.username + ".", false);
+        } catch (err) {
+            if (err.status !== 401) {
+                showMessage("Unable to reset password: " + err.message);
+            }
+        } finally {
+            e.target.disabled = false;
+        }
+    });
+
+    return row;
+}
+
+function filterStudents() {
+    const terms = $("search").value.toLowerCase().split(/\s+/).filter((t) => t);
+    for (const section of $("grades").querySelectorAll("details")) {
+        let visible = 0;
+        for (const row of section.querySelectorAll("tbody tr")) {
+            row.hidden = !terms.every((t) => row.dataset.search.includes(t));
+            if (!row.hidden) {
+                visible++;
+            }
+        }
+        section.hidden = visible === 0;
+        if (terms.length) {
+            section.open = true;
+        }
+    }
+}
+
+async function showStudents() {
+    show("students");
+    try {
+        state.users = (await api("GET", "users")) || [];
+        renderStudents();
+    } catch (err) {
+        if (err.status !== 401) {
+            showMessage("Unable to load students: " + err.message);
+        }
+    }
+}
+
+$("login").addEventListener("submit", async (e) => {
+    e.preventDefault();
+    const form = new FormData(e.target);
+    try {
+        const resp = await api("POST", "auth", { username: form.get("username"), password: form.get("password") }, false);
+        await completeLogin(resp);
+    } catch (err) {
+        showMessage(err.status === 401 ? "Invalid username or password." : "Unable to log in: " + err.message);
+    }
+});
+
+$("sso").addEventListener("click", async () => {
+    try {
+        const resp = await api("GET", "auth/oidc", undefined, false);
+        window.location.assign(resp.url);
+    } catch (err) {
+        showMessage("Unable to start single sign-on: " + err.message);
+    }
+});
+
+$("mfa").addEventListener("submit", async (e) => {
+    e.preventDefault();
+    const form = new FormData(e.target);
+    try {
+        const resp = await api("POST", "auth/mfa", { mfa_token: state.mfaToken, code: form.get("code").trim() }, false);
+        state.mfaToken = null;
+        setSession(resp);
+        showMessage("");
+        if (resp.recovery_codes && resp.recovery_codes.length) {
+            $("recovery-codes").textContent = resp.recovery_codes.join("\n");
+            show("recovery");
+            return;
+        }
+        await showStudents();
+    } catch (err) {
+        if (err.status === 401 && err.message.indexOf("MFA token") !== -1) {
+            await showLogin();
+            showMessage("Verification timed out. Please log in again.");
+            return;
+        }
+        showMessage(err.status === 401 ? "Invalid code." : "Unable to verify code: " + err.message);
+    }
+});
+
+$("recovery-done").addEventListener("click", () => showStudents());
+
+$("logout").addEventListener("click", () => {
+    clearSession();
+    showMessage("");
+    showLogin();
+});
+
+$("search").addEventListener("input", filterStudents);
+$("refresh").addEventListener("click", () => showStudents());
+
+// completeSSO finishes an OpenID Connect login if the identity provider redirected back to this page
+async function completeSSO() {
+    const params = new URLSearchParams(window.location.search);
+    if (!params.has("code") || !params.has("state")) {
+        return false;
+    }
+
+    window.history.replaceState(null, "", window.location.pathname);
+
+    try {
+        const resp = await api("POST", "auth/oidc", { state: params.get("state"), code: params.get("code") }, false);
+        await completeLogin(resp);
+    } catch (err) {
+        await showLogin();
+        showMessage("Unable to log in with single sign-on: " + err.message);
+    }
+    return true;
+}
+
+(async () => {
+    if (await completeSSO()) {
+        return;
+    }
+    if (state.session) {
+        await showStudents();
+    } else {
+        await showLogin();
+    }
+})();
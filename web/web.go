@@ -0,0 +1,47 @@
+// Package web is the built-in web frontend
+package web
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Config is the server configuration the frontend needs, served as config.json
+type Config struct {
+	// OIDC is true if OpenID Connect logins are enabled
+	OIDC bool `json:"oidc"`
+}
+
+// Handler returns an http.Handler serving the web frontend. The frontend expects the API to be served
+// from the same path, e.g. if the frontend is served at /prefix/, the API is at /prefix/api/3.0
+func Handler(config *Config) http.Handler {
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+
+	files := http.FileServer(http.FS(sub))
+
+	buf, err := json.Marshal(config)
+	if err != nil {
+		panic(err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self' data:; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.URL.Path == "/config.json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(buf)
+			return
+		}
+		files.ServeHTTP(w, r)
+	})
+}
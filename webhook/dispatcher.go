@@ -0,0 +1,214 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Endpoint is a URL events are delivered to
+type Endpoint struct {
+	URL string
+	// Events are the event types sent to the endpoint. If empty, all events are sent
+	Events []string
+}
+
+func (e *Endpoint) wants(typ string) bool {
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Config configures a Dispatcher
+type Config struct {
+	Endpoints []*Endpoint
+	// Secret is used to sign payloads
+	Secret []byte
+	// MaxAttempts is the number of attempts before a delivery is marked failed
+	MaxAttempts int
+	// Timeout is the timeout for each attempt
+	Timeout time.Duration
+	// Retention is how long finished deliveries are kept. If 0, deliveries are kept forever
+	Retention time.Duration
+}
+
+// Dispatcher queues events and delivers them to endpoints
+type Dispatcher struct {
+	config *Config
+	store  Store
+	client *http.Client
+	wake   chan struct{}
+}
+
+// New returns a new *Dispatcher. Run must be called to deliver events
+func New(config *Config, store Store) *Dispatcher {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = 1
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &Dispatcher{
+		config: config,
+		store:  store,
+		client: &http.Client{Timeout: config.Timeout},
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Store returns the Dispatcher's Store
+func (d *Dispatcher) Store() Store {
+	return d.store
+}
+
+// Notify queues e for delivery to every endpoint that wants it. ID and Time are set if empty
+func (d *Dispatcher) Notify(e *Event) error {
+	if e.ID == "" {
+		id, err := NewID()
+		if err != nil {
+			return fmt.Errorf("Unable to generate event ID: %v", err)
+		}
+		e.ID = id
+	}
+
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for _, endpoint := range d.config.Endpoints {
+		if !endpoint.wants(e.Type) {
+			continue
+		}
+
+		id, err := NewID()
+		if err != nil {
+			return fmt.Errorf("Unable to generate delivery ID: %v", err)
+		}
+
+		now := time.Now()
+		if err = d.store.Add(&Delivery{
+			ID:          id,
+			Event:       e,
+			URL:         endpoint.URL,
+			Status:      StatusPending,
+			Created:     now,
+			NextAttempt: now,
+		}); err != nil {
+			return fmt.Errorf("Unable to queue delivery: %v", err)
+		}
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// attempt sends the delivery once and returns the response code, or an error if the delivery failed
+func (d *Dispatcher) attempt(dl *Delivery) (int, error) {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to encode event: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("Unable to create request: %v", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "userbrowser-webhook")
+	req.Header.Set(HeaderEvent, dl.Event.Type)
+	req.Header.Set(HeaderDelivery, dl.ID)
+	req.Header.Set(HeaderSignature, Sign(d.config.Secret, time.Now(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Unexpected response: %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// deliver attempts every due delivery
+func (d *Dispatcher) deliver() {
+	due, err := d.store.Due(time.Now())
+	if err != nil {
+		log.Println("Unable to read webhook queue:", err)
+		return
+	}
+
+	for _, dl := range due {
+		code, err := d.attempt(dl)
+
+		dl.Attempts++
+		dl.LastAttempt = time.Now()
+		dl.ResponseCode = code
+		dl.Error = ""
+
+		switch {
+		case err == nil:
+			dl.Status = StatusDelivered
+			dl.NextAttempt = time.Time{}
+			deliveries.With(resultSuccess).Inc()
+		case dl.Attempts >= d.config.MaxAttempts:
+			dl.Status = StatusFailed
+			dl.Error = err.Error()
+			dl.NextAttempt = time.Time{}
+			deliveries.With(resultFailed).Inc()
+			log.Printf("Webhook delivery %s to %s failed after %d attempts: %v\n", dl.ID, dl.URL, dl.Attempts, err)
+		default:
+			dl.Error = err.Error()
+			dl.NextAttempt = dl.LastAttempt.Add(Backoff(dl.Attempts))
+			deliveries.With(resultRetry).Inc()
+		}
+
+		if err = d.store.Update(dl); err != nil {
+			log.Println("Unable to update webhook delivery:", err)
+		}
+	}
+}
+
+// Run delivers queued events until the process exits, checking for due deliveries every interval
+// and immediately after Notify is called
+func (d *Dispatcher) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+
+	for {
+		d.deliver()
+
+		if d.config.Retention > 0 && time.Since(lastPurge) > time.Hour {
+			lastPurge = time.Now()
+			if _, err := d.store.Purge(lastPurge.Add(-d.config.Retention)); err != nil {
+				log.Println("Unable to purge webhook deliveries:", err)
+			}
+		}
+
+		select {
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
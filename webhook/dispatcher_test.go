@@ -0,0 +1,250 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testStore struct {
+	mu         *sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+func newTestStore() *testStore {
+	return &testStore{mu: new(sync.Mutex), deliveries: make(map[string]*Delivery)}
+}
+
+func (s *testStore) Add(d *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dl := *d
+	s.deliveries[d.ID] = &dl
+	return nil
+}
+
+func (s *testStore) Due(t time.Time) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []*Delivery
+	for _, d := range s.deliveries {
+		if d.Status == StatusPending && !d.NextAttempt.After(t) {
+			dl := *d
+			due = append(due, &dl)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Created.Before(due[j].Created) })
+	return due, nil
+}
+
+func (s *testStore) Update(d *Delivery) error {
+	return s.Add(d)
+}
+
+func (s *testStore) List(status Status, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []*Delivery
+	for _, d := range s.deliveries {
+		if status == "" || d.Status == status {
+			dl := *d
+			list = append(list, &dl)
+		}
+	}
+	return list, nil
+}
+
+func (s *testStore) Purge(t time.Time) (int, error) {
+	return 0, nil
+}
+
+// receiver is an endpoint that verifies signatures and responds with the next status code in codes,
+// or 204 once codes are used up
+type receiver struct {
+	server *httptest.Server
+
+	mu       *sync.Mutex
+	codes    []int
+	requests []*http.Request
+	events   []*Event
+	errors   []error
+}
+
+func newReceiver(t *testing.T, secret []byte, codes ...int) *receiver {
+	t.Helper()
+	r := &receiver{mu: new(sync.Mutex), codes: codes}
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		body, err := io.ReadAll(req.Body)
+		if err == nil {
+			err = Verify(secret, req.Header.Get(HeaderSignature), body, time.Minute)
+		}
+		e := new(Event)
+		if err == nil {
+			err = json.Unmarshal(body, e)
+		}
+		r.requests = append(r.requests, req)
+		r.events = append(r.events, e)
+		r.errors = append(r.errors, err)
+
+		code := http.StatusNoContent
+		if len(r.codes) > 0 {
+			code, r.codes = r.codes[0], r.codes[1:]
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func only(t *testing.T, s Store) *Delivery {
+	t.Helper()
+	list, err := s.List("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected 1 delivery, got %d", len(list))
+	}
+	return list[0]
+}
+
+func TestDeliver(t *testing.T) {
+	secret := []byte("secret")
+	r := newReceiver(t, secret)
+	d := New(&Config{Endpoints: []*Endpoint{{URL: r.server.URL}}, Secret: secret}, newTestStore())
+
+	if err := d.Notify(&Event{Type: EventPasswordReset, Actor: "teacher", Target: "student"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	d.deliver()
+
+	if r.count() != 1 {
+		t.Fatalf("expected 1 request, got %d", r.count())
+	}
+	if r.errors[0] != nil {
+		t.Fatalf("receiver rejected delivery: %v", r.errors[0])
+	}
+
+	dl := only(t, d.store)
+	req, e := r.requests[0], r.events[0]
+	if req.Header.Get(HeaderEvent) != EventPasswordReset || req.Header.Get(HeaderDelivery) != dl.ID ||
+		req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected headers: %v", req.Header)
+	}
+	if e.ID == "" || e.Time.IsZero() || e.Type != EventPasswordReset || e.Actor != "teacher" || e.Target != "student" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if dl.Status != StatusDelivered || dl.Attempts != 1 || dl.ResponseCode != http.StatusNoContent || !dl.NextAttempt.IsZero() {
+		t.Errorf("unexpected delivery: %+v", dl)
+	}
+
+	// delivered events aren't sent again
+	d.deliver()
+	if r.count() != 1 {
+		t.Errorf("expected 1 request, got %d", r.count())
+	}
+}
+
+func TestDeliverRetry(t *testing.T) {
+	secret := []byte("secret")
+	r := newReceiver(t, secret, http.StatusInternalServerError, http.StatusBadGateway)
+	d := New(&Config{Endpoints: []*Endpoint{{URL: r.server.URL}}, Secret: secret, MaxAttempts: 5}, newTestStore())
+
+	if err := d.Notify(&Event{Type: EventPasswordReset}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		d.deliver()
+
+		dl := only(t, d.store)
+		if dl.Status != StatusPending || dl.Attempts != attempt || dl.ResponseCode < 500 || dl.Error == "" {
+			t.Fatalf("attempt %d: unexpected delivery: %+v", attempt, dl)
+		}
+		if !dl.NextAttempt.Equal(dl.LastAttempt.Add(Backoff(attempt))) {
+			t.Errorf("attempt %d: expected next attempt after %v, got %v", attempt, Backoff(attempt),
+				dl.NextAttempt.Sub(dl.LastAttempt))
+		}
+
+		// the delivery isn't retried until its backoff has passed
+		d.deliver()
+		if r.count() != attempt {
+			t.Fatalf("attempt %d: retried before backoff passed", attempt)
+		}
+
+		dl.NextAttempt = time.Now()
+		d.store.Update(dl)
+	}
+
+	d.deliver()
+	if dl := only(t, d.store); dl.Status != StatusDelivered || dl.Attempts != 3 || dl.Error != "" {
+		t.Errorf("unexpected delivery: %+v", dl)
+	}
+
+	// every attempt has the same delivery ID and a valid signature
+	for i, req := range r.requests {
+		if r.errors[i] != nil || req.Header.Get(HeaderDelivery) != r.requests[0].Header.Get(HeaderDelivery) {
+			t.Errorf("attempt %d: unexpected request: %v, %v", i+1, req.Header, r.errors[i])
+		}
+	}
+}
+
+func TestDeliverMaxAttempts(t *testing.T) {
+	secret := []byte("secret")
+	r := newReceiver(t, secret, http.StatusInternalServerError, http.StatusInternalServerError)
+	d := New(&Config{Endpoints: []*Endpoint{{URL: r.server.URL}}, Secret: secret, MaxAttempts: 2}, newTestStore())
+
+	if err := d.Notify(&Event{Type: EventPasswordReset}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	d.deliver()
+	dl := only(t, d.store)
+	dl.NextAttempt = time.Now()
+	d.store.Update(dl)
+	d.deliver()
+
+	if dl = only(t, d.store); dl.Status != StatusFailed || dl.Attempts != 2 || dl.Error == "" || !dl.NextAttempt.IsZero() {
+		t.Errorf("unexpected delivery: %+v", dl)
+	}
+}
+
+func TestNotifyEndpoints(t *testing.T) {
+	d := New(&Config{Endpoints: []*Endpoint{
+		{URL: "https://all.example.com"},
+		{URL: "https://resets.example.com", Events: []string{EventPasswordReset}},
+		{URL: "https://keys.example.com", Events: []string{EventAPIKeyCreated, EventAPIKeyRevoked}},
+	}}, newTestStore())
+
+	if err := d.Notify(&Event{Type: EventPasswordReset}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	list, _ := d.store.List(StatusPending, 0)
+	var urls []string
+	for _, dl := range list {
+		urls = append(urls, dl.URL)
+	}
+	sort.Strings(urls)
+
+	if len(urls) != 2 || urls[0] != "https://all.example.com" || urls[1] != "https://resets.example.com" {
+		t.Errorf("unexpected endpoints: %v", urls)
+	}
+	if list[0].Event.ID != list[1].Event.ID {
+		t.Error("deliveries of the same event have different event IDs")
+	}
+}
@@ -0,0 +1,182 @@
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+// Store represents a Store that persists deliveries to a JSON file
+type Store struct {
+	path string
+	mu   *sync.Mutex
+}
+
+// New returns a new *Store using the file at path
+func New(path string) *Store {
+	return &Store{path: path, mu: new(sync.Mutex)}
+}
+
+func (s *Store) read() (map[string]*webhook.Delivery, error) {
+	deliveries := make(map[string]*webhook.Delivery)
+
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return deliveries, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %v", s.path, err)
+	}
+	defer f.Close()
+
+	if err = json.NewDecoder(f).Decode(&deliveries); err != nil {
+		return nil, fmt.Errorf("Unable to decode %s: %v", s.path, err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *Store) write(deliveries map[string]*webhook.Delivery) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("Unable to create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = json.NewEncoder(tmp).Encode(deliveries); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Unable to encode deliveries: %v", err)
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("Unable to write temporary file: %v", err)
+	}
+
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("Unable to replace %s: %v", s.path, err)
+	}
+
+	return nil
+}
+
+// Add stores a new delivery or returns an error if one occurred
+func (s *Store) Add(d *webhook.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := deliveries[d.ID]; ok {
+		return fmt.Errorf("Delivery %s already exists", d.ID)
+	}
+
+	deliveries[d.ID] = d
+
+	return s.write(deliveries)
+}
+
+// Due returns pending deliveries due to be attempted at or before t, oldest first, or an error if one occurred
+func (s *Store) Due(t time.Time) ([]*webhook.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []*webhook.Delivery
+	for _, d := range deliveries {
+		if d.Status == webhook.StatusPending && !d.NextAttempt.After(t) {
+			due = append(due, d)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].Created.Before(due[j].Created)
+	})
+
+	return due, nil
+}
+
+// Update replaces the stored delivery with the same ID or returns an error if one occurred
+func (s *Store) Update(d *webhook.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := deliveries[d.ID]; !ok {
+		return errors.New("Delivery doesn't exist")
+	}
+
+	deliveries[d.ID] = d
+
+	return s.write(deliveries)
+}
+
+// List returns deliveries with the given status (or all if empty), newest first, up to limit (or all if 0),
+// or an error if one occurred
+func (s *Store) List(status webhook.Status, limit int) ([]*webhook.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*webhook.Delivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		if status == "" || d.Status == status {
+			list = append(list, d)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.After(list[j].Created)
+	})
+
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	return list, nil
+}
+
+// Purge removes finished deliveries created before t and returns the number removed, or an error if one occurred
+func (s *Store) Purge(t time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries, err := s.read()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for id, d := range deliveries {
+		if d.Status != webhook.StatusPending && d.Created.Before(t) {
+			delete(deliveries, id)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, s.write(deliveries)
+}
@@ -0,0 +1,123 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/korylprince/userbrowser-server/v3/webhook"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	s := New(path)
+
+	now := time.Now()
+	for i, status := range []webhook.Status{webhook.StatusPending, webhook.StatusPending, webhook.StatusDelivered} {
+		if err := s.Add(&webhook.Delivery{
+			ID:          string(rune('a' + i)),
+			Event:       &webhook.Event{ID: "event", Type: webhook.EventPasswordReset},
+			Status:      status,
+			Created:     now.Add(time.Duration(i) * time.Second),
+			NextAttempt: now.Add(time.Duration(i) * time.Second),
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := s.Add(&webhook.Delivery{ID: "a"}); err == nil {
+		t.Error("expected error for duplicate delivery")
+	}
+
+	due, err := s.Due(now.Add(time.Minute))
+	if err != nil || len(due) != 2 || due[0].ID != "a" || due[1].ID != "b" {
+		t.Fatalf("unexpected due deliveries: %v, %v", due, err)
+	}
+	if due, _ = s.Due(now); len(due) != 1 {
+		t.Errorf("expected 1 due delivery, got %d", len(due))
+	}
+
+	due[0].Status = webhook.StatusFailed
+	if err = s.Update(due[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = s.Update(&webhook.Delivery{ID: "missing"}); err == nil {
+		t.Error("expected error for missing delivery")
+	}
+
+	list, err := s.List("", 2)
+	if err != nil || len(list) != 2 || list[0].ID != "c" || list[1].ID != "b" {
+		t.Errorf("unexpected list: %v, %v", list, err)
+	}
+
+	// pending deliveries are never purged
+	if n, err := s.Purge(now.Add(time.Minute)); err != nil || n != 2 {
+		t.Errorf("expected 2 purged, got %d, %v", n, err)
+	}
+	if list, _ = s.List("", 0); len(list) != 1 || list[0].ID != "b" {
+		t.Errorf("unexpected list after purge: %v", list)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	secret := []byte("secret")
+
+	received := make(chan string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get(webhook.HeaderDelivery)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	config := func() *webhook.Config {
+		return &webhook.Config{Endpoints: []*webhook.Endpoint{{URL: srv.URL}}, Secret: secret}
+	}
+
+	// events queued by a process that exits before delivering them...
+	d := webhook.New(config(), New(path))
+	for i := 0; i < 2; i++ {
+		if err := d.Notify(&webhook.Event{Type: webhook.EventPasswordReset}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	queued, err := d.Store().List(webhook.StatusPending, 0)
+	if err != nil || len(queued) != 2 {
+		t.Fatalf("expected 2 queued deliveries, got %d, %v", len(queued), err)
+	}
+
+	// ...are delivered after a restart
+	s := New(path)
+	go webhook.New(config(), s).Run(time.Hour)
+
+	ids := make(map[string]bool)
+	for len(ids) < 2 {
+		select {
+		case id := <-received:
+			ids[id] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 2 deliveries, got %d", len(ids))
+		}
+	}
+	for _, dl := range queued {
+		if !ids[dl.ID] {
+			t.Errorf("delivery %s not replayed", dl.ID)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		delivered, err := s.List(webhook.StatusDelivered, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(delivered) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 2 delivered, got %d", len(delivered))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
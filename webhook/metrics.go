@@ -0,0 +1,12 @@
+package webhook
+
+import "github.com/korylprince/userbrowser-server/v3/metrics"
+
+const (
+	resultSuccess = "success"
+	resultRetry   = "retry"
+	resultFailed  = "failed"
+)
+
+var deliveries = metrics.NewCounterVec("userbrowser_webhook_deliveries_total",
+	"Number of webhook delivery attempts by result.", "result")
@@ -0,0 +1,165 @@
+// Package webhook delivers signed event notifications to HTTP endpoints
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types
+const (
+	EventPasswordReset      = "user.password_reset"
+	EventAPIKeyCreated      = "apikey.created"
+	EventAPIKeyRevoked      = "apikey.revoked"
+	EventMFAEnrolled        = "mfa.enrolled"
+	EventMFADisabled        = "mfa.disabled"
+	EventMFARecoveryCodes   = "mfa.recovery_codes_regenerated"
+	EventWebAuthnRegistered = "webauthn.registered"
+	EventWebAuthnDeleted    = "webauthn.deleted"
+	EventSessionDeleted     = "session.deleted"
+)
+
+// Headers sent with each delivery
+const (
+	HeaderEvent     = "X-Userbrowser-Event"
+	HeaderDelivery  = "X-Userbrowser-Delivery"
+	HeaderSignature = "X-Userbrowser-Signature"
+)
+
+// Event is the payload sent to endpoints. Events never contain passwords;
+// receivers should use the API with an API key to read a student's new password
+type Event struct {
+	ID     string    `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor,omitempty"`
+	APIKey string    `json:"api_key,omitempty"`
+	// Target is the student, API key, or user the event is about
+	Target string `json:"target,omitempty"`
+}
+
+// Status is the status of a Delivery
+type Status string
+
+// Delivery statuses
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	StatusFailed    Status = "failed"
+)
+
+// Delivery is an attempt to deliver an event to an endpoint
+type Delivery struct {
+	ID           string    `json:"id"`
+	Event        *Event    `json:"event"`
+	URL          string    `json:"url"`
+	Status       Status    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	Created      time.Time `json:"created"`
+	LastAttempt  time.Time `json:"last_attempt,omitempty"`
+	NextAttempt  time.Time `json:"next_attempt,omitempty"`
+	ResponseCode int       `json:"response_code,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// Store is a persistent delivery queue and history
+type Store interface {
+	// Add stores a new delivery or returns an error if one occurred
+	Add(d *Delivery) error
+	// Due returns pending deliveries due to be attempted at or before t, oldest first, or an error if one occurred
+	Due(t time.Time) ([]*Delivery, error)
+	// Update replaces the stored delivery with the same ID or returns an error if one occurred
+	Update(d *Delivery) error
+	// List returns deliveries with the given status (or all if empty), newest first, up to limit (or all if 0),
+	// or an error if one occurred
+	List(status Status, limit int) ([]*Delivery, error)
+	// Purge removes finished deliveries created before t and returns the number removed, or an error if one occurred
+	Purge(t time.Time) (int, error)
+}
+
+// NewID returns a new random ID
+func NewID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func mac(secret []byte, t int64, body []byte) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(strconv.FormatInt(t, 10)))
+	m.Write([]byte("."))
+	m.Write(body)
+	return m.Sum(nil)
+}
+
+// Sign returns the signature header value for body sent at t, in the format "t={unix time},v1={hex HMAC-SHA256}".
+// The HMAC is computed over "{unix time}.{body}"
+func Sign(secret []byte, t time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac(secret, t.Unix(), body)))
+}
+
+// Verify returns an error if signature isn't a valid signature for body,
+// or was created more than tolerance from now. A tolerance of 0 disables the time check
+func Verify(secret []byte, signature string, body []byte, tolerance time.Duration) error {
+	var (
+		t   int64 = -1
+		sig []byte
+	)
+
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			var err error
+			if t, err = strconv.ParseInt(kv[1], 10, 64); err != nil {
+				return fmt.Errorf("Invalid timestamp: %v", err)
+			}
+		case "v1":
+			var err error
+			if sig, err = hex.DecodeString(kv[1]); err != nil {
+				return fmt.Errorf("Invalid signature encoding: %v", err)
+			}
+		}
+	}
+
+	if t < 0 || sig == nil {
+		return errors.New("Malformed signature")
+	}
+
+	if !hmac.Equal(sig, mac(secret, t, body)) {
+		return errors.New("Signature mismatch")
+	}
+
+	if tolerance > 0 {
+		if d := time.Since(time.Unix(t, 0)); d > tolerance || d < -tolerance {
+			return errors.New("Signature timestamp outside tolerance")
+		}
+	}
+
+	return nil
+}
+
+// Backoff returns how long to wait before the next attempt after the given number of failed attempts:
+// 30 seconds doubling each attempt, up to 6 hours
+func Backoff(attempts int) time.Duration {
+	d := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= 6*time.Hour {
+			return 6 * time.Hour
+		}
+	}
+	return d
+}
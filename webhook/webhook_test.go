@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"1","type":"user.password_reset"}`)
+	now := time.Now()
+
+	sig := Sign(secret, now, body)
+	if err := Verify(secret, sig, body, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// receivers compute the HMAC-SHA256 of "{unix time}.{body}" themselves
+	if s := Sign(secret, time.Unix(1700000000, 0), []byte("body")); s !=
+		"t=1700000000,v1=42ac6f0448c1d9c3e1e82b9726248f58fef84afffcbad5188246e96070e0ea46" {
+		t.Errorf("unexpected signature: %s", s)
+	}
+
+	cases := map[string]struct {
+		secret []byte
+		sig    string
+		body   []byte
+	}{
+		"wrong secret":      {[]byte("other"), sig, body},
+		"modified body":     {secret, sig, append(body, ' ')},
+		"other timestamp":   {secret, strings.Replace(sig, "t=", "t=1", 1), body},
+		"missing timestamp": {secret, sig[strings.Index(sig, ",")+1:], body},
+		"missing signature": {secret, sig[:strings.Index(sig, ",")], body},
+		"bad encoding":      {secret, sig + "x", body},
+		"empty":             {secret, "", body},
+	}
+	for name, c := range cases {
+		if err := Verify(c.secret, c.sig, c.body, time.Minute); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	old := Sign(secret, now.Add(-time.Hour), body)
+	if err := Verify(secret, old, body, time.Minute); err == nil {
+		t.Error("expected error for old signature")
+	}
+	if err := Verify(secret, old, body, 0); err != nil {
+		t.Errorf("unexpected error with time check disabled: %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		10: 256 * time.Minute,
+		11: 6 * time.Hour,
+		50: 6 * time.Hour,
+	} {
+		if d := Backoff(attempts); d != want {
+			t.Errorf("%d attempts: expected %v, got %v", attempts, want, d)
+		}
+	}
+}